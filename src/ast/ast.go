@@ -3,12 +3,15 @@ package ast
 import (
 	"MyCompiler/src/token"
 	"bytes"
+	"sort"
 	"strings"
 )
 
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position // 节点第一个字符的位置
+	End() token.Position // 节点之后第一个字符的位置
 }
 
 // region statement
@@ -49,6 +52,40 @@ type ExpressionStatement struct {
 type BlockStatement struct {
 	Token      token.Token // 词法单元是 {
 	Statements []Statement
+	RBrace     token.Token // 右花括号
+}
+
+// WhileStatement statement while循环 条件为真时重复执行循环体
+type WhileStatement struct {
+	Token     token.Token // 词法单元是 while
+	Condition Expression
+	Body      *BlockStatement
+}
+
+// ForStatement statement for循环 for (Init; Condition; Update) Body
+// Init和Update是不带分号的let语句或表达式语句 三个部分都可以省略，省略Condition时一直循环
+type ForStatement struct {
+	Token     token.Token // 词法单元是 for
+	Init      Statement
+	Condition Expression
+	Update    Statement
+	Body      *BlockStatement
+}
+
+// BreakStatement statement 跳出最内层的循环
+type BreakStatement struct {
+	Token token.Token
+}
+
+// ContinueStatement statement 跳到最内层循环的下一次迭代 for循环会先执行Update
+type ContinueStatement struct {
+	Token token.Token
+}
+
+// BadStatement 有语法错误的语句 语法分析器从From跳到To之后继续分析
+type BadStatement struct {
+	From token.Token // 语句的第一个词法单元
+	To   token.Token // 跳过的最后一个词法单元
 }
 
 // endregion
@@ -72,12 +109,27 @@ type IntegerLiteral struct {
 	Value int64
 }
 
+// FloatLiteral expression 浮点数字面量表达式
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
 // StringLiteral expression 字符串字面量
 type StringLiteral struct {
 	Token token.Token
 	Value string
 }
 
+// TemplateLiteral expression 模板字符串 "a ${x} b"
+// Strings比Values多一个: Strings[0] Values[0] Strings[1] ... Strings[n]
+type TemplateLiteral struct {
+	Token   token.Token // 词法单元是 TEMPLATE_HEAD
+	Strings []string
+	Values  []Expression
+	Tail    token.Token // 词法单元是 TEMPLATE_TAIL
+}
+
 // BooleanLiteral expression 布尔字面量表达式
 type BooleanLiteral struct {
 	Token token.Token
@@ -88,19 +140,22 @@ type BooleanLiteral struct {
 type ArrayLiteral struct {
 	Token    token.Token
 	Elements []Expression
+	RBracket token.Token // 右方括号
 }
 
 // HashLiteral expression 哈希字面量
 type HashLiteral struct {
-	Token token.Token
-	Pairs map[Expression]Expression
+	Token  token.Token
+	Pairs  map[Expression]Expression
+	RBrace token.Token // 右花括号
 }
 
 // IndexExpression expression 数组索引表达式
 type IndexExpression struct {
-	Token token.Token
-	Left  Expression
-	Index Expression
+	Token    token.Token // 词法单元是 [
+	Left     Expression
+	Index    Expression
+	RBracket token.Token // 右方括号
 }
 
 // PrefixExpression expression 前缀表达式
@@ -131,6 +186,7 @@ type FnExpression struct {
 	Token      token.Token // 词法单元是 fn
 	Parameters []*Identifier
 	Body       *BlockStatement
+	Name       string // 通过let绑定的函数名 匿名函数为空
 }
 
 // CallExpression expression 调用函数表达式
@@ -138,6 +194,7 @@ type CallExpression struct {
 	Token     token.Token // 词法单元是 '('
 	Function  Expression  // 标识符或者是函数表达式
 	Arguments []Expression
+	RParen    token.Token // 右括号
 }
 
 // endregion
@@ -219,6 +276,12 @@ func (i *IntegerLiteral) String() string { return i.Token.Literal }
 
 func (i *IntegerLiteral) expressionNode() {}
 
+func (f *FloatLiteral) TokenLiteral() string { return f.Token.Literal }
+
+func (f *FloatLiteral) String() string { return f.Token.Literal }
+
+func (f *FloatLiteral) expressionNode() {}
+
 func (b *BooleanLiteral) TokenLiteral() string { return b.Token.Literal }
 
 func (b *BooleanLiteral) String() string { return b.Token.Literal }
@@ -231,6 +294,27 @@ func (s *StringLiteral) String() string { return s.Token.Literal }
 
 func (s *StringLiteral) expressionNode() {}
 
+func (t *TemplateLiteral) TokenLiteral() string { return t.Token.Literal }
+
+func (t *TemplateLiteral) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(`"`)
+	for i, s := range t.Strings {
+		out.WriteString(s)
+		if i < len(t.Values) {
+			out.WriteString("${")
+			out.WriteString(t.Values[i].String())
+			out.WriteString("}")
+		}
+	}
+	out.WriteString(`"`)
+
+	return out.String()
+}
+
+func (t *TemplateLiteral) expressionNode() {}
+
 func (p *PrefixExpression) TokenLiteral() string { return p.Token.Literal }
 
 func (p *PrefixExpression) String() string {
@@ -293,6 +377,68 @@ func (b *BlockStatement) String() string {
 
 func (b *BlockStatement) statementNode() {}
 
+func (w *WhileStatement) TokenLiteral() string { return w.Token.Literal }
+
+func (w *WhileStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("while")
+	out.WriteString(w.Condition.String())
+	out.WriteString(" ")
+	out.WriteString(w.Body.String())
+
+	return out.String()
+}
+
+func (w *WhileStatement) statementNode() {}
+
+func (f *ForStatement) TokenLiteral() string { return f.Token.Literal }
+
+func (f *ForStatement) String() string {
+	var out bytes.Buffer
+
+	// let语句的String带分号 头部的各部分统一去掉
+	clause := func(s Statement) string {
+		if s == nil {
+			return ""
+		}
+		return strings.TrimSuffix(s.String(), ";")
+	}
+
+	out.WriteString("for (")
+	out.WriteString(clause(f.Init))
+	out.WriteString("; ")
+	if f.Condition != nil {
+		out.WriteString(f.Condition.String())
+	}
+	out.WriteString("; ")
+	out.WriteString(clause(f.Update))
+	out.WriteString(") ")
+	out.WriteString(f.Body.String())
+
+	return out.String()
+}
+
+func (f *ForStatement) statementNode() {}
+
+func (b *BreakStatement) TokenLiteral() string { return b.Token.Literal }
+
+func (b *BreakStatement) String() string { return b.Token.Literal + ";" }
+
+func (b *BreakStatement) statementNode() {}
+
+func (c *ContinueStatement) TokenLiteral() string { return c.Token.Literal }
+
+func (c *ContinueStatement) String() string { return c.Token.Literal + ";" }
+
+func (c *ContinueStatement) statementNode() {}
+
+func (b *BadStatement) TokenLiteral() string { return b.From.Literal }
+
+func (b *BadStatement) String() string { return "<bad statement>" }
+
+func (b *BadStatement) statementNode() {}
+
 func (f *FnExpression) TokenLiteral() string { return f.Token.Literal }
 
 func (f *FnExpression) String() string {
@@ -356,8 +502,8 @@ func (h *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	var pairs []string
-	for key, value := range h.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, key := range h.Keys() {
+		pairs = append(pairs, key.String()+":"+h.Pairs[key].String())
 	}
 
 	out.WriteString("{")
@@ -369,6 +515,22 @@ func (h *HashLiteral) String() string {
 
 func (h *HashLiteral) expressionNode() {}
 
+// Keys 按源码中的顺序返回哈希字面量的键 没有位置时按字符串形式排序，保证顺序稳定
+func (h *HashLiteral) Keys() []Expression {
+	keys := make([]Expression, 0, len(h.Pairs))
+	for k := range h.Pairs {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		pi, pj := keys[i].Pos(), keys[j].Pos()
+		if pi.Offset != pj.Offset {
+			return pi.Offset < pj.Offset
+		}
+		return keys[i].String() < keys[j].String()
+	})
+	return keys
+}
+
 func (a *ArrayLiteral) expressionNode() {}
 
 func (i *IndexExpression) TokenLiteral() string { return i.Token.Literal }
@@ -386,3 +548,157 @@ func (i *IndexExpression) String() string {
 }
 
 func (i *IndexExpression) expressionNode() {}
+
+// region 位置
+
+// 子节点可能因为语法错误为nil，这时退回到fallback
+func endOf(n Node, fallback token.Position) token.Position {
+	if n == nil {
+		return fallback
+	}
+	return n.End()
+}
+
+func (p *Program) Pos() token.Position {
+	if len(p.Statement) > 0 {
+		return p.Statement[0].Pos()
+	}
+	return token.Position{}
+}
+
+func (p *Program) End() token.Position {
+	if len(p.Statement) > 0 {
+		return p.Statement[len(p.Statement)-1].End()
+	}
+	return token.Position{}
+}
+
+func (l *LetStatement) Pos() token.Position { return l.Token.Pos }
+
+func (l *LetStatement) End() token.Position {
+	if l.Name == nil {
+		return l.Token.End
+	}
+	return endOf(l.Value, l.Name.End())
+}
+
+func (r *ReturnStatement) Pos() token.Position { return r.Token.Pos }
+
+func (r *ReturnStatement) End() token.Position { return endOf(r.ReturnValue, r.Token.End) }
+
+func (e *ExpressionStatement) Pos() token.Position { return e.Token.Pos }
+
+func (e *ExpressionStatement) End() token.Position { return endOf(e.Expression, e.Token.End) }
+
+func (b *BlockStatement) Pos() token.Position { return b.Token.Pos }
+
+func (b *BlockStatement) End() token.Position {
+	if b.RBrace.End.IsValid() {
+		return b.RBrace.End
+	}
+	if len(b.Statements) > 0 {
+		return b.Statements[len(b.Statements)-1].End()
+	}
+	return b.Token.End
+}
+
+func (w *WhileStatement) Pos() token.Position { return w.Token.Pos }
+
+func (w *WhileStatement) End() token.Position {
+	if w.Body != nil {
+		return w.Body.End()
+	}
+	return endOf(w.Condition, w.Token.End)
+}
+
+func (f *ForStatement) Pos() token.Position { return f.Token.Pos }
+
+func (f *ForStatement) End() token.Position {
+	if f.Body != nil {
+		return f.Body.End()
+	}
+	return f.Token.End
+}
+
+func (b *BreakStatement) Pos() token.Position { return b.Token.Pos }
+
+func (b *BreakStatement) End() token.Position { return b.Token.End }
+
+func (c *ContinueStatement) Pos() token.Position { return c.Token.Pos }
+
+func (c *ContinueStatement) End() token.Position { return c.Token.End }
+
+func (b *BadStatement) Pos() token.Position { return b.From.Pos }
+
+func (b *BadStatement) End() token.Position { return b.To.End }
+
+func (i *Identifier) Pos() token.Position { return i.Token.Pos }
+
+func (i *Identifier) End() token.Position { return i.Token.End }
+
+func (i *IntegerLiteral) Pos() token.Position { return i.Token.Pos }
+
+func (i *IntegerLiteral) End() token.Position { return i.Token.End }
+
+func (f *FloatLiteral) Pos() token.Position { return f.Token.Pos }
+
+func (f *FloatLiteral) End() token.Position { return f.Token.End }
+
+func (s *StringLiteral) Pos() token.Position { return s.Token.Pos }
+
+func (s *StringLiteral) End() token.Position { return s.Token.End }
+
+func (t *TemplateLiteral) Pos() token.Position { return t.Token.Pos }
+
+func (t *TemplateLiteral) End() token.Position { return t.Tail.End }
+
+func (b *BooleanLiteral) Pos() token.Position { return b.Token.Pos }
+
+func (b *BooleanLiteral) End() token.Position { return b.Token.End }
+
+func (a *ArrayLiteral) Pos() token.Position { return a.Token.Pos }
+
+func (a *ArrayLiteral) End() token.Position { return a.RBracket.End }
+
+func (h *HashLiteral) Pos() token.Position { return h.Token.Pos }
+
+func (h *HashLiteral) End() token.Position { return h.RBrace.End }
+
+func (i *IndexExpression) Pos() token.Position { return i.Left.Pos() }
+
+func (i *IndexExpression) End() token.Position { return i.RBracket.End }
+
+func (p *PrefixExpression) Pos() token.Position { return p.Token.Pos }
+
+func (p *PrefixExpression) End() token.Position { return endOf(p.Right, p.Token.End) }
+
+func (i *InfixExpression) Pos() token.Position { return i.Left.Pos() }
+
+func (i *InfixExpression) End() token.Position { return endOf(i.Right, i.Token.End) }
+
+func (i *IfExpression) Pos() token.Position { return i.Token.Pos }
+
+func (i *IfExpression) End() token.Position {
+	if i.Alternative != nil {
+		return i.Alternative.End()
+	}
+	if i.Consequence != nil {
+		return i.Consequence.End()
+	}
+	return endOf(i.Condition, i.Token.End)
+}
+
+func (f *FnExpression) Pos() token.Position { return f.Token.Pos }
+
+func (f *FnExpression) End() token.Position {
+	if f.Body != nil {
+		return f.Body.End()
+	}
+	return f.Token.End
+}
+
+func (c *CallExpression) Pos() token.Position { return c.Function.Pos() }
+
+func (c *CallExpression) End() token.Position { return c.RParen.End }
+
+// endregion
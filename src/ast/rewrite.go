@@ -0,0 +1,341 @@
+package ast
+
+import "fmt"
+
+// ApplyFunc Apply对每个节点调用的函数
+type ApplyFunc func(*Cursor) bool
+
+// Apply 深度优先遍历语法树，可以在遍历的同时替换、删除和插入节点
+//
+// 对每个节点先调用pre(c)，再遍历子节点，最后调用post(c)
+// pre返回false时跳过这个节点的子节点和post；post返回false时整个遍历立即结束
+// pre和post可以为nil
+//
+// 替换后的新节点不会再被遍历(pre中替换时会遍历新节点的子节点)
+// 插入的节点不会被遍历
+// 返回遍历之后的根节点 根节点本身可能被替换
+func Apply(root Node, pre, post ApplyFunc) Node {
+	result := root
+	a := &application{pre: pre, post: post}
+	a.apply(&Cursor{
+		name:    "Root",
+		index:   -1,
+		node:    root,
+		replace: func(n Node) { result = n },
+	})
+	return result
+}
+
+// Rewrite 自底向上地用f(node)的返回值替换每个节点 返回新的根节点
+// 子节点已经替换完之后才对父节点调用f；f原样返回节点表示不替换
+func Rewrite(root Node, f func(Node) Node) Node {
+	return Apply(root, nil, func(c *Cursor) bool {
+		if n := f(c.Node()); n != c.Node() {
+			c.Replace(n)
+		}
+		return true
+	})
+}
+
+// Cursor 描述Apply当前访问的节点和它在父节点中的位置
+type Cursor struct {
+	parent Node
+	name   string
+	index  int // 在父节点的列表字段中的下标 不在列表中时为-1
+	node   Node
+
+	replace func(Node)
+	// 只有列表中的节点才有
+	remove       func()
+	insertBefore func(Node)
+	insertAfter  func(Node)
+}
+
+// Node 返回当前节点
+func (c *Cursor) Node() Node { return c.node }
+
+// Parent 返回当前节点的父节点 根节点的父节点为nil
+func (c *Cursor) Parent() Node { return c.parent }
+
+// Name 返回当前节点在父节点中的字段名，如 "Left" "Statements"
+// 哈希字面量的键和值分别为 "Pairs.Key" "Pairs.Value"，根节点为 "Root"
+func (c *Cursor) Name() string { return c.name }
+
+// Index 当前节点在列表字段中时返回下标，否则返回-1
+// 在遍历过程中删除或插入节点后，下标是当前节点现在的位置
+func (c *Cursor) Index() int { return c.index }
+
+// Replace 用n替换当前节点 n的类型必须能放进父节点的字段，否则panic
+func (c *Cursor) Replace(n Node) {
+	c.replace(n)
+	c.node = n
+}
+
+// Delete 从父节点的列表字段中删除当前节点
+// 对哈希字面量的键调用时删除整个键值对；不在列表中的节点调用时panic
+func (c *Cursor) Delete() {
+	if c.remove == nil {
+		panic(fmt.Sprintf("ast.Cursor.Delete: %s is not a list element", c.name))
+	}
+	c.remove()
+}
+
+// InsertBefore 在当前节点之前插入n 只能用于列表字段中的节点
+func (c *Cursor) InsertBefore(n Node) {
+	if c.insertBefore == nil {
+		panic(fmt.Sprintf("ast.Cursor.InsertBefore: %s is not a list element", c.name))
+	}
+	c.insertBefore(n)
+}
+
+// InsertAfter 在当前节点之后插入n 只能用于列表字段中的节点
+func (c *Cursor) InsertAfter(n Node) {
+	if c.insertAfter == nil {
+		panic(fmt.Sprintf("ast.Cursor.InsertAfter: %s is not a list element", c.name))
+	}
+	c.insertAfter(n)
+}
+
+type application struct {
+	pre, post ApplyFunc
+	stopped   bool // post返回了false
+}
+
+// 访问cursor指向的节点
+func (a *application) apply(c *Cursor) {
+	if a.stopped || isNilNode(c.node) {
+		return
+	}
+	if a.pre != nil && !a.pre(c) {
+		return
+	}
+	// pre中可能替换或删除了节点
+	if !isNilNode(c.node) {
+		a.children(c.node)
+	}
+	if a.stopped {
+		return
+	}
+	if a.post != nil && !a.post(c) {
+		a.stopped = true
+	}
+}
+
+// 访问一个不在列表中的子节点 set把替换后的节点写回父节点的字段
+func (a *application) field(parent Node, name string, node Node, set func(Node)) {
+	a.apply(&Cursor{parent: parent, name: name, index: -1, node: node, replace: set})
+}
+
+func (a *application) children(node Node) {
+	switch n := node.(type) {
+	case *Program:
+		a.statements(n, "Statement", &n.Statement)
+	case *LetStatement:
+		a.field(n, "Name", n.Name, func(x Node) { n.Name = toIdentifier(x) })
+		a.field(n, "Value", n.Value, func(x Node) { n.Value = toExpression(x) })
+	case *ReturnStatement:
+		a.field(n, "ReturnValue", n.ReturnValue, func(x Node) { n.ReturnValue = toExpression(x) })
+	case *ExpressionStatement:
+		a.field(n, "Expression", n.Expression, func(x Node) { n.Expression = toExpression(x) })
+	case *BlockStatement:
+		a.statements(n, "Statements", &n.Statements)
+	case *WhileStatement:
+		a.field(n, "Condition", n.Condition, func(x Node) { n.Condition = toExpression(x) })
+		a.field(n, "Body", n.Body, func(x Node) { n.Body = toBlock(x) })
+	case *ForStatement:
+		a.field(n, "Init", n.Init, func(x Node) { n.Init = toStatement(x) })
+		a.field(n, "Condition", n.Condition, func(x Node) { n.Condition = toExpression(x) })
+		a.field(n, "Update", n.Update, func(x Node) { n.Update = toStatement(x) })
+		a.field(n, "Body", n.Body, func(x Node) { n.Body = toBlock(x) })
+	case *TemplateLiteral:
+		// 删除或插入插值会破坏Strings和Values的对应关系 只允许替换
+		for i := range n.Values {
+			i := i
+			a.apply(&Cursor{parent: n, name: "Values", index: i, node: n.Values[i],
+				replace: func(x Node) { n.Values[i] = toExpression(x) }})
+		}
+	case *ArrayLiteral:
+		a.expressions(n, "Elements", &n.Elements)
+	case *HashLiteral:
+		a.pairs(n)
+	case *IndexExpression:
+		a.field(n, "Left", n.Left, func(x Node) { n.Left = toExpression(x) })
+		a.field(n, "Index", n.Index, func(x Node) { n.Index = toExpression(x) })
+	case *PrefixExpression:
+		a.field(n, "Right", n.Right, func(x Node) { n.Right = toExpression(x) })
+	case *InfixExpression:
+		a.field(n, "Left", n.Left, func(x Node) { n.Left = toExpression(x) })
+		a.field(n, "Right", n.Right, func(x Node) { n.Right = toExpression(x) })
+	case *IfExpression:
+		a.field(n, "Condition", n.Condition, func(x Node) { n.Condition = toExpression(x) })
+		a.field(n, "Consequence", n.Consequence, func(x Node) { n.Consequence = toBlock(x) })
+		a.field(n, "Alternative", n.Alternative, func(x Node) { n.Alternative = toBlock(x) })
+	case *FnExpression:
+		a.identifiers(n, "Parameters", &n.Parameters)
+		a.field(n, "Body", n.Body, func(x Node) { n.Body = toBlock(x) })
+	case *CallExpression:
+		a.field(n, "Function", n.Function, func(x Node) { n.Function = toExpression(x) })
+		a.expressions(n, "Arguments", &n.Arguments)
+	case *BadStatement, *BreakStatement, *ContinueStatement,
+		*Identifier, *IntegerLiteral, *FloatLiteral, *StringLiteral, *BooleanLiteral:
+		// 没有子节点
+	default:
+		panic(fmt.Sprintf("ast.Apply: unexpected node type %T", node))
+	}
+}
+
+// region 列表
+
+// 遍历列表时的位置 删除或插入节点后调整
+type iterator struct {
+	index int // 当前节点的下标
+	step  int // 访问完当前节点后前进的距离
+}
+
+// 为列表中的第it.index个元素创建Cursor
+// set del ins 分别在列表中替换、删除和插入元素
+func (a *application) element(parent Node, name string, it *iterator, node Node,
+	set func(int, Node), del func(int), ins func(int, Node)) {
+	c := &Cursor{parent: parent, name: name, index: it.index, node: node}
+	c.replace = func(x Node) { set(it.index, x) }
+	c.remove = func() {
+		del(it.index)
+		it.step--
+	}
+	c.insertBefore = func(x Node) {
+		ins(it.index, x)
+		it.index++
+		c.index = it.index
+	}
+	c.insertAfter = func(x Node) {
+		ins(it.index+1, x)
+		it.step++
+	}
+	a.apply(c)
+}
+
+func (a *application) statements(parent Node, name string, list *[]Statement) {
+	it := &iterator{}
+	for it.index = 0; it.index < len(*list) && !a.stopped; it.index += it.step {
+		it.step = 1
+		a.element(parent, name, it, (*list)[it.index],
+			func(i int, x Node) { (*list)[i] = toStatement(x) },
+			func(i int) { *list = append((*list)[:i], (*list)[i+1:]...) },
+			func(i int, x Node) {
+				*list = append(*list, nil)
+				copy((*list)[i+1:], (*list)[i:])
+				(*list)[i] = toStatement(x)
+			})
+	}
+}
+
+func (a *application) expressions(parent Node, name string, list *[]Expression) {
+	it := &iterator{}
+	for it.index = 0; it.index < len(*list) && !a.stopped; it.index += it.step {
+		it.step = 1
+		a.element(parent, name, it, (*list)[it.index],
+			func(i int, x Node) { (*list)[i] = toExpression(x) },
+			func(i int) { *list = append((*list)[:i], (*list)[i+1:]...) },
+			func(i int, x Node) {
+				*list = append(*list, nil)
+				copy((*list)[i+1:], (*list)[i:])
+				(*list)[i] = toExpression(x)
+			})
+	}
+}
+
+func (a *application) identifiers(parent Node, name string, list *[]*Identifier) {
+	it := &iterator{}
+	for it.index = 0; it.index < len(*list) && !a.stopped; it.index += it.step {
+		it.step = 1
+		a.element(parent, name, it, (*list)[it.index],
+			func(i int, x Node) { (*list)[i] = toIdentifier(x) },
+			func(i int) { *list = append((*list)[:i], (*list)[i+1:]...) },
+			func(i int, x Node) {
+				*list = append(*list, nil)
+				copy((*list)[i+1:], (*list)[i:])
+				(*list)[i] = toIdentifier(x)
+			})
+	}
+}
+
+// 哈希字面量按键在源码中的顺序遍历 先键后值
+// 替换键时保留原来的值；删除键时删除整个键值对；不支持插入
+func (a *application) pairs(h *HashLiteral) {
+	for i, key := range h.Keys() {
+		if a.stopped {
+			return
+		}
+		key := key
+		c := &Cursor{parent: h, name: "Pairs.Key", index: i, node: key}
+		c.replace = func(x Node) {
+			value := h.Pairs[key]
+			delete(h.Pairs, key)
+			key = toExpression(x)
+			h.Pairs[key] = value
+		}
+		removed := false
+		c.remove = func() {
+			delete(h.Pairs, key)
+			removed = true
+		}
+		a.apply(c)
+		if removed || a.stopped {
+			continue
+		}
+
+		a.apply(&Cursor{parent: h, name: "Pairs.Value", index: i, node: h.Pairs[key],
+			replace: func(x Node) { h.Pairs[key] = toExpression(x) }})
+	}
+}
+
+// endregion
+
+// region 类型转换
+
+// 把替换用的节点转换成字段的类型 类型不对时panic
+func toStatement(n Node) Statement {
+	if n == nil {
+		return nil
+	}
+	s, ok := n.(Statement)
+	if !ok {
+		panic(fmt.Sprintf("ast.Cursor: %T is not a Statement", n))
+	}
+	return s
+}
+
+func toExpression(n Node) Expression {
+	if n == nil {
+		return nil
+	}
+	e, ok := n.(Expression)
+	if !ok {
+		panic(fmt.Sprintf("ast.Cursor: %T is not an Expression", n))
+	}
+	return e
+}
+
+func toIdentifier(n Node) *Identifier {
+	if n == nil {
+		return nil
+	}
+	i, ok := n.(*Identifier)
+	if !ok {
+		panic(fmt.Sprintf("ast.Cursor: %T is not an *Identifier", n))
+	}
+	return i
+}
+
+func toBlock(n Node) *BlockStatement {
+	if n == nil {
+		return nil
+	}
+	b, ok := n.(*BlockStatement)
+	if !ok {
+		panic(fmt.Sprintf("ast.Cursor: %T is not a *BlockStatement", n))
+	}
+	return b
+}
+
+// endregion
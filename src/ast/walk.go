@@ -0,0 +1,122 @@
+package ast
+
+import "fmt"
+
+// Visitor 遍历语法树时对每个节点调用Visit
+// 返回的w不为nil时用w继续遍历节点的子节点，最后再调用一次w.Visit(nil)
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk 深度优先遍历语法树 子节点按源码中的顺序访问
+// 为nil的子节点(如没有else的if)会跳过
+func Walk(v Visitor, node Node) {
+	if isNilNode(node) {
+		return
+	}
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		for _, s := range n.Statement {
+			Walk(v, s)
+		}
+	case *LetStatement:
+		Walk(v, n.Name)
+		Walk(v, n.Value)
+	case *ReturnStatement:
+		Walk(v, n.ReturnValue)
+	case *ExpressionStatement:
+		Walk(v, n.Expression)
+	case *BlockStatement:
+		for _, s := range n.Statements {
+			Walk(v, s)
+		}
+	case *WhileStatement:
+		Walk(v, n.Condition)
+		Walk(v, n.Body)
+	case *ForStatement:
+		Walk(v, n.Init)
+		Walk(v, n.Condition)
+		Walk(v, n.Update)
+		Walk(v, n.Body)
+	case *TemplateLiteral:
+		for _, e := range n.Values {
+			Walk(v, e)
+		}
+	case *ArrayLiteral:
+		for _, e := range n.Elements {
+			Walk(v, e)
+		}
+	case *HashLiteral:
+		for _, key := range n.Keys() {
+			Walk(v, key)
+			Walk(v, n.Pairs[key])
+		}
+	case *IndexExpression:
+		Walk(v, n.Left)
+		Walk(v, n.Index)
+	case *PrefixExpression:
+		Walk(v, n.Right)
+	case *InfixExpression:
+		Walk(v, n.Left)
+		Walk(v, n.Right)
+	case *IfExpression:
+		Walk(v, n.Condition)
+		Walk(v, n.Consequence)
+		Walk(v, n.Alternative)
+	case *FnExpression:
+		for _, p := range n.Parameters {
+			Walk(v, p)
+		}
+		Walk(v, n.Body)
+	case *CallExpression:
+		Walk(v, n.Function)
+		for _, e := range n.Arguments {
+			Walk(v, e)
+		}
+	case *BadStatement, *BreakStatement, *ContinueStatement,
+		*Identifier, *IntegerLiteral, *FloatLiteral, *StringLiteral, *BooleanLiteral:
+		// 没有子节点
+	default:
+		panic(fmt.Sprintf("ast.Walk: unexpected node type %T", node))
+	}
+
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect 深度优先遍历语法树 对每个节点调用f(node)
+// f返回false时不再遍历这个节点的子节点；子节点遍历完之后调用f(nil)
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// 判断节点是否为空 包括装在接口里的nil指针
+func isNilNode(node Node) bool {
+	switch n := node.(type) {
+	case nil:
+		return true
+	case *Identifier:
+		return n == nil
+	case *BlockStatement:
+		return n == nil
+	case *LetStatement:
+		return n == nil
+	case *ReturnStatement:
+		return n == nil
+	case *ExpressionStatement:
+		return n == nil
+	}
+	return false
+}
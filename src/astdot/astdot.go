@@ -0,0 +1,89 @@
+package astdot
+
+import (
+	"MyCompiler/src/ast"
+	"MyCompiler/src/dot"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Write 把语法树画成Graphviz的树 写到w
+// 每个节点标出类型、字面量或运算符和源码位置；边上标出子节点所在的字段，列表中的子节点带下标
+// 语句画成方框，表达式画成椭圆
+func Write(w io.Writer, node ast.Node) error {
+	g := dot.NewGraph("ast")
+	g.Defaults("node", dot.A("fontname", "monospace"))
+	g.Defaults("edge", dot.A("fontname", "monospace"), dot.A("fontsize", "10"))
+
+	ids := map[ast.Node]string{}
+	ast.Apply(node, func(c *ast.Cursor) bool {
+		n := c.Node()
+		id := fmt.Sprintf("n%d", len(ids))
+		ids[n] = id
+
+		shape := "ellipse"
+		if _, ok := n.(ast.Statement); ok {
+			shape = "box"
+		}
+		g.Node(id, dot.Label(label(n)...), dot.A("shape", shape))
+
+		if c.Parent() != nil {
+			name := c.Name()
+			if c.Index() >= 0 {
+				name = fmt.Sprintf("%s[%d]", name, c.Index())
+			}
+			g.Edge(ids[c.Parent()], id, dot.Label(name))
+		}
+		return true
+	}, nil)
+
+	_, err := g.WriteTo(w)
+	return err
+}
+
+// 节点的标签: 类型名、节点的值(如果有)和位置
+func label(node ast.Node) []string {
+	lines := []string{strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")}
+	if detail := detail(node); detail != "" {
+		lines = append(lines, detail)
+	}
+	if pos := node.Pos(); pos.IsValid() {
+		lines = append(lines, fmt.Sprintf("%d:%d", pos.Line, pos.Column))
+	}
+	return lines
+}
+
+func detail(node ast.Node) string {
+	switch n := node.(type) {
+	case *ast.Program:
+		return n.Pos().Filename
+	case *ast.Identifier:
+		return n.Value
+	case *ast.IntegerLiteral:
+		return n.Token.Literal
+	case *ast.FloatLiteral:
+		return n.Token.Literal
+	case *ast.StringLiteral:
+		return strconv.Quote(n.Value)
+	case *ast.TemplateLiteral:
+		// 插值的位置用 ${…} 表示 插值的表达式是子节点
+		parts := make([]string, len(n.Strings))
+		for i, s := range n.Strings {
+			parts[i] = strings.Trim(strconv.Quote(s), `"`)
+		}
+		return `"` + strings.Join(parts, "${…}") + `"`
+	case *ast.BooleanLiteral:
+		return strconv.FormatBool(n.Value)
+	case *ast.PrefixExpression:
+		return n.Operator
+	case *ast.InfixExpression:
+		return n.Operator
+	case *ast.FnExpression:
+		return n.Name
+	case *ast.BadStatement:
+		return n.String()
+	}
+	return ""
+}
@@ -0,0 +1,616 @@
+package astjson
+
+import (
+	"MyCompiler/src/ast"
+	"MyCompiler/src/token"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Decode 从JSON重建语法树 根节点必须是Program
+// 位置信息是可选的；词法单元的类型和字面量按节点的内容重新生成
+func Decode(data []byte) (*ast.Program, error) {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &decoder{}
+	if raw, ok := fields["filename"]; ok {
+		err = json.Unmarshal(raw, &d.filename)
+		if err != nil {
+			return nil, fmt.Errorf("Program.filename: %s", err)
+		}
+	}
+
+	node, err := d.node(data)
+	if err != nil {
+		return nil, err
+	}
+	program, ok := node.(*ast.Program)
+	if !ok {
+		return nil, fmt.Errorf("root node must be %s, got %T", KindProgram, node)
+	}
+	return program, nil
+}
+
+// Read 从r中读取JSON并重建语法树
+func Read(r io.Reader) (*ast.Program, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(data)
+}
+
+type decoder struct {
+	filename string
+}
+
+// 一个节点的所有字段 kind是节点的类型名
+type fieldSet struct {
+	kind   string
+	fields map[string]json.RawMessage
+}
+
+type position struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	Offset int `json:"offset"`
+}
+
+// 解码一个节点 null返回nil
+func (d *decoder) node(data json.RawMessage) (ast.Node, error) {
+	if isNull(data) {
+		return nil, nil
+	}
+
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, err
+	}
+	f := &fieldSet{fields: fields}
+	err = json.Unmarshal(fields["kind"], &f.kind)
+	if err != nil || f.kind == "" {
+		return nil, fmt.Errorf("node without kind: %s", data)
+	}
+
+	node, err := d.decodeKind(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", f.kind, err)
+	}
+	return node, nil
+}
+
+func (d *decoder) decodeKind(f *fieldSet) (ast.Node, error) {
+	pos, err := d.position(f, "pos")
+	if err != nil {
+		return nil, err
+	}
+	end, err := d.position(f, "end")
+	if err != nil {
+		return nil, err
+	}
+
+	switch f.kind {
+	case KindProgram:
+		statements, err := d.statements(f, "statements")
+		if err != nil {
+			return nil, err
+		}
+		return &ast.Program{Statement: statements}, nil
+	case KindLetStatement:
+		name, err := d.identifier(f, "name", true)
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.expression(f, "value", false)
+		if err != nil {
+			return nil, err
+		}
+		if fn, ok := value.(*ast.FnExpression); ok && fn.Name == "" {
+			fn.Name = name.Value
+		}
+		return &ast.LetStatement{Token: d.token(token.LET, "let", pos), Name: name, Value: value}, nil
+	case KindReturnStatement:
+		value, err := d.expression(f, "value", false)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.ReturnStatement{Token: d.token(token.RETURN, "return", pos), ReturnValue: value}, nil
+	case KindExpressionStatement:
+		expr, err := d.expression(f, "expression", false)
+		if err != nil {
+			return nil, err
+		}
+		stmt := &ast.ExpressionStatement{Expression: expr}
+		if expr != nil {
+			// 语法分析器记录的是表达式的第一个词法单元
+			stmt.Token = d.token("", expr.TokenLiteral(), pos)
+		}
+		return stmt, nil
+	case KindBlockStatement:
+		statements, err := d.statements(f, "statements")
+		if err != nil {
+			return nil, err
+		}
+		return &ast.BlockStatement{
+			Token:      d.token(token.LBRACE, "{", pos),
+			Statements: statements,
+			RBrace:     d.closing(token.RBRACE, "}", end),
+		}, nil
+	case KindWhileStatement:
+		condition, err := d.expression(f, "condition", true)
+		if err != nil {
+			return nil, err
+		}
+		body, err := d.block(f, "body", true)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.WhileStatement{Token: d.token(token.WHILE, "while", pos), Condition: condition, Body: body}, nil
+	case KindForStatement:
+		init, err := d.statement(f, "init")
+		if err != nil {
+			return nil, err
+		}
+		condition, err := d.expression(f, "condition", false)
+		if err != nil {
+			return nil, err
+		}
+		update, err := d.statement(f, "update")
+		if err != nil {
+			return nil, err
+		}
+		body, err := d.block(f, "body", true)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.ForStatement{
+			Token:     d.token(token.FOR, "for", pos),
+			Init:      init,
+			Condition: condition,
+			Update:    update,
+			Body:      body,
+		}, nil
+	case KindBreakStatement:
+		return &ast.BreakStatement{Token: d.leaf(token.BREAK, "break", pos, end)}, nil
+	case KindContinueStatement:
+		return &ast.ContinueStatement{Token: d.leaf(token.CONTINUE, "continue", pos, end)}, nil
+	case KindBadStatement:
+		return &ast.BadStatement{
+			From: token.Token{Type: token.ILLEGAL, Pos: pos},
+			To:   token.Token{Type: token.ILLEGAL, End: end},
+		}, nil
+	case KindIdentifier:
+		var value string
+		err := d.field(f, "value", &value)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.Identifier{Token: d.leaf(token.IDENT, value, pos, end), Value: value}, nil
+	case KindIntegerLiteral:
+		var value int64
+		err := d.field(f, "value", &value)
+		if err != nil {
+			return nil, err
+		}
+		literal := strconv.FormatInt(value, 10)
+		err = d.optionalField(f, "literal", &literal)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.IntegerLiteral{Token: d.leaf(token.INT, literal, pos, end), Value: value}, nil
+	case KindFloatLiteral:
+		var value float64
+		err := d.field(f, "value", &value)
+		if err != nil {
+			return nil, err
+		}
+		literal := strconv.FormatFloat(value, 'g', -1, 64)
+		err = d.optionalField(f, "literal", &literal)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.FloatLiteral{Token: d.leaf(token.FLOAT, literal, pos, end), Value: value}, nil
+	case KindStringLiteral:
+		var value string
+		err := d.field(f, "value", &value)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.StringLiteral{Token: d.leaf(token.STRING, value, pos, end), Value: value}, nil
+	case KindTemplateLiteral:
+		var strs []string
+		err := d.field(f, "strings", &strs)
+		if err != nil {
+			return nil, err
+		}
+		values, err := d.expressions(f, "values")
+		if err != nil {
+			return nil, err
+		}
+		if len(strs) != len(values)+1 {
+			return nil, fmt.Errorf("expected %d strings for %d values, got %d", len(values)+1, len(values), len(strs))
+		}
+		tail := d.closing(token.TEMPLATE_TAIL, strs[len(strs)-1], end)
+		// 结尾的片段比一个字符长 开始位置无法还原
+		tail.Pos = token.Position{}
+		return &ast.TemplateLiteral{
+			Token:   d.token(token.TEMPLATE_HEAD, strs[0], pos),
+			Strings: strs,
+			Values:  values,
+			Tail:    tail,
+		}, nil
+	case KindBooleanLiteral:
+		var value bool
+		err := d.field(f, "value", &value)
+		if err != nil {
+			return nil, err
+		}
+		literal := strconv.FormatBool(value)
+		return &ast.BooleanLiteral{Token: d.leaf(token.LookupIdent(literal), literal, pos, end), Value: value}, nil
+	case KindArrayLiteral:
+		elements, err := d.expressions(f, "elements")
+		if err != nil {
+			return nil, err
+		}
+		return &ast.ArrayLiteral{
+			Token:    d.token(token.LBRACKET, "[", pos),
+			Elements: elements,
+			RBracket: d.closing(token.RBRACKET, "]", end),
+		}, nil
+	case KindHashLiteral:
+		pairs, err := d.pairs(f)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.HashLiteral{
+			Token:  d.token(token.LBRACE, "{", pos),
+			Pairs:  pairs,
+			RBrace: d.closing(token.RBRACE, "}", end),
+		}, nil
+	case KindIndexExpression:
+		tokenPos, err := d.position(f, "tokenPos")
+		if err != nil {
+			return nil, err
+		}
+		left, err := d.expression(f, "left", true)
+		if err != nil {
+			return nil, err
+		}
+		index, err := d.expression(f, "index", true)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.IndexExpression{
+			Token:    d.token(token.LBRACKET, "[", tokenPos),
+			Left:     left,
+			Index:    index,
+			RBracket: d.closing(token.RBRACKET, "]", end),
+		}, nil
+	case KindPrefixExpression:
+		var operator string
+		err := d.field(f, "operator", &operator)
+		if err != nil {
+			return nil, err
+		}
+		right, err := d.expression(f, "right", true)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.PrefixExpression{
+			Token:    d.token(token.TokenType(operator), operator, pos),
+			Operator: operator,
+			Right:    right,
+		}, nil
+	case KindInfixExpression:
+		var operator string
+		err := d.field(f, "operator", &operator)
+		if err != nil {
+			return nil, err
+		}
+		tokenPos, err := d.position(f, "tokenPos")
+		if err != nil {
+			return nil, err
+		}
+		left, err := d.expression(f, "left", true)
+		if err != nil {
+			return nil, err
+		}
+		right, err := d.expression(f, "right", true)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.InfixExpression{
+			Token:    d.token(token.TokenType(operator), operator, tokenPos),
+			Operator: operator,
+			Left:     left,
+			Right:    right,
+		}, nil
+	case KindIfExpression:
+		condition, err := d.expression(f, "condition", true)
+		if err != nil {
+			return nil, err
+		}
+		consequence, err := d.block(f, "consequence", true)
+		if err != nil {
+			return nil, err
+		}
+		alternative, err := d.block(f, "alternative", false)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.IfExpression{
+			Token:       d.token(token.IF, "if", pos),
+			Condition:   condition,
+			Consequence: consequence,
+			Alternative: alternative,
+		}, nil
+	case KindFnExpression:
+		fn := &ast.FnExpression{Token: d.token(token.FUNCTION, "fn", pos)}
+		err := d.optionalField(f, "name", &fn.Name)
+		if err != nil {
+			return nil, err
+		}
+		var params []json.RawMessage
+		err = d.field(f, "parameters", &params)
+		if err != nil {
+			return nil, err
+		}
+		fn.Parameters = []*ast.Identifier{}
+		for i, raw := range params {
+			node, err := d.node(raw)
+			if err != nil {
+				return nil, err
+			}
+			ident, ok := node.(*ast.Identifier)
+			if !ok {
+				return nil, fmt.Errorf("parameters[%d]: expected %s, got %T", i, KindIdentifier, node)
+			}
+			fn.Parameters = append(fn.Parameters, ident)
+		}
+		fn.Body, err = d.block(f, "body", true)
+		if err != nil {
+			return nil, err
+		}
+		return fn, nil
+	case KindCallExpression:
+		tokenPos, err := d.position(f, "tokenPos")
+		if err != nil {
+			return nil, err
+		}
+		function, err := d.expression(f, "function", true)
+		if err != nil {
+			return nil, err
+		}
+		args, err := d.expressions(f, "arguments")
+		if err != nil {
+			return nil, err
+		}
+		return &ast.CallExpression{
+			Token:     d.token(token.LPAREN, "(", tokenPos),
+			Function:  function,
+			Arguments: args,
+			RParen:    d.closing(token.RPAREN, ")", end),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown node kind")
+	}
+}
+
+// region 字段
+
+// 读取必须存在的字段
+func (d *decoder) field(f *fieldSet, name string, v interface{}) error {
+	raw, ok := f.fields[name]
+	if !ok {
+		return fmt.Errorf("missing field %q", name)
+	}
+	err := json.Unmarshal(raw, v)
+	if err != nil {
+		return fmt.Errorf("field %q: %s", name, err)
+	}
+	return nil
+}
+
+// 读取可选的字段 字段不存在或为null时保持v不变
+func (d *decoder) optionalField(f *fieldSet, name string, v interface{}) error {
+	raw, ok := f.fields[name]
+	if !ok || isNull(raw) {
+		return nil
+	}
+	err := json.Unmarshal(raw, v)
+	if err != nil {
+		return fmt.Errorf("field %q: %s", name, err)
+	}
+	return nil
+}
+
+// 读取可选的位置字段 不存在时返回无效的位置
+func (d *decoder) position(f *fieldSet, name string) (token.Position, error) {
+	var p *position
+	err := d.optionalField(f, name, &p)
+	if err != nil || p == nil {
+		return token.Position{}, err
+	}
+	return token.Position{Filename: d.filename, Offset: p.Offset, Line: p.Line, Column: p.Column}, nil
+}
+
+// 读取子节点 required为true时不允许null
+func (d *decoder) child(f *fieldSet, name string, required bool) (ast.Node, error) {
+	raw, ok := f.fields[name]
+	if !ok && required {
+		return nil, fmt.Errorf("missing field %q", name)
+	}
+	if !ok {
+		return nil, nil
+	}
+	node, err := d.node(raw)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil && required {
+		return nil, fmt.Errorf("field %q must not be null", name)
+	}
+	return node, nil
+}
+
+func (d *decoder) expression(f *fieldSet, name string, required bool) (ast.Expression, error) {
+	node, err := d.child(f, name, required)
+	if err != nil || node == nil {
+		return nil, err
+	}
+	expr, ok := node.(ast.Expression)
+	if !ok {
+		return nil, fmt.Errorf("field %q: expected an expression, got %T", name, node)
+	}
+	return expr, nil
+}
+
+func (d *decoder) identifier(f *fieldSet, name string, required bool) (*ast.Identifier, error) {
+	node, err := d.child(f, name, required)
+	if err != nil || node == nil {
+		return nil, err
+	}
+	ident, ok := node.(*ast.Identifier)
+	if !ok {
+		return nil, fmt.Errorf("field %q: expected %s, got %T", name, KindIdentifier, node)
+	}
+	return ident, nil
+}
+
+func (d *decoder) block(f *fieldSet, name string, required bool) (*ast.BlockStatement, error) {
+	node, err := d.child(f, name, required)
+	if err != nil || node == nil {
+		return nil, err
+	}
+	block, ok := node.(*ast.BlockStatement)
+	if !ok {
+		return nil, fmt.Errorf("field %q: expected %s, got %T", name, KindBlockStatement, node)
+	}
+	return block, nil
+}
+
+// 可以为null的语句 for语句的init和update
+func (d *decoder) statement(f *fieldSet, name string) (ast.Statement, error) {
+	node, err := d.child(f, name, false)
+	if err != nil || node == nil {
+		return nil, err
+	}
+	stmt, ok := node.(ast.Statement)
+	if !ok {
+		return nil, fmt.Errorf("field %q: expected a statement, got %T", name, node)
+	}
+	return stmt, nil
+}
+
+func (d *decoder) statements(f *fieldSet, name string) ([]ast.Statement, error) {
+	var list []json.RawMessage
+	err := d.field(f, name, &list)
+	if err != nil {
+		return nil, err
+	}
+
+	statements := []ast.Statement{}
+	for i, raw := range list {
+		node, err := d.node(raw)
+		if err != nil {
+			return nil, err
+		}
+		stmt, ok := node.(ast.Statement)
+		if !ok {
+			return nil, fmt.Errorf("%s[%d]: expected a statement, got %T", name, i, node)
+		}
+		statements = append(statements, stmt)
+	}
+	return statements, nil
+}
+
+func (d *decoder) expressions(f *fieldSet, name string) ([]ast.Expression, error) {
+	var list []json.RawMessage
+	err := d.field(f, name, &list)
+	if err != nil {
+		return nil, err
+	}
+
+	expressions := []ast.Expression{}
+	for i, raw := range list {
+		node, err := d.node(raw)
+		if err != nil {
+			return nil, err
+		}
+		expr, ok := node.(ast.Expression)
+		if !ok {
+			return nil, fmt.Errorf("%s[%d]: expected an expression, got %T", name, i, node)
+		}
+		expressions = append(expressions, expr)
+	}
+	return expressions, nil
+}
+
+func (d *decoder) pairs(f *fieldSet) (map[ast.Expression]ast.Expression, error) {
+	var list []map[string]json.RawMessage
+	err := d.field(f, "pairs", &list)
+	if err != nil {
+		return nil, err
+	}
+
+	pairs := make(map[ast.Expression]ast.Expression)
+	for i, fields := range list {
+		pair := &fieldSet{kind: "pair", fields: fields}
+		key, err := d.expression(pair, "key", true)
+		if err != nil {
+			return nil, fmt.Errorf("pairs[%d]: %s", i, err)
+		}
+		value, err := d.expression(pair, "value", true)
+		if err != nil {
+			return nil, fmt.Errorf("pairs[%d]: %s", i, err)
+		}
+		pairs[key] = value
+	}
+	return pairs, nil
+}
+
+// endregion
+
+// region 词法单元
+
+// 从pos开始的词法单元 结束位置按字面量的长度推算
+func (d *decoder) token(tokenType token.TokenType, literal string, pos token.Position) token.Token {
+	tok := token.Token{Type: tokenType, Literal: literal, Pos: pos}
+	if pos.IsValid() {
+		tok.End = pos
+		tok.End.Offset += len(literal)
+		tok.End.Column += utf8.RuneCountInString(literal)
+	}
+	return tok
+}
+
+// 单独构成一个节点的词法单元 位置就是节点的范围
+func (d *decoder) leaf(tokenType token.TokenType, literal string, pos, end token.Position) token.Token {
+	return token.Token{Type: tokenType, Literal: literal, Pos: pos, End: end}
+}
+
+// 节点末尾的单字符词法单元 如右括号
+func (d *decoder) closing(tokenType token.TokenType, literal string, end token.Position) token.Token {
+	tok := token.Token{Type: tokenType, Literal: literal, End: end}
+	if end.IsValid() {
+		tok.Pos = end
+		tok.Pos.Offset--
+		tok.Pos.Column--
+	}
+	return tok
+}
+
+// endregion
+
+func isNull(data json.RawMessage) bool {
+	return len(data) == 0 || string(data) == "null"
+}
@@ -0,0 +1,297 @@
+package astjson
+
+import (
+	"MyCompiler/src/ast"
+	"MyCompiler/src/token"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Encode 把语法树编码成缩进的JSON 字段按固定的顺序输出
+// node通常是*ast.Program，也可以是单个语句或表达式
+func Encode(node ast.Node) ([]byte, error) {
+	e := &encoder{}
+	value := e.node(node)
+	if e.err != nil {
+		return nil, e.err
+	}
+	return e.encode(value)
+}
+
+// Write 把语法树编码后写入w
+func Write(w io.Writer, node ast.Node) error {
+	data, err := Encode(node)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// EncodeTokens 把词法单元序列编码成JSON数组
+func EncodeTokens(tokens []token.Token) ([]byte, error) {
+	e := &encoder{}
+	list := make(array, 0, len(tokens))
+	for _, tok := range tokens {
+		list = append(list, e.token(tok))
+	}
+	return e.encode(list)
+}
+
+// object 字段有序的JSON对象 值为nil的字段输出为null
+type object []field
+
+type field struct {
+	name  string
+	value interface{}
+}
+
+type array []interface{}
+
+// omit 作为字段值时整个字段不输出
+type omitted struct{}
+
+var omit = omitted{}
+
+type encoder struct {
+	out bytes.Buffer
+	err error // 遇到的第一个不认识的节点类型
+}
+
+// 输出value 并整理成两个空格缩进的格式
+func (e *encoder) encode(value interface{}) ([]byte, error) {
+	err := e.value(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	err = json.Indent(&out, e.out.Bytes(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
+
+func (e *encoder) value(value interface{}) error {
+	switch value := value.(type) {
+	case object:
+		e.out.WriteByte('{')
+		first := true
+		for _, f := range value {
+			if f.value == omit {
+				continue
+			}
+			if !first {
+				e.out.WriteByte(',')
+			}
+			first = false
+			e.scalar(f.name)
+			e.out.WriteByte(':')
+			err := e.value(f.value)
+			if err != nil {
+				return err
+			}
+		}
+		e.out.WriteByte('}')
+	case array:
+		e.out.WriteByte('[')
+		for i, v := range value {
+			if i > 0 {
+				e.out.WriteByte(',')
+			}
+			err := e.value(v)
+			if err != nil {
+				return err
+			}
+		}
+		e.out.WriteByte(']')
+	default:
+		return e.scalar(value)
+	}
+	return nil
+}
+
+// 字符串 数字 布尔值和null 不把 < > & 转义成\u003c之类的形式
+func (e *encoder) scalar(value interface{}) error {
+	enc := json.NewEncoder(&e.out)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(value)
+	if err != nil {
+		return err
+	}
+	// Encode会在末尾加一个换行
+	e.out.Truncate(e.out.Len() - 1)
+	return nil
+}
+
+func (e *encoder) position(p token.Position) interface{} {
+	if !p.IsValid() {
+		return omit
+	}
+	return object{{"line", p.Line}, {"column", p.Column}, {"offset", p.Offset}}
+}
+
+func (e *encoder) token(tok token.Token) object {
+	leading := interface{}(omit)
+	if len(tok.Leading) > 0 {
+		comments := array{}
+		for _, c := range tok.Leading {
+			comments = append(comments, object{{"text", c.Text}, {"pos", e.position(c.Pos)}, {"end", e.position(c.End)}})
+		}
+		leading = comments
+	}
+	return object{
+		{"type", string(tok.Type)},
+		{"literal", tok.Literal},
+		{"pos", e.position(tok.Pos)},
+		{"end", e.position(tok.End)},
+		{"leading", leading},
+	}
+}
+
+// 节点的公共字段 kind pos end 后面跟上各自的字段
+func (e *encoder) header(kind string, node ast.Node, fields ...field) object {
+	o := object{{"kind", kind}, {"pos", e.position(node.Pos())}, {"end", e.position(node.End())}}
+	return append(o, fields...)
+}
+
+func (e *encoder) node(node ast.Node) interface{} {
+	// 空的子节点 包括装在接口里的nil指针
+	if node == nil || reflect.ValueOf(node).IsNil() {
+		return nil
+	}
+
+	switch node := node.(type) {
+	case *ast.Program:
+		filename := interface{}(omit)
+		if pos := node.Pos(); pos.Filename != "" {
+			filename = pos.Filename
+		}
+		statements := array{}
+		for _, s := range node.Statement {
+			statements = append(statements, e.node(s))
+		}
+		return object{{"kind", KindProgram}, {"filename", filename}, {"statements", statements}}
+	case *ast.LetStatement:
+		return e.header(KindLetStatement, node,
+			field{"name", e.node(node.Name)},
+			field{"value", e.node(node.Value)})
+	case *ast.ReturnStatement:
+		return e.header(KindReturnStatement, node, field{"value", e.node(node.ReturnValue)})
+	case *ast.ExpressionStatement:
+		return e.header(KindExpressionStatement, node, field{"expression", e.node(node.Expression)})
+	case *ast.BlockStatement:
+		statements := array{}
+		for _, s := range node.Statements {
+			statements = append(statements, e.node(s))
+		}
+		return e.header(KindBlockStatement, node, field{"statements", statements})
+	case *ast.WhileStatement:
+		return e.header(KindWhileStatement, node,
+			field{"condition", e.node(node.Condition)},
+			field{"body", e.node(node.Body)})
+	case *ast.ForStatement:
+		return e.header(KindForStatement, node,
+			field{"init", e.node(node.Init)},
+			field{"condition", e.node(node.Condition)},
+			field{"update", e.node(node.Update)},
+			field{"body", e.node(node.Body)})
+	case *ast.BreakStatement:
+		return e.header(KindBreakStatement, node)
+	case *ast.ContinueStatement:
+		return e.header(KindContinueStatement, node)
+	case *ast.BadStatement:
+		return e.header(KindBadStatement, node)
+	case *ast.Identifier:
+		return e.header(KindIdentifier, node, field{"value", node.Value})
+	case *ast.IntegerLiteral:
+		return e.header(KindIntegerLiteral, node,
+			field{"literal", node.Token.Literal},
+			field{"value", node.Value})
+	case *ast.FloatLiteral:
+		return e.header(KindFloatLiteral, node,
+			field{"literal", node.Token.Literal},
+			field{"value", node.Value})
+	case *ast.StringLiteral:
+		return e.header(KindStringLiteral, node, field{"value", node.Value})
+	case *ast.TemplateLiteral:
+		strs := array{}
+		for _, s := range node.Strings {
+			strs = append(strs, s)
+		}
+		return e.header(KindTemplateLiteral, node,
+			field{"strings", strs},
+			field{"values", e.expressions(node.Values)})
+	case *ast.BooleanLiteral:
+		return e.header(KindBooleanLiteral, node, field{"value", node.Value})
+	case *ast.ArrayLiteral:
+		return e.header(KindArrayLiteral, node, field{"elements", e.expressions(node.Elements)})
+	case *ast.HashLiteral:
+		return e.header(KindHashLiteral, node, field{"pairs", e.pairs(node)})
+	case *ast.IndexExpression:
+		return e.header(KindIndexExpression, node,
+			field{"tokenPos", e.position(node.Token.Pos)},
+			field{"left", e.node(node.Left)},
+			field{"index", e.node(node.Index)})
+	case *ast.PrefixExpression:
+		return e.header(KindPrefixExpression, node,
+			field{"operator", node.Operator},
+			field{"right", e.node(node.Right)})
+	case *ast.InfixExpression:
+		return e.header(KindInfixExpression, node,
+			field{"operator", node.Operator},
+			field{"tokenPos", e.position(node.Token.Pos)},
+			field{"left", e.node(node.Left)},
+			field{"right", e.node(node.Right)})
+	case *ast.IfExpression:
+		return e.header(KindIfExpression, node,
+			field{"condition", e.node(node.Condition)},
+			field{"consequence", e.node(node.Consequence)},
+			field{"alternative", e.node(node.Alternative)})
+	case *ast.FnExpression:
+		name := interface{}(omit)
+		if node.Name != "" {
+			name = node.Name
+		}
+		params := array{}
+		for _, p := range node.Parameters {
+			params = append(params, e.node(p))
+		}
+		return e.header(KindFnExpression, node,
+			field{"name", name},
+			field{"parameters", params},
+			field{"body", e.node(node.Body)})
+	case *ast.CallExpression:
+		return e.header(KindCallExpression, node,
+			field{"tokenPos", e.position(node.Token.Pos)},
+			field{"function", e.node(node.Function)},
+			field{"arguments", e.expressions(node.Arguments)})
+	default:
+		if e.err == nil {
+			e.err = fmt.Errorf("unsupported node type %T", node)
+		}
+		return nil
+	}
+}
+
+func (e *encoder) expressions(list []ast.Expression) array {
+	out := array{}
+	for _, x := range list {
+		out = append(out, e.node(x))
+	}
+	return out
+}
+
+// 按键在源码中的顺序输出 保证输出稳定
+func (e *encoder) pairs(h *ast.HashLiteral) array {
+	out := array{}
+	for _, k := range h.Keys() {
+		out = append(out, object{{"key", e.node(k)}, {"value", e.node(h.Pairs[k])}})
+	}
+	return out
+}
@@ -0,0 +1,67 @@
+package astjson
+
+// 语法树的JSON格式
+//
+// 每个节点是一个JSON对象，kind字段是节点的类型名(与ast包中的类型名相同)
+// 所有节点都有可选的pos和end字段，表示节点在源码中的范围
+// 位置的格式为 {"line": 1, "column": 5, "offset": 4}，未知的位置省略
+// 文件名只在Program上记录一次
+//
+//	Program              filename statements
+//	LetStatement         name(Identifier) value
+//	ReturnStatement      value
+//	ExpressionStatement  expression
+//	BlockStatement       statements
+//	WhileStatement       condition body
+//	ForStatement         init condition update body    init和update是let语句或表达式语句
+//	BreakStatement
+//	ContinueStatement
+//	BadStatement                           有语法错误的语句 只有位置
+//	Identifier           value
+//	IntegerLiteral       literal value     literal是源码中的写法，如 "0x1F"
+//	FloatLiteral         literal value
+//	StringLiteral        value             转义之后的字符串
+//	TemplateLiteral      strings values    strings比values多一个
+//	BooleanLiteral       value
+//	ArrayLiteral         elements
+//	HashLiteral          pairs             [{"key": ..., "value": ...}] 按源码中的顺序
+//	IndexExpression      tokenPos left index
+//	PrefixExpression     operator right
+//	InfixExpression      operator tokenPos left right
+//	IfExpression         condition consequence alternative
+//	FnExpression         name parameters body    name是通过let绑定的函数名
+//	CallExpression       tokenPos function arguments
+//
+// tokenPos是运算符、[ 或 ( 的位置 这几种节点的开始位置来自左边的子节点
+// 值可以为空的子节点用null表示
+//
+// 词法单元编码成 {"type", "literal", "pos", "end", "leading"}
+// leading是词法单元之前的注释 [{"text", "pos", "end"}]，没有注释时省略
+
+// 节点的类型名
+const (
+	KindProgram             = "Program"
+	KindLetStatement        = "LetStatement"
+	KindReturnStatement     = "ReturnStatement"
+	KindExpressionStatement = "ExpressionStatement"
+	KindBlockStatement      = "BlockStatement"
+	KindWhileStatement      = "WhileStatement"
+	KindForStatement        = "ForStatement"
+	KindBreakStatement      = "BreakStatement"
+	KindContinueStatement   = "ContinueStatement"
+	KindBadStatement        = "BadStatement"
+	KindIdentifier          = "Identifier"
+	KindIntegerLiteral      = "IntegerLiteral"
+	KindFloatLiteral        = "FloatLiteral"
+	KindStringLiteral       = "StringLiteral"
+	KindTemplateLiteral     = "TemplateLiteral"
+	KindBooleanLiteral      = "BooleanLiteral"
+	KindArrayLiteral        = "ArrayLiteral"
+	KindHashLiteral         = "HashLiteral"
+	KindIndexExpression     = "IndexExpression"
+	KindPrefixExpression    = "PrefixExpression"
+	KindInfixExpression     = "InfixExpression"
+	KindIfExpression        = "IfExpression"
+	KindFnExpression        = "FnExpression"
+	KindCallExpression      = "CallExpression"
+)
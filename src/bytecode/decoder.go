@@ -0,0 +1,192 @@
+package bytecode
+
+import (
+	"MyCompiler/src/code"
+	"MyCompiler/src/compiler"
+	"MyCompiler/src/object"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+// Decode 从文件格式解码出字节码 文件没有调试信息时返回的DebugInfo为nil
+func Decode(data []byte) (*compiler.ByteCode, *DebugInfo, error) {
+	d := &decoder{data: data}
+
+	if len(data) < len(Magic) || string(data[:len(Magic)]) != Magic {
+		return nil, nil, fmt.Errorf("not a bytecode file: bad magic header")
+	}
+	d.pos = len(Magic)
+
+	version, err := d.uint16()
+	if err != nil {
+		return nil, nil, err
+	}
+	if version != Version {
+		return nil, nil, fmt.Errorf("unsupported bytecode version %d (want %d)", version, Version)
+	}
+
+	flags, err := d.byte()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	count, err := d.uint32()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	constants := []object.Object{}
+	for i := uint32(0); i < count; i++ {
+		c, err := d.constant()
+		if err != nil {
+			return nil, nil, fmt.Errorf("constant %d: %s", i, err)
+		}
+		constants = append(constants, c)
+	}
+
+	instructions, err := d.bytes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var debug *DebugInfo
+	if flags&FlagDebugInfo != 0 {
+		sourceName, err := d.bytes()
+		if err != nil {
+			return nil, nil, err
+		}
+		debug = &DebugInfo{SourceName: string(sourceName)}
+	}
+
+	if d.pos != len(d.data) {
+		return nil, nil, fmt.Errorf("unexpected %d trailing bytes", len(d.data)-d.pos)
+	}
+
+	bc := &compiler.ByteCode{
+		Instructions: code.Instructions(instructions),
+		Constants:    constants,
+	}
+	return bc, debug, nil
+}
+
+// Read 从r读取全部内容并解码
+func Read(r io.Reader) (*compiler.ByteCode, *DebugInfo, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return Decode(data)
+}
+
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *decoder) constant() (object.Object, error) {
+	tag, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tag {
+	case TagInteger:
+		v, err := d.uint64()
+		if err != nil {
+			return nil, err
+		}
+		return &object.Integer{Value: int64(v)}, nil
+	case TagFloat:
+		v, err := d.uint64()
+		if err != nil {
+			return nil, err
+		}
+		return &object.Float{Value: math.Float64frombits(v)}, nil
+	case TagString:
+		b, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		return &object.String{Value: string(b)}, nil
+	case TagFunction:
+		numLocals, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		numParameters, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		ins, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		return &object.CompiledFunction{
+			Instructions:  code.Instructions(ins),
+			NumLocals:     int(numLocals),
+			NumParameters: int(numParameters),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown constant tag %d", tag)
+	}
+}
+
+// 取出接下来的n个字节
+func (d *decoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, fmt.Errorf("unexpected end of file at offset %d", d.pos)
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *decoder) byte() (byte, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (d *decoder) uint16() (uint16, error) {
+	b, err := d.next(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(b), nil
+}
+
+func (d *decoder) uint32() (uint32, error) {
+	b, err := d.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
+
+func (d *decoder) uint64() (uint64, error) {
+	b, err := d.next(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// 读取4字节长度和数据 返回数据的拷贝
+func (d *decoder) bytes() ([]byte, error) {
+	n, err := d.uint32()
+	if err != nil {
+		return nil, err
+	}
+	b, err := d.next(int(n))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(b))
+	copy(out, b)
+	return out, nil
+}
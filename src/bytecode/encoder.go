@@ -0,0 +1,103 @@
+package bytecode
+
+import (
+	"MyCompiler/src/compiler"
+	"MyCompiler/src/object"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Encode 把字节码编码成文件格式 debug为nil时不写入调试信息
+// 编码结果只取决于字节码本身，相同的输入总是得到相同的字节
+func Encode(bc *compiler.ByteCode, debug *DebugInfo) ([]byte, error) {
+	var buf bytes.Buffer
+	e := &encoder{out: &buf}
+
+	e.out.WriteString(Magic)
+	e.uint16(Version)
+
+	var flags byte
+	if debug != nil {
+		flags |= FlagDebugInfo
+	}
+	e.out.WriteByte(flags)
+
+	e.uint32(uint32(len(bc.Constants)))
+	for i, c := range bc.Constants {
+		err := e.constant(c)
+		if err != nil {
+			return nil, fmt.Errorf("constant %d: %s", i, err)
+		}
+	}
+
+	e.bytes(bc.Instructions)
+
+	if debug != nil {
+		e.bytes([]byte(debug.SourceName))
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Write 把字节码编码后写入w
+func Write(w io.Writer, bc *compiler.ByteCode, debug *DebugInfo) error {
+	data, err := Encode(bc, debug)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+type encoder struct {
+	out *bytes.Buffer
+}
+
+func (e *encoder) constant(obj object.Object) error {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		e.out.WriteByte(TagInteger)
+		e.uint64(uint64(obj.Value))
+	case *object.Float:
+		e.out.WriteByte(TagFloat)
+		e.uint64(math.Float64bits(obj.Value))
+	case *object.String:
+		e.out.WriteByte(TagString)
+		e.bytes([]byte(obj.Value))
+	case *object.CompiledFunction:
+		e.out.WriteByte(TagFunction)
+		e.uint32(uint32(obj.NumLocals))
+		e.uint32(uint32(obj.NumParameters))
+		e.bytes(obj.Instructions)
+	default:
+		return fmt.Errorf("unsupported constant type: %s", obj.Type())
+	}
+	return nil
+}
+
+// 写入4字节长度和数据
+func (e *encoder) bytes(b []byte) {
+	e.uint32(uint32(len(b)))
+	e.out.Write(b)
+}
+
+func (e *encoder) uint16(v uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	e.out.Write(b[:])
+}
+
+func (e *encoder) uint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	e.out.Write(b[:])
+}
+
+func (e *encoder) uint64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	e.out.Write(b[:])
+}
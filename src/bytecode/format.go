@@ -0,0 +1,42 @@
+package bytecode
+
+// 字节码文件格式 (所有多字节整数都是大端序)
+//
+//	magic          4字节  "MKBC"
+//	version        2字节  格式版本
+//	flags          1字节  FlagDebugInfo 表示文件末尾带有调试信息
+//	constantCount  4字节
+//	constants      constantCount个常量 每个常量以1字节的类型标签开头
+//	instructions   4字节长度 + 顶层指令
+//	debugInfo      (可选) 见DebugInfo
+//
+// 常量的编码:
+//
+//	TagInteger   8字节有符号整数
+//	TagString    4字节长度 + UTF-8字节
+//	TagFunction  4字节NumLocals + 4字节NumParameters + 4字节长度 + 指令
+//	TagFloat     8字节IEEE 754双精度浮点数
+
+// Magic 文件头
+const Magic = "MKBC"
+
+// Version 当前的格式版本 格式有不兼容的改动时加一
+const Version uint16 = 1
+
+// 文件头中的标记位
+const (
+	FlagDebugInfo byte = 1 << iota // 带有调试信息
+)
+
+// 常量的类型标签
+const (
+	TagInteger  byte = 1
+	TagString   byte = 2
+	TagFunction byte = 3
+	TagFloat    byte = 4
+)
+
+// DebugInfo 调试信息 不影响执行
+type DebugInfo struct {
+	SourceName string // 源文件名
+}
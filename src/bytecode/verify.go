@@ -0,0 +1,184 @@
+package bytecode
+
+import (
+	"MyCompiler/src/cfg"
+	"MyCompiler/src/code"
+	"MyCompiler/src/compiler"
+	"MyCompiler/src/object"
+	"fmt"
+)
+
+// Verify 检查字节码能否安全地交给虚拟机执行
+// Decode只检查文件的结构，指令可能是损坏的或者手工构造的；虚拟机假设指令是编译器生成的，
+// 遇到这样的指令会直接panic。运行字节码文件之前先调用Verify
+//
+// 检查顶层指令和每个函数的指令:
+//   - 指令都能解码，跳转目标都在指令边界上
+//   - 常量和内置函数的下标在范围内，OpClosure引用的常量是函数
+//   - 局部变量的下标小于函数的NumLocals，顶层指令中不能使用局部变量和OpReturn
+//   - 自由变量的下标小于创建闭包时给出的自由变量个数
+//   - 任何执行路径上弹出的值都不会比压入的多
+func Verify(bc *compiler.ByteCode) error {
+	v := &verifier{constants: bc.Constants, maxFree: map[int]int{}}
+
+	for i, c := range bc.Constants {
+		fn, ok := c.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		if fn.NumParameters > fn.NumLocals {
+			return fmt.Errorf("constant %d: %d parameters but only %d locals", i, fn.NumParameters, fn.NumLocals)
+		}
+		maxFree, err := v.instructions(fn.Instructions, fn)
+		if err != nil {
+			return fmt.Errorf("constant %d: %s", i, err)
+		}
+		v.maxFree[i] = maxFree
+	}
+
+	if _, err := v.instructions(bc.Instructions, nil); err != nil {
+		return fmt.Errorf("instructions: %s", err)
+	}
+	for i, c := range bc.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			if err := v.closures(fn.Instructions); err != nil {
+				return fmt.Errorf("constant %d: %s", i, err)
+			}
+		}
+	}
+	return v.closures(bc.Instructions)
+}
+
+type verifier struct {
+	constants []object.Object
+	maxFree   map[int]int // 函数常量的下标 -> 用到的最大自由变量下标加一
+}
+
+// 检查一段指令 fn为nil表示顶层指令
+// 返回指令中用到的自由变量个数(最大下标加一)
+func (v *verifier) instructions(ins code.Instructions, fn *object.CompiledFunction) (int, error) {
+	g, err := cfg.Build(ins)
+	if err != nil {
+		return 0, err
+	}
+
+	maxFree := 0
+	for i := 0; i < len(ins); {
+		def, operands, width, _ := code.ReadInstruction(ins, i)
+		op, _ := code.OpcodeAt(ins, i)
+		switch op {
+		case code.OpConstant:
+			if operands[0] >= len(v.constants) {
+				return 0, fmt.Errorf("%04d: constant index %d out of range", i, operands[0])
+			}
+		case code.OpClosure:
+			if operands[0] >= len(v.constants) {
+				return 0, fmt.Errorf("%04d: constant index %d out of range", i, operands[0])
+			}
+			if _, ok := v.constants[operands[0]].(*object.CompiledFunction); !ok {
+				return 0, fmt.Errorf("%04d: constant %d is not a function", i, operands[0])
+			}
+		case code.OpGetBuiltin:
+			if operands[0] >= len(object.Builtins) {
+				return 0, fmt.Errorf("%04d: builtin index %d out of range", i, operands[0])
+			}
+		case code.OpGetLocal, code.OpSetLocal:
+			if fn == nil {
+				return 0, fmt.Errorf("%04d: %s outside a function", i, def.Name)
+			}
+			if operands[0] >= fn.NumLocals {
+				return 0, fmt.Errorf("%04d: local index %d out of range", i, operands[0])
+			}
+		case code.OpReturn:
+			if fn == nil {
+				return 0, fmt.Errorf("%04d: %s outside a function", i, def.Name)
+			}
+		case code.OpGetFree:
+			if fn == nil {
+				return 0, fmt.Errorf("%04d: %s outside a function", i, def.Name)
+			}
+			if operands[0]+1 > maxFree {
+				maxFree = operands[0] + 1
+			}
+		}
+		i += width
+	}
+
+	return maxFree, v.stackDepth(ins, g)
+}
+
+// 检查创建闭包时给出的自由变量足够函数使用
+func (v *verifier) closures(ins code.Instructions) error {
+	for i := 0; i < len(ins); {
+		_, operands, width, _ := code.ReadInstruction(ins, i)
+		if op, _ := code.OpcodeAt(ins, i); op == code.OpClosure {
+			if want := v.maxFree[operands[0]]; operands[1] < want {
+				return fmt.Errorf("%04d: closure of constant %d needs %d free variables, got %d",
+					i, operands[0], want, operands[1])
+			}
+		}
+		i += width
+	}
+	return nil
+}
+
+// 沿控制流图计算每个基本块入口处栈的最小深度，检查没有指令会弹出空栈
+// 深度只会变小，有净弹出的循环最终会报错，所以一定会结束
+func (v *verifier) stackDepth(ins code.Instructions, g *cfg.Graph) error {
+	if len(g.Blocks) == 0 {
+		return nil
+	}
+
+	entry := map[int]int{0: 0}
+	worklist := []int{0}
+	for len(worklist) > 0 {
+		b := g.Blocks[worklist[len(worklist)-1]]
+		worklist = worklist[:len(worklist)-1]
+
+		depth := entry[b.Index]
+		for _, i := range b.Instructions(ins) {
+			def, operands, _, _ := code.ReadInstruction(ins, i)
+			op, _ := code.OpcodeAt(ins, i)
+			pops, pushes := stackEffect(op, operands)
+			if depth < pops {
+				return fmt.Errorf("%04d: %s pops %d values but the stack may have only %d", i, def.Name, pops, depth)
+			}
+			depth += pushes - pops
+		}
+
+		for _, e := range b.Succs {
+			if e.To == cfg.Exit {
+				continue
+			}
+			if d, ok := entry[e.To]; !ok || depth < d {
+				entry[e.To] = depth
+				worklist = append(worklist, e.To)
+			}
+		}
+	}
+	return nil
+}
+
+// 指令从栈上弹出和压入的值的个数
+func stackEffect(op code.Opcode, operands []int) (pops, pushes int) {
+	switch op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull,
+		code.OpGetGlobal, code.OpGetLocal, code.OpGetFree, code.OpGetBuiltin, code.OpCurrentClosure:
+		return 0, 1
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpIndex,
+		code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanOrEqual:
+		return 2, 1
+	case code.OpMinus, code.OpBang:
+		return 1, 1
+	case code.OpPop, code.OpJumpNotTruthy, code.OpSetGlobal, code.OpSetLocal, code.OpReturnValue:
+		return 1, 0
+	case code.OpArray, code.OpHash, code.OpConcat:
+		return operands[0], 1
+	case code.OpCall:
+		// 函数本身和参数
+		return operands[0] + 1, 1
+	case code.OpClosure:
+		return operands[1], 1
+	}
+	return 0, 0
+}
@@ -0,0 +1,168 @@
+package cfg
+
+import (
+	"MyCompiler/src/code"
+	"fmt"
+	"sort"
+)
+
+// Exit 表示离开这段指令的边的目标 (返回或者执行到指令末尾)
+const Exit = -1
+
+// EdgeKind 边的种类
+type EdgeKind int
+
+const (
+	Fallthrough EdgeKind = iota // 顺序执行到下一个基本块
+	Jump                        // OpJump 无条件跳转
+	Branch                      // OpJumpNotTruthy 栈顶不为真时跳转
+	Return                      // OpReturnValue 或 OpReturn
+)
+
+func (k EdgeKind) String() string {
+	switch k {
+	case Fallthrough:
+		return "fallthrough"
+	case Jump:
+		return "jump"
+	case Branch:
+		return "branch"
+	case Return:
+		return "return"
+	}
+	return fmt.Sprintf("EdgeKind(%d)", int(k))
+}
+
+// Edge 基本块之间的边 To为目标基本块的下标或Exit
+type Edge struct {
+	To   int
+	Kind EdgeKind
+}
+
+// Block 基本块 只能从第一条指令进入、从最后一条指令离开的一段指令
+type Block struct {
+	Index int
+	Start int    // 第一条指令的位置
+	End   int    // 最后一条指令之后的位置
+	Succs []Edge // 后继 条件跳转时先是Branch再是Fallthrough
+	Preds []int  // 前驱基本块的下标 按下标排序
+}
+
+// Graph 一段指令的控制流图 Blocks按位置排序，Blocks[0]是入口
+type Graph struct {
+	Blocks []*Block
+}
+
+// Build 把指令切分成基本块并连接成控制流图
+// 跳转目标和跳转、返回之后的指令开始新的基本块
+// 指令无法解码或者跳转目标不是指令边界时返回error
+func Build(ins code.Instructions) (*Graph, error) {
+	boundaries := map[int]bool{len(ins): true}
+	leaders := map[int]bool{0: true}
+
+	for i := 0; i < len(ins); {
+		_, operands, width, err := code.ReadInstruction(ins, i)
+		if err != nil {
+			return nil, fmt.Errorf("%04d: %s", i, err)
+		}
+		boundaries[i] = true
+
+		op, _ := code.OpcodeAt(ins, i)
+		switch op {
+		case code.OpJump, code.OpJumpNotTruthy:
+			leaders[operands[0]] = true
+			leaders[i+width] = true
+		case code.OpReturnValue, code.OpReturn:
+			leaders[i+width] = true
+		}
+		i += width
+	}
+
+	var starts []int
+	for pos := range leaders {
+		if !boundaries[pos] {
+			return nil, fmt.Errorf("jump to %04d, which is not an instruction boundary", pos)
+		}
+		if pos < len(ins) {
+			starts = append(starts, pos)
+		}
+	}
+	sort.Ints(starts)
+
+	g := &Graph{}
+	index := make(map[int]int, len(starts)) // 起始位置 -> 基本块下标
+	for i, start := range starts {
+		end := len(ins)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		g.Blocks = append(g.Blocks, &Block{Index: i, Start: start, End: end})
+		index[start] = i
+	}
+
+	// 指向pos的边 pos为指令末尾时离开这段指令
+	target := func(pos int) int {
+		if pos == len(ins) {
+			return Exit
+		}
+		return index[pos]
+	}
+
+	for _, b := range g.Blocks {
+		last := lastInstruction(ins, b)
+		op, _ := code.OpcodeAt(ins, last)
+		_, operands, _, _ := code.ReadInstruction(ins, last)
+
+		switch op {
+		case code.OpJump:
+			b.Succs = []Edge{{target(operands[0]), Jump}}
+		case code.OpJumpNotTruthy:
+			b.Succs = []Edge{{target(operands[0]), Branch}, {target(b.End), Fallthrough}}
+		case code.OpReturnValue, code.OpReturn:
+			b.Succs = []Edge{{Exit, Return}}
+		default:
+			b.Succs = []Edge{{target(b.End), Fallthrough}}
+		}
+
+		for _, e := range b.Succs {
+			if e.To != Exit {
+				succ := g.Blocks[e.To]
+				succ.Preds = appendUnique(succ.Preds, b.Index)
+			}
+		}
+	}
+	for _, b := range g.Blocks {
+		sort.Ints(b.Preds)
+	}
+
+	return g, nil
+}
+
+// Instructions 返回基本块中每条指令的位置
+func (b *Block) Instructions(ins code.Instructions) []int {
+	var positions []int
+	for i := b.Start; i < b.End; {
+		positions = append(positions, i)
+		_, _, width, _ := code.ReadInstruction(ins, i)
+		i += width
+	}
+	return positions
+}
+
+// region 帮助函数
+
+func lastInstruction(ins code.Instructions, b *Block) int {
+	positions := b.Instructions(ins)
+	return positions[len(positions)-1]
+}
+
+func appendUnique(list []int, n int) []int {
+	for _, x := range list {
+		if x == n {
+			return list
+		}
+	}
+	return append(list, n)
+}
+
+// endregion
@@ -13,6 +13,38 @@ type Opcode byte
 const (
 	OpConstant Opcode = iota
 	OpAdd
+	OpPop
+	OpSub
+	OpMul
+	OpDiv
+	OpTrue
+	OpFalse
+	OpEqual
+	OpNotEqual
+	OpGreaterThan
+	OpMinus
+	OpBang
+	OpJumpNotTruthy
+	OpJump
+	OpNull
+	OpGetGlobal
+	OpSetGlobal
+	OpArray
+	OpHash
+	OpIndex
+	OpCall
+	OpReturnValue
+	OpReturn
+	OpGetLocal
+	OpSetLocal
+	OpClosure
+	OpGetFree
+	OpCurrentClosure
+	OpGetBuiltin
+	OpWide
+	// 字节码文件里保存的是操作码的值 新的操作码只能追加在末尾
+	OpGreaterThanOrEqual
+	OpConcat
 )
 
 type Definition struct {
@@ -24,6 +56,48 @@ type Definition struct {
 var definitions = map[Opcode]*Definition{
 	OpConstant: {"OpConstant", []int{2}}, // OpConstant唯一的操作数有两字节宽
 	OpAdd:      {"OpAdd", []int{}},       // add操作没有操作数
+	OpPop:      {"OpPop", []int{}},       // 弹出栈顶元素
+	OpSub:      {"OpSub", []int{}},
+	OpMul:      {"OpMul", []int{}},
+	OpDiv:      {"OpDiv", []int{}},
+	OpTrue:     {"OpTrue", []int{}},  // 将true压入栈中
+	OpFalse:    {"OpFalse", []int{}}, // 将false压入栈中
+	// 比较操作 (小于号由编译器调换操作数顺序后用OpGreaterThan实现)
+	OpEqual:       {"OpEqual", []int{}},
+	OpNotEqual:    {"OpNotEqual", []int{}},
+	OpGreaterThan: {"OpGreaterThan", []int{}},
+	// 小于等于同样调换操作数后用OpGreaterThanOrEqual实现
+	OpGreaterThanOrEqual: {"OpGreaterThanOrEqual", []int{}},
+	// 模板字符串 操作数为片段个数 把栈顶的这些值按Inspect拼接成一个字符串
+	OpConcat: {"OpConcat", []int{2}},
+	// 前缀操作
+	OpMinus: {"OpMinus", []int{}},
+	OpBang:  {"OpBang", []int{}},
+	// 跳转 操作数为跳转目标在指令集中的绝对位置
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}}, // 栈顶不为真时跳转
+	OpJump:          {"OpJump", []int{2}},          // 无条件跳转
+	OpNull:          {"OpNull", []int{}},           // 将null压入栈中
+	// 全局变量 操作数为变量在全局存储中的索引
+	OpGetGlobal: {"OpGetGlobal", []int{2}},
+	OpSetGlobal: {"OpSetGlobal", []int{2}},
+	// 复合数据类型
+	OpArray: {"OpArray", []int{2}}, // 操作数为数组元素个数
+	OpHash:  {"OpHash", []int{2}},  // 操作数为键和值的总个数
+	OpIndex: {"OpIndex", []int{}},  // 栈顶为索引，其下为被索引的对象
+	// 函数
+	OpCall:        {"OpCall", []int{1}},       // 操作数为参数个数
+	OpReturnValue: {"OpReturnValue", []int{}}, // 以栈顶的值作为返回值返回
+	OpReturn:      {"OpReturn", []int{}},      // 没有返回值，返回null
+	// 局部变量 操作数为变量在当前帧中的索引
+	OpGetLocal: {"OpGetLocal", []int{1}},
+	OpSetLocal: {"OpSetLocal", []int{1}},
+	// 闭包
+	OpClosure:        {"OpClosure", []int{2, 1}},    // 操作数为函数在常量池中的索引和自由变量个数
+	OpGetFree:        {"OpGetFree", []int{1}},       // 操作数为自由变量在闭包中的索引
+	OpCurrentClosure: {"OpCurrentClosure", []int{}}, // 将正在执行的闭包压入栈中(用于递归)
+	OpGetBuiltin:     {"OpGetBuiltin", []int{1}},    // 操作数为内置函数在object.Builtins中的下标
+	// 前缀 紧跟其后的指令的每个操作数宽度加倍 (1->2, 2->4)
+	OpWide: {"OpWide", []int{}},
 }
 
 func Lookup(op Opcode) (*Definition, error) {
@@ -63,11 +137,7 @@ func Make(op Opcode, operands ...int) []byte {
 	for i, o := range operands {
 		// 获取第i个操作数的宽度
 		width := def.OperandWidths[i]
-		switch width {
-		case 2:
-			// 如果操作数的宽度是2，用大端序把操作数写入instruction
-			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
-		}
+		putOperand(instruction[offset:], width, o)
 		// 移到下一个位置
 		offset += width
 	}
@@ -75,62 +145,196 @@ func Make(op Opcode, operands ...int) []byte {
 	return instruction
 }
 
+// MakeWide 编码带OpWide前缀的指令 每个操作数的宽度是正常宽度的两倍
+func MakeWide(op Opcode, operands ...int) []byte {
+	def, err := Lookup(op)
+	if err != nil {
+		return []byte{}
+	}
+
+	widths := WideWidths(def)
+
+	instructionLen := 2
+	for _, w := range widths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(OpWide)
+	instruction[1] = byte(op)
+
+	offset := 2
+	for i, o := range operands {
+		putOperand(instruction[offset:], widths[i], o)
+		offset += widths[i]
+	}
+	return instruction
+}
+
+// WideWidths 返回带OpWide前缀时各操作数的宽度
+func WideWidths(def *Definition) []int {
+	widths := make([]int, len(def.OperandWidths))
+	for i, w := range def.OperandWidths {
+		widths[i] = w * 2
+	}
+	return widths
+}
+
+// Fits 判断operand能否用width字节的无符号数表示
+func Fits(width int, operand int) bool {
+	if operand < 0 {
+		return false
+	}
+	return uint64(operand) < uint64(1)<<(8*uint(width))
+}
+
+// 用大端序把操作数写入ins
+func putOperand(ins []byte, width int, operand int) {
+	switch width {
+	case 4:
+		binary.BigEndian.PutUint32(ins, uint32(operand))
+	case 2:
+		binary.BigEndian.PutUint16(ins, uint16(operand))
+	case 1:
+		ins[0] = byte(operand)
+	}
+}
+
 // ReadOperands 从指令中读取操作数
 // 返回操作数数组和操作数总长度
 func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
-	operands := make([]int, len(def.OperandWidths))
+	return readOperands(def.OperandWidths, ins)
+}
+
+// ReadOperandsWide 读取带OpWide前缀的指令的操作数
+func ReadOperandsWide(def *Definition, ins Instructions) ([]int, int) {
+	return readOperands(WideWidths(def), ins)
+}
+
+func readOperands(widths []int, ins Instructions) ([]int, int) {
+	operands := make([]int, len(widths))
 	offset := 0
 
-	for i, width := range def.OperandWidths {
-		switch width {
-		case 2:
-			operands[i] = int(ReadUint16(ins[offset:]))
-		}
+	for i, width := range widths {
+		operands[i] = ReadOperand(ins[offset:], width)
 		offset += width
 	}
 	return operands, offset
 }
 
+// ReadOperand 读取一个宽度为width的操作数
+func ReadOperand(ins Instructions, width int) int {
+	switch width {
+	case 4:
+		return int(ReadUint32(ins))
+	case 2:
+		return int(ReadUint16(ins))
+	case 1:
+		return int(ReadUint8(ins))
+	}
+	return 0
+}
+
+func ReadUint32(ins Instructions) uint32 {
+	return binary.BigEndian.Uint32(ins)
+}
+
 func ReadUint16(ins Instructions) uint16 {
 	return binary.BigEndian.Uint16(ins)
 }
 
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
+
+// OpcodeAt 返回位置pos上指令的操作码 带OpWide前缀时返回被前缀的操作码，wide为true
+func OpcodeAt(ins Instructions, pos int) (op Opcode, wide bool) {
+	if Opcode(ins[pos]) == OpWide && pos+1 < len(ins) {
+		return Opcode(ins[pos+1]), true
+	}
+	return Opcode(ins[pos]), false
+}
+
+// ReadInstruction 解码位置pos上的一条指令 (包括OpWide前缀)
+// 返回操作码定义、操作数和整条指令的长度；遇到未知操作码或操作数不完整时返回error
+func ReadInstruction(ins Instructions, pos int) (*Definition, []int, int, error) {
+	start := pos
+	wide := false
+	if Opcode(ins[pos]) == OpWide {
+		if pos+1 >= len(ins) {
+			return nil, nil, 1, fmt.Errorf("OpWide at end of instructions")
+		}
+		wide = true
+		pos++
+	}
+
+	def, err := Lookup(Opcode(ins[pos]))
+	if err != nil {
+		return nil, nil, pos - start + 1, err
+	}
+
+	widths := def.OperandWidths
+	if wide {
+		if len(widths) == 0 {
+			return nil, nil, 2, fmt.Errorf("OpWide cannot prefix %s", def.Name)
+		}
+		widths = WideWidths(def)
+	}
+
+	operandsLen := 0
+	for _, w := range widths {
+		operandsLen += w
+	}
+	if pos+1+operandsLen > len(ins) {
+		return def, nil, len(ins) - start,
+			fmt.Errorf("truncated operands for %s: want %d bytes, got %d",
+				def.Name, operandsLen, len(ins)-pos-1)
+	}
+
+	operands, read := readOperands(widths, ins[pos+1:])
+	return def, operands, pos - start + 1 + read, nil
+}
+
 func (self Instructions) String() string {
 	var out bytes.Buffer
 
 	i := 0
 	for i < len(self) {
-		def, err := Lookup(Opcode(self[i]))
+		def, operands, width, err := ReadInstruction(self, i)
 		if err != nil {
-			fmt.Fprintf(&out, "ERROR: %s\n", err)
-			continue
+			fmt.Fprintf(&out, "%04d ERROR: %s\n", i, err)
+		} else {
+			_, wide := OpcodeAt(self, i)
+			fmt.Fprintf(&out, "%04d %s\n", i, formatPrefix(wide)+FormatInstruction(def, operands))
 		}
 
-		operands, read := ReadOperands(def, self[i+1:])
-
-		fmt.Fprintf(&out, "%04d %s\n", i, self.fmtInstruction(def, operands))
-
-		// i移动到下一个位置
-		i += 1 + read
+		// i移动到下一条指令 出错时也要前进，否则会死循环
+		i += width
 	}
 	return out.String()
 }
 
-func (self Instructions) fmtInstruction(def *Definition, operands []int) string {
+func formatPrefix(wide bool) string {
+	if wide {
+		return "OpWide "
+	}
+	return ""
+}
+
+// FormatInstruction 把操作码和操作数格式化成 "名称 操作数..." 的形式
+func FormatInstruction(def *Definition, operands []int) string {
 	// 有几个操作数
 	operandCount := len(def.OperandWidths)
 
 	if len(operands) != operandCount {
-		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n",
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d",
 			len(operands), operandCount)
 	}
 
-	switch operandCount {
-	case 1:
-		return fmt.Sprintf("%s %d", def.Name, operands[0])
-	case 0:
-		return def.Name // 如果没有操作数就直接返回指令名称
+	var out bytes.Buffer
+	out.WriteString(def.Name)
+	for _, o := range operands {
+		fmt.Fprintf(&out, " %d", o)
 	}
-
-	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
+	return out.String()
 }
@@ -4,12 +4,41 @@ import (
 	"MyCompiler/src/ast"
 	"MyCompiler/src/code"
 	"MyCompiler/src/object"
+	"errors"
 	"fmt"
 )
 
 type Compiler struct {
-	instructions code.Instructions // 编译器编译后的指令存放在这里
-	constants    []object.Object   // 编译器计算后的常量放在这里
+	constants []object.Object // 编译器计算后的常量放在这里
+
+	symbolTable *SymbolTable // 符号表
+
+	scopes     []CompilationScope // 编译作用域 每进入一个函数体就压入一个新作用域
+	scopeIndex int                // 当前作用域的索引
+}
+
+// CompilationScope 编译作用域 每个函数体的指令单独存放
+type CompilationScope struct {
+	instructions        code.Instructions  // 编译后的指令存放在这里
+	lastInstruction     EmittedInstruction // 最后一条发出的指令
+	previousInstruction EmittedInstruction // 倒数第二条发出的指令
+
+	loops []*loopScope // 正在编译的循环 最内层的在最后；函数体中的break不能跳出函数外的循环
+
+	wideJumps bool // 向前跳转的占位指令都用OpWide前缀 回填的目标放不进两字节时打开
+}
+
+// 正在编译的循环 记录需要回填的跳转指令的位置
+type loopScope struct {
+	breaks    []int // 跳到循环之后 包括条件不成立时的跳转
+	continues []int // 跳到下一次迭代
+	values    int   // 正在编译的、值要留在栈上的表达式层数 不为0时不能break或continue
+}
+
+// EmittedInstruction 记录已经发出的指令
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int // 指令在指令集中的位置
 }
 
 type ByteCode struct {
@@ -18,12 +47,35 @@ type ByteCode struct {
 }
 
 func New() *Compiler {
+	mainScope := CompilationScope{
+		instructions:        code.Instructions{},
+		lastInstruction:     EmittedInstruction{},
+		previousInstruction: EmittedInstruction{},
+	}
+
+	symbolTable := NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+
 	return &Compiler{
-		instructions: code.Instructions{},
-		constants:    []object.Object{},
+		constants:   []object.Object{},
+		symbolTable: symbolTable,
+		scopes:      []CompilationScope{mainScope},
+		scopeIndex:  0,
 	}
 }
 
+// NewWithState 使用已有的符号表和常量池创建编译器
+// REPL中每行输入都会新建编译器，需要沿用之前的状态
+// 符号表s需要由调用者预先定义好内置函数
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	compiler := New()
+	compiler.symbolTable = s
+	compiler.constants = constants
+	return compiler
+}
+
 // 进行编译
 func (self *Compiler) Compile(node ast.Node) error {
 	// 根据node的类别编译
@@ -37,11 +89,41 @@ func (self *Compiler) Compile(node ast.Node) error {
 			}
 		}
 	case *ast.ExpressionStatement:
-		err := self.Compile(node.Expression)
+		var err error
+		if ifExpression, ok := node.Expression.(*ast.IfExpression); ok {
+			// 语句位置的if 分支中可以break和continue
+			err = self.withWideJumps(func() error { return self.compileIfExpression(ifExpression) })
+		} else {
+			err = self.compileValue(node.Expression)
+		}
 		if err != nil {
 			return err
 		}
+		// 表达式语句的值用不上，需要从栈中弹出，防止栈越来越高
+		self.emit(code.OpPop)
 	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return self.withWideJumps(func() error { return self.compileLogicalExpression(node) })
+		}
+
+		if node.Operator == "<" || node.Operator == "<=" {
+			// 小于号调换左右操作数的顺序，转化成大于号
+			err := self.Compile(node.Right)
+			if err != nil {
+				return err
+			}
+			err = self.Compile(node.Left)
+			if err != nil {
+				return err
+			}
+			if node.Operator == "<" {
+				self.emit(code.OpGreaterThan)
+			} else {
+				self.emit(code.OpGreaterThanOrEqual)
+			}
+			return nil
+		}
+
 		err := self.Compile(node.Left)
 		if err != nil {
 			return err
@@ -54,24 +136,542 @@ func (self *Compiler) Compile(node ast.Node) error {
 		switch node.Operator {
 		case "+":
 			self.emit(code.OpAdd)
+		case "-":
+			self.emit(code.OpSub)
+		case "*":
+			self.emit(code.OpMul)
+		case "/":
+			self.emit(code.OpDiv)
+		case ">":
+			self.emit(code.OpGreaterThan)
+		case ">=":
+			self.emit(code.OpGreaterThanOrEqual)
+		case "==":
+			self.emit(code.OpEqual)
+		case "!=":
+			self.emit(code.OpNotEqual)
+		default:
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+	case *ast.PrefixExpression:
+		err := self.Compile(node.Right)
+		if err != nil {
+			return err
+		}
+
+		switch node.Operator {
+		case "!":
+			self.emit(code.OpBang)
+		case "-":
+			self.emit(code.OpMinus)
 		default:
 			return fmt.Errorf("unknown operator %s", node.Operator)
 		}
+	case *ast.FloatLiteral:
+		lit := &object.Float{Value: node.Value}
+		_, err := self.emit(code.OpConstant, self.addConstant(lit))
+		if err != nil {
+			return err
+		}
 	case *ast.IntegerLiteral:
 		integer := &object.Integer{Value: node.Value}
 		// 将integer加入常量池，并得到它的位置
 		pos := self.addConstant(integer)
 		// 将指令写入指令集, 操作数就是integer在常量池的索引
-		self.emit(code.OpConstant, pos)
+		_, err := self.emit(code.OpConstant, pos)
+		if err != nil {
+			return err
+		}
+	case *ast.IfExpression:
+		err := self.withWideJumps(func() error { return self.compileIfExpression(node) })
+		if err != nil {
+			return err
+		}
+	case *ast.BadStatement:
+		return fmt.Errorf("cannot compile statement with syntax errors at %s", node.Pos())
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			err := self.Compile(s)
+			if err != nil {
+				return err
+			}
+		}
+	case *ast.LetStatement:
+		err := self.compileValue(node.Value)
+		if err != nil {
+			return err
+		}
+
+		// 先编译值再定义符号 函数对自身的引用通过FunctionScope解析
+		symbol := self.symbolTable.DefineVariable(node.Name.Value)
+
+		if symbol.Scope == GlobalScope {
+			_, err = self.emit(code.OpSetGlobal, symbol.Index)
+		} else {
+			_, err = self.emit(code.OpSetLocal, symbol.Index)
+		}
+		if err != nil {
+			return err
+		}
+	case *ast.WhileStatement:
+		err := self.withWideJumps(func() error { return self.compileWhileStatement(node) })
+		if err != nil {
+			return err
+		}
+		// 循环的值为null 和求值器一致，也让最后弹出的对象不是循环条件
+		self.emit(code.OpNull)
+		self.emit(code.OpPop)
+	case *ast.ForStatement:
+		err := self.withWideJumps(func() error { return self.compileForStatement(node) })
+		if err != nil {
+			return err
+		}
+		// 循环的值为null 和求值器一致，也让最后弹出的对象不是循环条件
+		self.emit(code.OpNull)
+		self.emit(code.OpPop)
+	case *ast.BreakStatement:
+		loop := self.currentLoop()
+		if loop == nil {
+			return fmt.Errorf("break outside loop at %s", node.Pos())
+		}
+		if loop.values != 0 {
+			return fmt.Errorf("break inside expression at %s", node.Pos())
+		}
+		pos, err := self.emitForwardJump(code.OpJump)
+		if err != nil {
+			return err
+		}
+		loop.breaks = append(loop.breaks, pos)
+	case *ast.ContinueStatement:
+		loop := self.currentLoop()
+		if loop == nil {
+			return fmt.Errorf("continue outside loop at %s", node.Pos())
+		}
+		if loop.values != 0 {
+			return fmt.Errorf("continue inside expression at %s", node.Pos())
+		}
+		pos, err := self.emitForwardJump(code.OpJump)
+		if err != nil {
+			return err
+		}
+		loop.continues = append(loop.continues, pos)
+	case *ast.Identifier:
+		symbol, ok := self.symbolTable.Resolve(node.Value)
+		if !ok {
+			// 未定义的变量在编译期报错
+			return fmt.Errorf("undefined variable %s", node.Value)
+		}
+		err := self.loadSymbol(symbol)
+		if err != nil {
+			return err
+		}
+	case *ast.FnExpression:
+		err := self.compileFnExpression(node)
+		if err != nil {
+			return err
+		}
+	case *ast.ReturnStatement:
+		err := self.compileValue(node.ReturnValue)
+		if err != nil {
+			return err
+		}
+		self.emit(code.OpReturnValue)
+	case *ast.CallExpression:
+		err := self.Compile(node.Function)
+		if err != nil {
+			return err
+		}
+
+		// 参数依次压栈
+		for _, a := range node.Arguments {
+			err := self.Compile(a)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = self.emit(code.OpCall, len(node.Arguments))
+		if err != nil {
+			return err
+		}
+	case *ast.StringLiteral:
+		str := &object.String{Value: node.Value}
+		_, err := self.emit(code.OpConstant, self.addConstant(str))
+		if err != nil {
+			return err
+		}
+	case *ast.TemplateLiteral:
+		// 依次压入每个片段 空的字符串片段省略
+		count := 0
+		for i, s := range node.Strings {
+			if s != "" {
+				_, err := self.emit(code.OpConstant, self.addConstant(&object.String{Value: s}))
+				if err != nil {
+					return err
+				}
+				count++
+			}
+			if i < len(node.Values) {
+				err := self.Compile(node.Values[i])
+				if err != nil {
+					return err
+				}
+				count++
+			}
+		}
+		_, err := self.emit(code.OpConcat, count)
+		if err != nil {
+			return err
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			err := self.Compile(el)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := self.emit(code.OpArray, len(node.Elements))
+		if err != nil {
+			return err
+		}
+	case *ast.HashLiteral:
+		// Pairs是map，遍历顺序不固定 按键在源码中的顺序编译，保证生成的指令稳定，
+		// 重复的键也总是后面的覆盖前面的
+		keys := node.Keys()
+
+		for _, k := range keys {
+			err := self.Compile(k)
+			if err != nil {
+				return err
+			}
+			err = self.Compile(node.Pairs[k])
+			if err != nil {
+				return err
+			}
+		}
+		_, err := self.emit(code.OpHash, len(node.Pairs)*2)
+		if err != nil {
+			return err
+		}
+	case *ast.IndexExpression:
+		err := self.Compile(node.Left)
+		if err != nil {
+			return err
+		}
+		err = self.Compile(node.Index)
+		if err != nil {
+			return err
+		}
+		self.emit(code.OpIndex)
+	case *ast.BooleanLiteral:
+		if node.Value {
+			self.emit(code.OpTrue)
+		} else {
+			self.emit(code.OpFalse)
+		}
+	}
+	return nil
+}
+
+// 编译if表达式
+// 生成的指令结构为:
+//
+//	<条件>
+//	OpJumpNotTruthy <else分支>
+//	<consequence>
+//	OpJump <结尾>
+//	<alternative 或 OpNull>
+func (self *Compiler) compileIfExpression(node *ast.IfExpression) error {
+	err := self.compileValue(node.Condition)
+	if err != nil {
+		return err
+	}
+
+	// 先用当前位置占位，等consequence编译完再回填
+	jumpNotTruthyPos, err := self.emitForwardJump(code.OpJumpNotTruthy)
+	if err != nil {
+		return err
+	}
+
+	err = self.Compile(node.Consequence)
+	if err != nil {
+		return err
+	}
+	// if表达式需要留下一个值
+	self.keepBlockValue()
+
+	jumpPos, err := self.emitForwardJump(code.OpJump)
+	if err != nil {
+		return err
+	}
+
+	// 回填条件跳转的位置
+	afterConsequencePos := len(self.currentInstructions())
+	err = self.changeOperand(jumpNotTruthyPos, afterConsequencePos)
+	if err != nil {
+		return err
+	}
+
+	if node.Alternative == nil {
+		// 没有else分支时 if表达式的值为null
+		self.emit(code.OpNull)
+	} else {
+		err := self.Compile(node.Alternative)
+		if err != nil {
+			return err
+		}
+		self.keepBlockValue()
+	}
+
+	// 回填无条件跳转的位置
+	afterAlternativePos := len(self.currentInstructions())
+	err = self.changeOperand(jumpPos, afterAlternativePos)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// 编译while循环 循环结束时栈上不留下值
+//
+//	开始:                          continue跳到这里
+//	  <条件>
+//	  OpJumpNotTruthy <结尾>
+//	  <循环体>
+//	  OpJump <开始>
+//	结尾:                          break跳到这里
+func (self *Compiler) compileWhileStatement(node *ast.WhileStatement) error {
+	start := len(self.currentInstructions())
+	err := self.compileValue(node.Condition)
+	if err != nil {
+		return err
+	}
+	exitPos, err := self.emitForwardJump(code.OpJumpNotTruthy)
+	if err != nil {
+		return err
+	}
+
+	loop := self.enterLoop()
+	loop.breaks = append(loop.breaks, exitPos)
+	err = self.Compile(node.Body)
+	if err != nil {
+		return err
 	}
+	_, err = self.emit(code.OpJump, start)
+	if err != nil {
+		return err
+	}
+
+	return self.leaveLoop(start, len(self.currentInstructions()))
+}
+
+// 编译for循环 没有条件时省略条件跳转
+//
+//	  <init>
+//	开始:
+//	  <条件>
+//	  OpJumpNotTruthy <结尾>
+//	  <循环体>
+//	继续:                          continue跳到这里
+//	  <update>
+//	  OpJump <开始>
+//	结尾:                          break跳到这里
+func (self *Compiler) compileForStatement(node *ast.ForStatement) error {
+	if node.Init != nil {
+		err := self.Compile(node.Init)
+		if err != nil {
+			return err
+		}
+	}
+
+	start := len(self.currentInstructions())
+	var exits []int
+	if node.Condition != nil {
+		err := self.compileValue(node.Condition)
+		if err != nil {
+			return err
+		}
+		exitPos, err := self.emitForwardJump(code.OpJumpNotTruthy)
+		if err != nil {
+			return err
+		}
+		exits = append(exits, exitPos)
+	}
+
+	loop := self.enterLoop()
+	loop.breaks = append(loop.breaks, exits...)
+	err := self.Compile(node.Body)
+	if err != nil {
+		return err
+	}
+
+	next := len(self.currentInstructions())
+	if node.Update != nil {
+		err := self.Compile(node.Update)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = self.emit(code.OpJump, start)
+	if err != nil {
+		return err
+	}
+
+	return self.leaveLoop(next, len(self.currentInstructions()))
+}
+
+// 编译短路的 && 和 ||，结果总是布尔值
+//
+//	a && b:                          a || b:
+//	  <a>                              <a>
+//	  OpJumpNotTruthy <false>          OpJumpNotTruthy <right>
+//	  <b>                              OpTrue
+//	  OpBang OpBang                    OpJump <结尾>
+//	  OpJump <结尾>                  right:
+//	false:                             <b>
+//	  OpFalse                          OpBang OpBang
+//	结尾:                            结尾:
+//
+// 两次OpBang把b的值按真值规则转换成布尔值
+func (self *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	err := self.Compile(node.Left)
+	if err != nil {
+		return err
+	}
+
+	jumpNotTruthyPos, err := self.emitForwardJump(code.OpJumpNotTruthy)
+	if err != nil {
+		return err
+	}
+
+	if node.Operator == "||" {
+		// 左边为真时直接得到true
+		self.emit(code.OpTrue)
+	} else {
+		err = self.compileTruthValue(node.Right)
+		if err != nil {
+			return err
+		}
+	}
+
+	jumpPos, err := self.emitForwardJump(code.OpJump)
+	if err != nil {
+		return err
+	}
+
+	err = self.changeOperand(jumpNotTruthyPos, len(self.currentInstructions()))
+	if err != nil {
+		return err
+	}
+
+	if node.Operator == "||" {
+		err = self.compileTruthValue(node.Right)
+		if err != nil {
+			return err
+		}
+	} else {
+		// 左边为假时直接得到false
+		self.emit(code.OpFalse)
+	}
+
+	return self.changeOperand(jumpPos, len(self.currentInstructions()))
+}
+
+// 编译表达式并把结果转换成布尔值
+func (self *Compiler) compileTruthValue(node ast.Expression) error {
+	err := self.Compile(node)
+	if err != nil {
+		return err
+	}
+	self.emit(code.OpBang)
+	self.emit(code.OpBang)
 	return nil
 }
 
+// 编译函数字面量
+// 函数体编译到单独的作用域中，结果作为CompiledFunction放入常量池，运行时由OpClosure包装成闭包
+func (self *Compiler) compileFnExpression(node *ast.FnExpression) error {
+	self.enterScope()
+
+	if node.Name != "" {
+		self.symbolTable.DefineFunctionName(node.Name)
+	}
+
+	// 参数是函数的前几个局部变量
+	for _, p := range node.Parameters {
+		self.symbolTable.Define(p.Value)
+	}
+
+	err := self.Compile(node.Body)
+	if err != nil {
+		return err
+	}
+
+	// 最后一条表达式语句的值作为函数的返回值
+	if self.lastInstructionIs(code.OpPop) {
+		self.replaceLastPopWithReturn()
+	}
+	// 函数体为空或者最后不是表达式时，返回null
+	if !self.lastInstructionIs(code.OpReturnValue) {
+		self.emit(code.OpReturn)
+	}
+
+	freeSymbols := self.symbolTable.FreeSymbols
+	numLocals := self.symbolTable.numDefinitions
+	instructions := self.leaveScope()
+
+	// 在外层作用域中把自由变量依次压栈，由OpClosure捕获
+	for _, sym := range freeSymbols {
+		err := self.loadSymbol(sym)
+		if err != nil {
+			return err
+		}
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Instructions:  instructions,
+		NumLocals:     numLocals,
+		NumParameters: len(node.Parameters),
+	}
+	fnIndex := self.addConstant(compiledFn)
+	_, err = self.emit(code.OpClosure, fnIndex, len(freeSymbols))
+	return err
+}
+
+// 按作用域发出读取符号的指令
+func (self *Compiler) loadSymbol(s Symbol) error {
+	var err error
+	switch s.Scope {
+	case GlobalScope:
+		_, err = self.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		_, err = self.emit(code.OpGetLocal, s.Index)
+	case FreeScope:
+		_, err = self.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		_, err = self.emit(code.OpCurrentClosure)
+	case BuiltinScope:
+		_, err = self.emit(code.OpGetBuiltin, s.Index)
+	}
+	return err
+}
+
+// 让语句块在栈上留下一个值
+// 去掉最后一条表达式语句的OpPop；如果语句块没有产生值(比如为空)，就用null代替
+func (self *Compiler) keepBlockValue() {
+	if self.lastInstructionIs(code.OpPop) {
+		self.removeLastPop()
+	} else {
+		self.emit(code.OpNull)
+	}
+}
+
 // 将编译结果转化成字节码结构输出
 func (self *Compiler) Bytecode() *ByteCode {
 	return &ByteCode{
-		Instructions: self.instructions, // 将编译器生成的指令给到字节码结构
-		Constants:    self.constants,    // 将编译器计算的常量给字节码结构
+		Instructions: self.currentInstructions(), // 将编译器生成的指令给到字节码结构
+		Constants:    self.constants,             // 将编译器计算的常量给字节码结构
 	}
 }
 
@@ -86,15 +686,263 @@ func (self *Compiler) addConstant(obj object.Object) int {
 // 返回instruction在指令集合中的位置
 func (self *Compiler) addInstruction(ins []byte) int {
 	// 记住原来的位置
-	ret := len(self.instructions)
-	self.instructions = append(self.instructions, ins...)
+	ret := len(self.currentInstructions())
+	updated := append(self.currentInstructions(), ins...)
+	self.scopes[self.scopeIndex].instructions = updated
 	return ret
 }
 
 // 将操作码和操作数转换成指令加入Instruction中
+// 操作数超出正常宽度时加上OpWide前缀，加倍后仍放不下则返回error
 // 返回指令在指令集合中的位置
-func (self *Compiler) emit(op code.Opcode, operands ...int) int {
-	ins := code.Make(op, operands...)
+func (self *Compiler) emit(op code.Opcode, operands ...int) (int, error) {
+	def, err := code.Lookup(op)
+	if err != nil {
+		return 0, err
+	}
+
+	var ins []byte
+	switch {
+	case operandsFit(def.OperandWidths, operands):
+		ins = code.Make(op, operands...)
+	case operandsFit(code.WideWidths(def), operands):
+		ins = code.MakeWide(op, operands...)
+	default:
+		return 0, fmt.Errorf("operand out of range for %s: %v", def.Name, operands)
+	}
+
 	pos := self.addInstruction(ins)
-	return pos
+
+	self.setLastInstruction(op, pos)
+
+	return pos, nil
 }
+
+// 判断每个操作数是否都能放进对应的宽度
+func operandsFit(widths []int, operands []int) bool {
+	for i, o := range operands {
+		if !code.Fits(widths[i], o) {
+			return false
+		}
+	}
+	return true
+}
+
+// 记录最后发出的两条指令
+func (self *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	previous := self.scopes[self.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+
+	self.scopes[self.scopeIndex].previousInstruction = previous
+	self.scopes[self.scopeIndex].lastInstruction = last
+}
+
+// 判断最后一条指令是否是op
+func (self *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(self.currentInstructions()) == 0 {
+		return false
+	}
+	return self.scopes[self.scopeIndex].lastInstruction.Opcode == op
+}
+
+// 移除最后一条OpPop指令
+func (self *Compiler) removeLastPop() {
+	last := self.scopes[self.scopeIndex].lastInstruction
+	previous := self.scopes[self.scopeIndex].previousInstruction
+
+	old := self.currentInstructions()
+	self.scopes[self.scopeIndex].instructions = old[:last.Position]
+	self.scopes[self.scopeIndex].lastInstruction = previous
+}
+
+// 把最后一条OpPop替换为OpReturnValue
+func (self *Compiler) replaceLastPopWithReturn() {
+	lastPos := self.scopes[self.scopeIndex].lastInstruction.Position
+	self.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+
+	self.scopes[self.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+// 用新指令替换pos位置上的指令 新旧指令长度必须一致
+func (self *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := self.currentInstructions()
+	for i := 0; i < len(newInstruction); i++ {
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+// 修改pos位置上只有一个操作数的指令(跳转)的操作数 新操作数必须能放进原来的宽度
+func (self *Compiler) changeOperand(pos int, operand int) error {
+	op, wide := code.OpcodeAt(self.currentInstructions(), pos)
+	def, err := code.Lookup(op)
+	if err != nil {
+		return err
+	}
+	if len(def.OperandWidths) != 1 {
+		return fmt.Errorf("cannot change operand of %s at %04d", def.Name, pos)
+	}
+
+	widths, makeFn := def.OperandWidths, code.Make
+	if wide {
+		widths, makeFn = code.WideWidths(def), code.MakeWide
+	}
+	if !operandsFit(widths, []int{operand}) {
+		return &operandRangeError{name: def.Name, operand: operand}
+	}
+
+	newInstruction := makeFn(op, operand)
+	self.replaceInstruction(pos, newInstruction)
+	return nil
+}
+
+// 回填的操作数放不进占位指令的宽度
+type operandRangeError struct {
+	name    string
+	operand int
+}
+
+func (e *operandRangeError) Error() string {
+	return fmt.Sprintf("operand out of range for %s: %d", e.name, e.operand)
+}
+
+// region 向前跳转
+
+// 发出向前跳转的占位指令 先用当前位置占位，目标确定后用changeOperand回填
+// 当前位置放不进两字节时emit会直接选用宽指令
+func (self *Compiler) emitForwardJump(op code.Opcode) (int, error) {
+	pos := len(self.currentInstructions())
+	if !self.scopes[self.scopeIndex].wideJumps {
+		return self.emit(op, pos)
+	}
+	self.addInstruction(code.MakeWide(op, pos))
+	self.setLastInstruction(op, pos)
+	return pos, nil
+}
+
+// 编译一段带向前跳转的代码
+// 占位时不知道目标有多远，回填时目标可能放不进两字节的占位指令；
+// 这时丢掉这一段生成的指令、常量和定义的符号，之后的占位都用宽指令，再重新编译一次
+func (self *Compiler) withWideJumps(compile func() error) error {
+	scope := self.scopes[self.scopeIndex]
+	start := len(scope.instructions)
+	numConstants := len(self.constants)
+	numDefined := len(self.symbolTable.defined)
+	// 外层循环中已经记录的break和continue 这一段里记录的要一起丢掉
+	type loopLen struct{ breaks, continues int }
+	loopLens := make([]loopLen, len(scope.loops))
+	for i, loop := range scope.loops {
+		loopLens[i] = loopLen{len(loop.breaks), len(loop.continues)}
+	}
+
+	err := compile()
+	var rangeErr *operandRangeError
+	if scope.wideJumps || !errors.As(err, &rangeErr) {
+		return err
+	}
+
+	current := &self.scopes[self.scopeIndex]
+	current.instructions = current.instructions[:start]
+	current.lastInstruction = scope.lastInstruction
+	current.previousInstruction = scope.previousInstruction
+	current.loops = current.loops[:len(scope.loops)]
+	for i, loop := range current.loops {
+		loop.breaks = loop.breaks[:loopLens[i].breaks]
+		loop.continues = loop.continues[:loopLens[i].continues]
+	}
+	self.constants = self.constants[:numConstants]
+	self.symbolTable.undoDefinitions(numDefined)
+
+	// 只有这一段用宽跳转 之后的占位仍然先用两字节
+	current.wideJumps = true
+	err = compile()
+	self.scopes[self.scopeIndex].wideJumps = scope.wideJumps
+	return err
+}
+
+// endregion
+
+// region 循环
+
+// 开始编译一个循环 循环体中的break和continue记录在返回的loopScope中
+func (self *Compiler) enterLoop() *loopScope {
+	loop := &loopScope{}
+	scope := &self.scopes[self.scopeIndex]
+	scope.loops = append(scope.loops, loop)
+	return loop
+}
+
+// 编译值要留在栈上的表达式
+// 其中的break和continue跳走时会把算了一半的操作数留在栈上，所以在编译期报错
+func (self *Compiler) compileValue(node ast.Expression) error {
+	if loop := self.currentLoop(); loop != nil {
+		loop.values++
+		defer func() { loop.values-- }()
+	}
+	return self.Compile(node)
+}
+
+// 当前作用域中最内层的循环 不在循环中时返回nil
+func (self *Compiler) currentLoop() *loopScope {
+	loops := self.scopes[self.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
+// 结束最内层的循环 把continue回填到next，把break回填到end
+func (self *Compiler) leaveLoop(next, end int) error {
+	scope := &self.scopes[self.scopeIndex]
+	loop := scope.loops[len(scope.loops)-1]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+
+	for _, pos := range loop.continues {
+		err := self.changeOperand(pos, next)
+		if err != nil {
+			return err
+		}
+	}
+	for _, pos := range loop.breaks {
+		err := self.changeOperand(pos, end)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// endregion
+
+// region 作用域
+
+// 当前作用域的指令
+func (self *Compiler) currentInstructions() code.Instructions {
+	return self.scopes[self.scopeIndex].instructions
+}
+
+// 进入新的编译作用域 同时创建嵌套的符号表
+func (self *Compiler) enterScope() {
+	scope := CompilationScope{
+		instructions:        code.Instructions{},
+		lastInstruction:     EmittedInstruction{},
+		previousInstruction: EmittedInstruction{},
+	}
+	self.scopes = append(self.scopes, scope)
+	self.scopeIndex++
+
+	self.symbolTable = NewEnclosedSymbolTable(self.symbolTable)
+}
+
+// 离开当前编译作用域 返回该作用域中编译出的指令
+func (self *Compiler) leaveScope() code.Instructions {
+	instructions := self.currentInstructions()
+
+	self.scopes = self.scopes[:len(self.scopes)-1]
+	self.scopeIndex--
+
+	self.symbolTable = self.symbolTable.Outer
+
+	return instructions
+}
+
+// endregion
@@ -0,0 +1,153 @@
+package compiler
+
+// SymbolScope 符号的作用域
+type SymbolScope string
+
+const (
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	FreeScope     SymbolScope = "FREE"     // 外层函数的局部变量
+	FunctionScope SymbolScope = "FUNCTION" // 正在定义的函数本身
+	BuiltinScope  SymbolScope = "BUILTIN"  // 内置函数
+)
+
+// Symbol 符号 记录标识符的名称、作用域以及在作用域中的索引
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+}
+
+// SymbolTable 符号表 编译期间把标识符映射到符号
+type SymbolTable struct {
+	Outer *SymbolTable // 外层符号表 全局符号表的Outer为nil
+
+	store          map[string]Symbol
+	numDefinitions int // 已定义的符号数量 也就是下一个符号的索引
+
+	FreeSymbols []Symbol // 引用到的外层局部变量 (按在闭包中的索引排列)
+
+	defined []definition // Define的记录 编译器重新编译一段代码之前用来撤销其中的定义
+}
+
+// Define的一条记录 撤销时恢复被覆盖的符号
+type definition struct {
+	name     string
+	previous Symbol
+	existed  bool
+}
+
+func NewSymbolTable() *SymbolTable {
+	s := make(map[string]Symbol)
+	return &SymbolTable{store: s}
+}
+
+// NewEnclosedSymbolTable 创建嵌套在outer中的符号表 (用于函数体)
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// Clone 复制符号表 复制品上的定义不会影响原来的符号表
+// REPL在编译每行输入之前保存一份，这一行失败时恢复，避免留下没有赋值的全局变量
+func (s *SymbolTable) Clone() *SymbolTable {
+	store := make(map[string]Symbol, len(s.store))
+	for name, symbol := range s.store {
+		store[name] = symbol
+	}
+	return &SymbolTable{
+		Outer:          s.Outer,
+		store:          store,
+		numDefinitions: s.numDefinitions,
+		FreeSymbols:    append([]Symbol(nil), s.FreeSymbols...),
+		defined:        append([]definition(nil), s.defined...),
+	}
+}
+
+// Define 定义一个新符号
+// 在全局符号表中定义的是全局变量，在嵌套符号表中定义的是局部变量
+func (s *SymbolTable) Define(name string) Symbol {
+	previous, existed := s.store[name]
+	s.defined = append(s.defined, definition{name: name, previous: previous, existed: existed})
+
+	symbol := Symbol{Name: name, Index: s.numDefinitions}
+	if s.Outer == nil {
+		symbol.Scope = GlobalScope
+	} else {
+		symbol.Scope = LocalScope
+	}
+
+	s.store[name] = symbol
+	s.numDefinitions++
+	return symbol
+}
+
+// 撤销第n次之后的Define 恢复被覆盖的同名符号
+func (s *SymbolTable) undoDefinitions(n int) {
+	for len(s.defined) > n {
+		d := s.defined[len(s.defined)-1]
+		s.defined = s.defined[:len(s.defined)-1]
+		if d.existed {
+			s.store[d.name] = d.previous
+		} else {
+			delete(s.store, d.name)
+		}
+		s.numDefinitions--
+	}
+}
+
+// DefineVariable 为let语句定义符号
+// 当前符号表中已经有同名的全局或局部变量时沿用它的索引，这样循环中可以用let更新变量；
+// 否则(包括同名的内置函数、自由变量和函数名)定义一个新符号
+func (s *SymbolTable) DefineVariable(name string) Symbol {
+	if symbol, ok := s.store[name]; ok && (symbol.Scope == GlobalScope || symbol.Scope == LocalScope) {
+		return symbol
+	}
+	return s.Define(name)
+}
+
+// DefineBuiltin 定义内置函数 index为内置函数在object.Builtins中的下标
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+// DefineFunctionName 在函数自己的符号表中定义函数名 使函数体可以引用自身
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+// 把外层的符号定义为当前符号表的自由变量
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1}
+	symbol.Scope = FreeScope
+
+	s.store[original.Name] = symbol
+	return symbol
+}
+
+// Resolve 查找符号 当前符号表找不到时递归向外查找
+// 在外层找到的非全局符号会变成当前符号表的自由变量
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	obj, ok := s.store[name]
+	if !ok && s.Outer != nil {
+		obj, ok = s.Outer.Resolve(name)
+		if !ok {
+			return obj, ok
+		}
+
+		if obj.Scope == GlobalScope || obj.Scope == BuiltinScope {
+			return obj, ok
+		}
+
+		free := s.defineFree(obj)
+		return free, true
+	}
+	return obj, ok
+}
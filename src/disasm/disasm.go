@@ -0,0 +1,167 @@
+package disasm
+
+import (
+	"MyCompiler/src/code"
+	"MyCompiler/src/compiler"
+	"MyCompiler/src/object"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Disassemble 反汇编字节码
+// 依次输出常量池、顶层指令和常量池中每个函数的指令
+func Disassemble(out io.Writer, bc *compiler.ByteCode) {
+	fmt.Fprintln(out, "== constants ==")
+	for i, c := range bc.Constants {
+		fmt.Fprintf(out, "%4d %s\n", i, formatConstant(c))
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "== main ==")
+	io.WriteString(out, Instructions(bc.Instructions, bc.Constants))
+
+	for i, c := range bc.Constants {
+		fn, ok := c.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		fmt.Fprintln(out)
+		fmt.Fprintf(out, "== fn#%d (params=%d, locals=%d) ==\n", i, fn.NumParameters, fn.NumLocals)
+		io.WriteString(out, Instructions(fn.Instructions, bc.Constants))
+	}
+}
+
+// Instructions 反汇编一段指令
+// 常量操作数会附上常量的值，跳转目标用标签标出；无法解码的字节会输出ERROR并跳过
+func Instructions(ins code.Instructions, constants []object.Object) string {
+	var out bytes.Buffer
+
+	labels := jumpLabels(ins)
+	boundaries := instructionBoundaries(ins)
+
+	i := 0
+	for i < len(ins) {
+		if label, ok := labels[i]; ok {
+			fmt.Fprintf(&out, "%s:\n", label)
+		}
+
+		text, comment, width := instruction(ins, i, constants, labels, boundaries)
+		if comment != "" {
+			fmt.Fprintf(&out, "  %04d %-24s ; %s\n", i, text, comment)
+		} else {
+			fmt.Fprintf(&out, "  %04d %s\n", i, text)
+		}
+
+		i += width
+	}
+
+	// 跳到指令末尾的标签
+	if label, ok := labels[len(ins)]; ok {
+		fmt.Fprintf(&out, "%s:\n", label)
+	}
+
+	return out.String()
+}
+
+// region 帮助函数
+
+// 反汇编位置i上的一条指令 返回指令文本、注释和指令长度
+// 无法解码时文本为ERROR和错误信息
+func instruction(ins code.Instructions, i int, constants []object.Object,
+	labels map[int]string, boundaries map[int]bool) (text, comment string, width int) {
+	def, operands, width, err := code.ReadInstruction(ins, i)
+	if err != nil {
+		return fmt.Sprintf("ERROR: %s", err), "", width
+	}
+
+	op, wide := code.OpcodeAt(ins, i)
+	text = code.FormatInstruction(def, operands)
+	if wide {
+		text = "OpWide " + text
+	}
+	return text, annotate(op, operands, constants, labels, boundaries), width
+}
+
+// 判断操作码是否是跳转指令
+func isJump(op code.Opcode) bool {
+	return op == code.OpJump || op == code.OpJumpNotTruthy
+}
+
+// 所有指令的起始位置 (包含指令末尾)
+func instructionBoundaries(ins code.Instructions) map[int]bool {
+	boundaries := map[int]bool{len(ins): true}
+
+	i := 0
+	for i < len(ins) {
+		boundaries[i] = true
+		_, _, width, _ := code.ReadInstruction(ins, i)
+		i += width
+	}
+	return boundaries
+}
+
+// 找出所有跳转目标 按位置顺序编号为L0 L1 ...
+func jumpLabels(ins code.Instructions) map[int]string {
+	var targets []int
+	seen := map[int]bool{}
+
+	i := 0
+	for i < len(ins) {
+		_, operands, width, err := code.ReadInstruction(ins, i)
+		op, _ := code.OpcodeAt(ins, i)
+		if err == nil && isJump(op) && !seen[operands[0]] {
+			seen[operands[0]] = true
+			targets = append(targets, operands[0])
+		}
+		i += width
+	}
+
+	sort.Ints(targets)
+	labels := make(map[int]string, len(targets))
+	for n, t := range targets {
+		labels[t] = fmt.Sprintf("L%d", n)
+	}
+	return labels
+}
+
+// 为指令生成注释: 常量的值或跳转目标
+func annotate(op code.Opcode, operands []int, constants []object.Object,
+	labels map[int]string, boundaries map[int]bool) string {
+	switch {
+	case op == code.OpConstant:
+		index := operands[0]
+		if index >= len(constants) {
+			return fmt.Sprintf("invalid constant index %d", index)
+		}
+		return formatConstant(constants[index])
+	case op == code.OpClosure:
+		index := operands[0]
+		if index >= len(constants) {
+			return fmt.Sprintf("invalid constant index %d", index)
+		}
+		return fmt.Sprintf("fn#%d", index)
+	case isJump(op):
+		target := operands[0]
+		if !boundaries[target] {
+			return fmt.Sprintf("-> %04d (not an instruction boundary)", target)
+		}
+		return "-> " + labels[target]
+	}
+	return ""
+}
+
+// 格式化常量 函数只显示编号，指令在单独的段中列出
+func formatConstant(obj object.Object) string {
+	switch obj := obj.(type) {
+	case *object.String:
+		return fmt.Sprintf("%q", obj.Value)
+	case *object.CompiledFunction:
+		return fmt.Sprintf("<function params=%d locals=%d>", obj.NumParameters, obj.NumLocals)
+	default:
+		return obj.Inspect()
+	}
+}
+
+// endregion
@@ -0,0 +1,102 @@
+package disasm
+
+import (
+	"MyCompiler/src/cfg"
+	"MyCompiler/src/code"
+	"MyCompiler/src/compiler"
+	"MyCompiler/src/dot"
+	"MyCompiler/src/object"
+	"fmt"
+	"io"
+)
+
+// Graph 把字节码的控制流图画成Graphviz图 写到out
+// 顶层指令和常量池中的每个函数各是一个子图，每个基本块是一个节点，指令的写法与Disassemble相同
+// 条件跳转的两条边标为 true/false，返回指向子图的exit节点
+func Graph(out io.Writer, bc *compiler.ByteCode) error {
+	g := dot.NewGraph("bytecode")
+	g.Defaults("node", dot.A("shape", "box"), dot.A("fontname", "monospace"))
+	g.Defaults("edge", dot.A("fontname", "monospace"), dot.A("fontsize", "10"))
+
+	err := graphFunction(g, "main", "main", bc.Instructions, bc.Constants)
+	if err != nil {
+		return err
+	}
+	for i, c := range bc.Constants {
+		fn, ok := c.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		title := fmt.Sprintf("fn#%d (params=%d, locals=%d)", i, fn.NumParameters, fn.NumLocals)
+		err := graphFunction(g, fmt.Sprintf("fn%d", i), title, fn.Instructions, bc.Constants)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = g.WriteTo(out)
+	return err
+}
+
+// 把一段指令画成一个子图 节点的id都以id为前缀
+func graphFunction(g *dot.Graph, id, title string, ins code.Instructions, constants []object.Object) error {
+	graph, err := cfg.Build(ins)
+	if err != nil {
+		return fmt.Errorf("%s: %s", title, err)
+	}
+
+	labels := jumpLabels(ins)
+	boundaries := instructionBoundaries(ins)
+	blockID := func(index int) string {
+		if index == cfg.Exit {
+			return id + "_exit"
+		}
+		return fmt.Sprintf("%s_b%d", id, index)
+	}
+
+	g.Subgraph("cluster_"+id, dot.A("label", title))
+	g.Node(id+"_entry", dot.Label("entry"), dot.A("shape", "oval"))
+	g.Node(id+"_exit", dot.Label("exit"), dot.A("shape", "oval"))
+
+	for _, b := range graph.Blocks {
+		name := fmt.Sprintf("B%d", b.Index)
+		if label, ok := labels[b.Start]; ok {
+			name += " (" + label + ")"
+		}
+		lines := []string{name}
+		for _, pos := range b.Instructions(ins) {
+			text, comment, _ := instruction(ins, pos, constants, labels, boundaries)
+			if comment != "" {
+				lines = append(lines, fmt.Sprintf("%04d %-24s ; %s", pos, text, comment))
+			} else {
+				lines = append(lines, fmt.Sprintf("%04d %s", pos, text))
+			}
+		}
+		g.Node(blockID(b.Index), dot.LeftLabel(lines...))
+	}
+
+	if len(graph.Blocks) == 0 {
+		g.Edge(id+"_entry", id+"_exit")
+	} else {
+		g.Edge(id+"_entry", blockID(0))
+	}
+	for _, b := range graph.Blocks {
+		for _, e := range b.Succs {
+			var attrs []dot.Attr
+			switch {
+			case e.Kind == cfg.Branch:
+				attrs = append(attrs, dot.Label("false"))
+			case e.Kind == cfg.Fallthrough && len(b.Succs) == 2:
+				attrs = append(attrs, dot.Label("true"))
+			case e.Kind == cfg.Jump:
+				attrs = append(attrs, dot.Label("jump"))
+			case e.Kind == cfg.Return:
+				attrs = append(attrs, dot.A("style", "dashed"))
+			}
+			g.Edge(blockID(b.Index), blockID(e.To), attrs...)
+		}
+	}
+
+	g.End()
+	return nil
+}
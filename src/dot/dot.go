@@ -0,0 +1,138 @@
+package dot
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// Attr 节点、边或图的属性 Value是已经转义过的DOT字符串内容
+type Attr struct {
+	Key   string
+	Value string
+}
+
+// A 创建一个属性 value按普通文本转义
+func A(key, value string) Attr {
+	return Attr{key, escape(value)}
+}
+
+// Label 多行居中的标签
+func Label(lines ...string) Attr {
+	escaped := make([]string, len(lines))
+	for i, line := range lines {
+		escaped[i] = escape(line)
+	}
+	return Attr{"label", strings.Join(escaped, `\n`)}
+}
+
+// LeftLabel 多行左对齐的标签 用于代码
+func LeftLabel(lines ...string) Attr {
+	var out strings.Builder
+	for _, line := range lines {
+		out.WriteString(escape(line))
+		out.WriteString(`\l`)
+	}
+	return Attr{"label", out.String()}
+}
+
+// Graph 按顺序写出一个Graphviz有向图
+type Graph struct {
+	out    bytes.Buffer
+	indent int
+}
+
+// NewGraph 开始一个名为name的有向图
+func NewGraph(name string, attrs ...Attr) *Graph {
+	g := &Graph{}
+	g.line("digraph " + quote(name) + " {")
+	g.indent++
+	g.graphAttrs(attrs)
+	return g
+}
+
+// Defaults 为之后的节点或边设置默认属性 kind为 "node" "edge" 或 "graph"
+func (g *Graph) Defaults(kind string, attrs ...Attr) {
+	g.line(kind + attrList(attrs) + ";")
+}
+
+// Node 添加一个节点
+func (g *Graph) Node(id string, attrs ...Attr) {
+	g.line(quote(id) + attrList(attrs) + ";")
+}
+
+// Edge 添加一条从from到to的边
+func (g *Graph) Edge(from, to string, attrs ...Attr) {
+	g.line(quote(from) + " -> " + quote(to) + attrList(attrs) + ";")
+}
+
+// Subgraph 开始一个子图 以cluster开头的id会被画成带边框的分组；用End结束
+func (g *Graph) Subgraph(id string, attrs ...Attr) {
+	g.line("subgraph " + quote(id) + " {")
+	g.indent++
+	g.graphAttrs(attrs)
+}
+
+// End 结束当前的子图
+func (g *Graph) End() {
+	g.indent--
+	g.line("}")
+}
+
+// WriteTo 结束整个图并写到w
+func (g *Graph) WriteTo(w io.Writer) (int64, error) {
+	for g.indent > 0 {
+		g.End()
+	}
+	return g.out.WriteTo(w)
+}
+
+// region 帮助函数
+
+func (g *Graph) line(s string) {
+	g.out.WriteString(strings.Repeat("  ", g.indent))
+	g.out.WriteString(s)
+	g.out.WriteByte('\n')
+}
+
+func (g *Graph) graphAttrs(attrs []Attr) {
+	for _, a := range attrs {
+		g.line(a.Key + "=\"" + a.Value + "\";")
+	}
+}
+
+func attrList(attrs []Attr) string {
+	if len(attrs) == 0 {
+		return ""
+	}
+	parts := make([]string, len(attrs))
+	for i, a := range attrs {
+		parts[i] = a.Key + "=\"" + a.Value + "\""
+	}
+	return " [" + strings.Join(parts, ", ") + "]"
+}
+
+func quote(id string) string {
+	return `"` + escape(id) + `"`
+}
+
+// DOT字符串中只需要转义引号和反斜杠 换行写成 \n
+func escape(s string) string {
+	var out strings.Builder
+	for _, r := range s {
+		switch r {
+		case '"':
+			out.WriteString(`\"`)
+		case '\\':
+			out.WriteString(`\\`)
+		case '\n':
+			out.WriteString(`\n`)
+		case '\r':
+		default:
+			out.WriteRune(r)
+		}
+	}
+	return out.String()
+}
+
+// endregion
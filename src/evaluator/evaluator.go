@@ -4,12 +4,16 @@ import (
 	"MyCompiler/src/ast"
 	"MyCompiler/src/object"
 	"fmt"
+	"strings"
 )
 
 var (
 	TRUE  = &object.Boolean{Value: true}
 	FALSE = &object.Boolean{Value: false}
 	NULL  = &object.Null{}
+
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
 func Eval(node ast.Node, env *object.Environment) object.Object {
@@ -17,9 +21,15 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.Program:
 		return evalProgram(node, env)
 	case *ast.ExpressionStatement:
+		if ifExpression, ok := node.Expression.(*ast.IfExpression); ok {
+			// 语句位置的if 分支中的break和continue交给外层的循环
+			return evalIfExpression(ifExpression, env)
+		}
 		return Eval(node.Expression, env)
 	case *ast.BlockStatement:
 		return evalBlockStatements(node.Statements, env)
+	case *ast.BadStatement:
+		return newError("cannot evaluate statement with syntax errors at %s", node.Pos())
 	case *ast.ReturnStatement:
 		val := Eval(node.ReturnValue, env)
 		if isError(val) {
@@ -33,8 +43,20 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		// 将let语句声明的变量放入变量表
 		return env.Set(node.Name.Value, val)
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
 	case *ast.IfExpression:
-		return evalIfExpression(node, env)
+		result := evalIfExpression(node, env)
+		if err := loopControlInExpression(result); err != nil {
+			return err
+		}
+		return result
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
 		if isError(right) {
@@ -46,6 +68,9 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(left) {
 			return left
 		}
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node.Operator, left, node.Right, env)
+		}
 		right := Eval(node.Right, env)
 		if isError(right) {
 			return right
@@ -53,6 +78,8 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return evalInfixExpression(node.Operator, left, right)
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.BooleanLiteral:
 		// return &object.Boolean{Value: node.Value} // 这种方法每次需要创建新对象，浪费资源，应当使用单例
 		if node.Value {
@@ -62,6 +89,8 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
+	case *ast.TemplateLiteral:
+		return evalTemplateLiteral(node, env)
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
 		if len(elements) == 1 && isError(elements[0]) {
@@ -111,7 +140,9 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
 	pairs := make(map[object.HashKey]object.HashPair)
-	for keyNode, valueNode := range node.Pairs {
+	// 按键在源码中的顺序求值 重复的键由后面的覆盖前面的
+	for _, keyNode := range node.Keys() {
+		valueNode := node.Pairs[keyNode]
 		key := Eval(keyNode, env)
 		if isError(key) {
 			return key
@@ -137,6 +168,8 @@ func evalIndexExpression(left object.Object, index object.Object) object.Object
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalStringIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
 	default:
@@ -171,15 +204,50 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 	return arrayObject.Elements[idx]
 }
 
+// 字符串按码点索引 结果是只含一个字符的字符串
+func evalStringIndexExpression(str, index object.Object) object.Object {
+	ch, ok := str.(*object.String).CharAt(index.(*object.Integer).Value)
+	if !ok {
+		return NULL
+	}
+	return ch
+}
+
+// 模板字符串 每个插值的值按Inspect转换成字符串
+func evalTemplateLiteral(node *ast.TemplateLiteral, env *object.Environment) object.Object {
+	var out strings.Builder
+	for i, s := range node.Strings {
+		out.WriteString(s)
+		if i < len(node.Values) {
+			value := Eval(node.Values[i], env)
+			if isError(value) {
+				return value
+			}
+			out.WriteString(value.Inspect())
+		}
+	}
+	return &object.String{Value: out.String()}
+}
+
 func applyFunction(fn object.Object, args []object.Object) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
 		// 用外部环境的env包裹args
 		extendedEnv := extendFunctionEnv(fn, args)
 		evaluated := Eval(fn.Body, extendedEnv)
+		if err := loopControlError(evaluated); err != nil {
+			return err
+		}
 		return unwrapReturnValue(evaluated)
 	case *object.Builtin:
-		return fn.Fn(args...)
+		result, err := fn.Call(args...)
+		if err != nil {
+			return newError("%s", err)
+		}
+		if result == nil {
+			return NULL
+		}
+		return result
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
@@ -221,7 +289,7 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 	}
 
 	// 查找内置函数
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin := object.GetBuiltinByName(node.Value); builtin != nil {
 		return builtin
 	}
 	return newError("identifier not found: %s", node.Value)
@@ -240,11 +308,93 @@ func evalProgram(node *ast.Program, env *object.Environment) object.Object {
 			return result.Value
 		case *object.Error:
 			return result
+		case *object.Break, *object.Continue:
+			return loopControlError(result)
 		}
 	}
 	return result
 }
 
+// 执行while循环 循环语句的值为null
+func evalWhileStatement(node *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		condition := Eval(node.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return NULL
+		}
+
+		result := Eval(node.Body, env)
+		if result == BREAK {
+			return NULL
+		}
+		if isReturnOrError(result) {
+			return result
+		}
+	}
+}
+
+// 执行for循环 Init和Update中的let与循环外的let一样，定义在当前环境中
+func evalForStatement(node *ast.ForStatement, env *object.Environment) object.Object {
+	if node.Init != nil {
+		init := Eval(node.Init, env)
+		if isError(init) {
+			return init
+		}
+	}
+
+	for {
+		if node.Condition != nil {
+			condition := Eval(node.Condition, env)
+			if isError(condition) {
+				return condition
+			}
+			if !isTruthy(condition) {
+				return NULL
+			}
+		}
+
+		result := Eval(node.Body, env)
+		if result == BREAK {
+			return NULL
+		}
+		if isReturnOrError(result) {
+			return result
+		}
+
+		if node.Update != nil {
+			update := Eval(node.Update, env)
+			if isError(update) {
+				return update
+			}
+		}
+	}
+}
+
+// break或continue没有被循环接住 (在循环之外或者在循环中定义的函数里) 时转换成错误
+func loopControlError(obj object.Object) object.Object {
+	switch obj.(type) {
+	case *object.Break:
+		return newError("break outside loop")
+	case *object.Continue:
+		return newError("continue outside loop")
+	}
+	return nil
+}
+
+// 值要被使用的if表达式中不能break或continue 与编译器的检查一致
+func loopControlInExpression(obj object.Object) object.Object {
+	switch obj.(type) {
+	case *object.Break:
+		return newError("break inside expression")
+	case *object.Continue:
+		return newError("continue inside expression")
+	}
+	return nil
+}
+
 func evalIfExpression(node *ast.IfExpression, env *object.Environment) object.Object {
 	condition := Eval(node.Condition, env)
 	if isError(condition) {
@@ -273,11 +423,30 @@ func isTruthy(obj object.Object) bool {
 
 }
 
+// 短路求值 左边已经能决定结果时不再对右边求值 结果总是布尔值
+func evalLogicalExpression(operator string, left object.Object, right ast.Expression, env *object.Environment) object.Object {
+	if operator == "&&" && !isTruthy(left) {
+		return FALSE
+	}
+	if operator == "||" && isTruthy(left) {
+		return TRUE
+	}
+
+	value := Eval(right, env)
+	if isError(value) {
+		return value
+	}
+	return nativeBoolToBooleanObject(isTruthy(value))
+}
+
 func evalInfixExpression(operator string, left object.Object, right object.Object) object.Object {
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		// 左右表达式都是整数的情况
 		return evalIntegerInfixExpression(operator, left, right)
+	case isNumber(left) && isNumber(right):
+		// 至少有一边是浮点数 整数先转成浮点数
+		return evalFloatInfixExpression(operator, left, right)
 	case left.Type() == object.BOOLEAN_OBJ && right.Type() == object.BOOLEAN_OBJ:
 		// 左右表达式的值都是布尔值
 		return evalBooleanInfixExpression(operator, left, right)
@@ -335,6 +504,42 @@ func evalIntegerInfixExpression(operator string, left object.Object, right objec
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func evalFloatInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+	leftVal, _ := object.ToFloat(left)
+	rightVal, _ := object.ToFloat(right)
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return &object.Float{Value: leftVal / rightVal}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
@@ -344,6 +549,12 @@ func evalIntegerInfixExpression(operator string, left object.Object, right objec
 	}
 }
 
+// 是否是整数或浮点数
+func isNumber(obj object.Object) bool {
+	_, ok := object.ToFloat(obj)
+	return ok
+}
+
 func nativeBoolToBooleanObject(val bool) object.Object {
 	if val {
 		return TRUE
@@ -365,6 +576,9 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
+	if f, ok := right.(*object.Float); ok {
+		return &object.Float{Value: -f.Value}
+	}
 	if right.Type() != object.INTEGER_OBJ {
 		return newError("unknown operator: -%s", right.Type())
 	}
@@ -395,7 +609,8 @@ func evalBlockStatements(stmts []ast.Statement, env *object.Environment) object.
 
 		if result != nil {
 			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ ||
+				rt == object.BREAK_OBJ || rt == object.CONTINUE_OBJ {
 				return result
 			}
 		}
@@ -408,6 +623,14 @@ func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
+func isReturnOrError(obj object.Object) bool {
+	if obj != nil {
+		rt := obj.Type()
+		return rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ
+	}
+	return false
+}
+
 func isError(obj object.Object) bool {
 	if obj != nil {
 		return obj.Type() == object.ERROR_OBJ
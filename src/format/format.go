@@ -0,0 +1,91 @@
+package format
+
+import (
+	"MyCompiler/src/ast"
+	"MyCompiler/src/lexer"
+	"MyCompiler/src/parser"
+	"MyCompiler/src/token"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// 格式化的规则
+//
+//   - 每条语句一行，缩进4个空格；let和return语句以分号结尾
+//   - 表达式语句以分号结尾，块语句的最后一条表达式语句(块的值)和if表达式后面不加
+//   - 运算符两边各一个空格，只在优先级需要时加括号
+//   - 调用、数组和哈希字面量超过行宽时每项一行；最后一个参数是函数时只拆开函数体
+//   - 只有一条语句、在源码中写在一行内并且放得下的块语句保持在一行
+//   - 保留注释和语句之间的空行(多个空行合并成一个)
+//   - 字符串字面量保持源码中的写法
+
+// Source 格式化一段源码 源码有语法错误时返回错误，错误信息每行一条
+func Source(filename string, src []byte) ([]byte, error) {
+	program, err := parse(filename, src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := newPrinter(src, collectComments(filename, src))
+	p.program(program)
+	if p.err != nil {
+		return nil, p.err
+	}
+	result := p.bytes()
+
+	// 格式化之后重新分析一次 确认程序没有改变
+	formatted, err := parse(filename, result)
+	if err != nil || formatted.String() != program.String() {
+		return nil, fmt.Errorf("%s: formatting changed the program", token.Position{Filename: filename})
+	}
+	return result, nil
+}
+
+// Node 把语法树节点格式化后写到w 节点可以是程序、语句或表达式
+// 没有源码，所以不会输出注释，字符串字面量按转义之后的值重新写出
+func Node(w io.Writer, node ast.Node) error {
+	p := newPrinter(nil, nil)
+	switch n := node.(type) {
+	case *ast.Program:
+		p.program(n)
+	case *ast.BlockStatement:
+		p.block(n, p.oneLine(n))
+	case ast.Statement:
+		p.statement(n, true)
+	case ast.Expression:
+		p.expr(n)
+	default:
+		return fmt.Errorf("format: unexpected node type %T", node)
+	}
+	if p.err != nil {
+		return p.err
+	}
+	_, err := w.Write(p.bytes())
+	return err
+}
+
+func parse(filename string, src []byte) (*ast.Program, error) {
+	p := parser.New(lexer.NewWithFilename(filename, string(src)))
+	program := p.ParseProgram()
+	if len(p.Error()) != 0 {
+		return nil, errors.New(strings.Join(p.Error(), "\n"))
+	}
+	return program, nil
+}
+
+// 语法分析器不保留注释 单独做一次词法分析把所有注释按顺序收集起来
+func collectComments(filename string, src []byte) []token.Comment {
+	l := lexer.NewWithFilename(filename, string(src))
+	l.SetMode(lexer.KeepComments)
+
+	var comments []token.Comment
+	for {
+		tok := l.NextToken()
+		comments = append(comments, tok.Leading...)
+		if tok.Type == token.EOF {
+			return comments
+		}
+	}
+}
@@ -0,0 +1,760 @@
+package format
+
+import (
+	"MyCompiler/src/ast"
+	"MyCompiler/src/parser"
+	"MyCompiler/src/token"
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	lineWidth   = 80 // 行宽 超过时拆开列表
+	indentWidth = 4  // 每层缩进的空格数
+)
+
+// 运算符的优先级 与语法分析器一致
+var infixPrecedences = map[string]int{
+	"||": parser.LOGICAL_OR,
+	"&&": parser.LOGICAL_AND,
+	"==": parser.EQUALS,
+	"!=": parser.EQUALS,
+	"<":  parser.LESSGREATER,
+	">":  parser.LESSGREATER,
+	"<=": parser.LESSGREATER,
+	">=": parser.LESSGREATER,
+	"+":  parser.SUM,
+	"-":  parser.SUM,
+	"*":  parser.PRODUCT,
+	"/":  parser.PRODUCT,
+}
+
+const (
+	prefixPrecedence  = parser.PREFIX
+	postfixPrecedence = parser.INDEX     // 调用和索引
+	atomPrecedence    = parser.INDEX + 1 // 字面量 标识符 if fn 等不会被拆开的表达式
+)
+
+type printer struct {
+	src      []byte          // 源码 没有源码时为nil
+	comments []token.Comment // 还没有输出的注释 按位置排序
+	width    int             // 行宽 0表示不限制
+
+	out       bytes.Buffer
+	indent    int  // 缩进层级
+	extra     int  // 注释强制换行之后的续行缩进
+	column    int  // 当前行已经输出的字符数
+	lineStart bool // 下一次输出前先写缩进
+	lastLine  int  // 最后输出的内容在源码中的行号 用来判断注释是否在同一行
+	err       error
+}
+
+func newPrinter(src []byte, comments []token.Comment) *printer {
+	return &printer{src: src, comments: comments, width: lineWidth, lineStart: true}
+}
+
+// 格式化的结果 以一个换行结尾
+func (p *printer) bytes() []byte {
+	out := bytes.TrimRight(p.out.Bytes(), " \n")
+	if len(out) == 0 {
+		return nil
+	}
+	return append(out, '\n')
+}
+
+// region 输出
+
+func (p *printer) write(s string) {
+	if s == "" {
+		return
+	}
+	if p.lineStart {
+		n := (p.indent + p.extra) * indentWidth
+		p.out.WriteString(strings.Repeat(" ", n))
+		p.column = n
+		p.lineStart = false
+	}
+	p.out.WriteString(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		p.column = utf8.RuneCountInString(s[i+1:])
+	} else {
+		p.column += utf8.RuneCountInString(s)
+	}
+}
+
+// 输出一个词法单元 先输出它之前的注释
+func (p *printer) token(tok token.Token, text string) {
+	p.flush(tok.Pos)
+	p.write(text)
+	if tok.End.IsValid() {
+		p.lastLine = tok.End.Line
+	}
+}
+
+// 换行 去掉行尾的空格
+func (p *printer) newline() {
+	p.out.Truncate(len(bytes.TrimRight(p.out.Bytes(), " ")))
+	p.out.WriteByte('\n')
+	p.column = 0
+	p.lineStart = true
+}
+
+// 插入一个空行 在开头、左括号之后或者已经有空行时不插入
+func (p *printer) blankLine() {
+	if !p.lineStart {
+		p.newline()
+	}
+	out := bytes.TrimRight(p.out.Bytes(), " ")
+	if len(out) == 0 || bytes.HasSuffix(out, []byte("\n\n")) {
+		return
+	}
+	content := bytes.TrimRight(out, "\n")
+	if len(content) == 0 || strings.ContainsRune("{([", rune(content[len(content)-1])) {
+		return
+	}
+	p.newline()
+}
+
+// 列表项和语句之间的换行 先输出和上一个词法单元在同一行、位于next之前的注释
+func (p *printer) linebreak(next token.Position) {
+	for len(p.comments) > 0 {
+		c := p.comments[0]
+		if c.Pos.Line != p.lastLine || !before(c, next) {
+			break
+		}
+		p.comments = p.comments[1:]
+		p.space(" ")
+		p.write(c.Text)
+		p.lastLine = c.End.Line
+	}
+	p.newline()
+	p.extra = 0
+}
+
+// 输出pos之前的所有注释
+func (p *printer) flush(pos token.Position) {
+	for len(p.comments) > 0 && before(p.comments[0], pos) {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		next := pos
+		if len(p.comments) > 0 && before(p.comments[0], pos) {
+			next = p.comments[0].Pos
+		}
+		p.comment(c, next)
+	}
+}
+
+// 输出一条注释 next是注释之后的内容在源码中的位置
+func (p *printer) comment(c token.Comment, next token.Position) {
+	ownLine := c.Pos.Line > p.lastLine
+	if ownLine && !p.lineStart {
+		// 源码中注释独占一行 表达式的剩余部分作为续行
+		p.newline()
+		p.extra = 1
+	}
+	midLine := !p.lineStart
+	if p.lineStart {
+		if p.lastLine > 0 && c.Pos.Line-p.lastLine > 1 {
+			p.blankLine()
+		}
+	} else {
+		p.space("([{ ")
+	}
+	p.write(c.Text)
+	p.lastLine = c.End.Line
+
+	// 行注释之后，或者源码中注释之后的内容在下一行时换行
+	if strings.HasPrefix(c.Text, "//") || next.Line > c.End.Line {
+		if midLine {
+			p.extra = 1
+		}
+		p.newline()
+	} else {
+		p.write(" ")
+	}
+}
+
+// 在注释之前留一个空格 上一个字符在after中时不用
+func (p *printer) space(after string) {
+	out := p.out.Bytes()
+	if len(out) > 0 && !strings.ContainsRune(after, rune(out[len(out)-1])) {
+		p.write(" ")
+	}
+}
+
+// 注释是否在pos之前 没有位置时不输出注释
+func before(c token.Comment, pos token.Position) bool {
+	return pos.IsValid() && c.Pos.Offset < pos.Offset
+}
+
+// from和to之间是否有注释
+func (p *printer) hasComments(from, to token.Position) bool {
+	if !from.IsValid() || !to.IsValid() {
+		return false
+	}
+	i := sort.Search(len(p.comments), func(i int) bool { return p.comments[i].Pos.Offset >= from.Offset })
+	return i < len(p.comments) && p.comments[i].Pos.Offset < to.Offset
+}
+
+// 进入新的嵌套层级 续行缩进并入缩进层级
+type level struct {
+	indent, extra int
+}
+
+func (p *printer) push() level {
+	l := level{p.indent, p.extra}
+	p.indent += p.extra
+	p.extra = 0
+	return l
+}
+
+func (p *printer) pop(l level) {
+	p.indent, p.extra = l.indent, l.extra
+}
+
+// 在不限制行宽、没有注释的情况下输出 用来判断能否放在一行
+func (p *printer) measure(f func(q *printer)) string {
+	q := newPrinter(p.src, nil)
+	q.width = 0
+	f(q)
+	return q.out.String()
+}
+
+// 从当前列开始输出s之后不超过行宽
+func (p *printer) fits(s string) bool {
+	return p.width <= 0 || p.column+utf8.RuneCountInString(s) <= p.width
+}
+
+// endregion
+
+// region 语句
+
+func (p *printer) program(program *ast.Program) {
+	p.statements(program.Statement, false)
+	end := token.Position{Line: math.MaxInt32, Offset: math.MaxInt32}
+	if p.out.Len() > 0 {
+		p.linebreak(end)
+	}
+	p.flush(end)
+}
+
+// 输出语句列表 inBlock时最后一条表达式语句是块的值，不加分号
+func (p *printer) statements(list []ast.Statement, inBlock bool) {
+	for i, s := range list {
+		if p.out.Len() > 0 {
+			p.linebreak(s.Pos())
+		}
+		p.flush(s.Pos())
+		if p.lastLine > 0 && s.Pos().Line-p.lastLine > 1 {
+			p.blankLine()
+		}
+
+		semicolon := true
+		if stmt, ok := s.(*ast.ExpressionStatement); ok {
+			last := i == len(list)-1
+			switch {
+			case last && inBlock:
+				semicolon = false
+			case isIf(stmt.Expression):
+				// if后面没有分号时，下一条以 ( [ - 开头的语句会被当成调用、索引或减法
+				semicolon = !last && p.continues(list[i+1])
+			}
+		}
+		p.statement(s, semicolon)
+	}
+}
+
+// 语句s能否接在前一个表达式后面成为它的一部分
+func (p *printer) continues(s ast.Statement) bool {
+	stmt, ok := s.(*ast.ExpressionStatement)
+	if !ok {
+		return false
+	}
+	text := p.measure(func(q *printer) { q.expr(stmt.Expression) })
+	return strings.HasPrefix(text, "(") || strings.HasPrefix(text, "[") || strings.HasPrefix(text, "-")
+}
+
+func isIf(e ast.Expression) bool {
+	_, ok := e.(*ast.IfExpression)
+	return ok
+}
+
+func (p *printer) statement(s ast.Statement, semicolon bool) {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		p.clause(s)
+		p.write(";")
+	case *ast.ReturnStatement:
+		p.token(s.Token, "return")
+		if s.ReturnValue != nil {
+			p.write(" ")
+			p.expr(s.ReturnValue)
+		}
+		p.write(";")
+	case *ast.ExpressionStatement:
+		p.expr(s.Expression)
+		if semicolon {
+			p.write(";")
+		}
+	case *ast.BlockStatement:
+		p.block(s, p.oneLine(s))
+	case *ast.WhileStatement:
+		p.whileStatement(s)
+	case *ast.ForStatement:
+		p.forStatement(s)
+	case *ast.BreakStatement:
+		p.token(s.Token, "break")
+		p.write(";")
+	case *ast.ContinueStatement:
+		p.token(s.Token, "continue")
+		p.write(";")
+	case *ast.BadStatement:
+		p.fail("cannot format statement with syntax errors at %s", s.Pos())
+	default:
+		p.fail("format: unexpected statement type %T", s)
+	}
+}
+
+// 不带分号的let语句或表达式语句 for语句的头部也用它输出
+func (p *printer) clause(s ast.Statement) {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		p.token(s.Token, "let")
+		p.write(" ")
+		p.expr(s.Name)
+		p.write(" = ")
+		p.expr(s.Value)
+	case *ast.ExpressionStatement:
+		p.expr(s.Expression)
+	default:
+		p.fail("format: unexpected for clause type %T", s)
+	}
+}
+
+func (p *printer) whileStatement(s *ast.WhileStatement) {
+	oneLine := p.oneLine(s.Body)
+	if oneLine && p.width > 0 {
+		oneLine = p.fits(p.measure(func(q *printer) { q.whileStatement(s) }))
+	}
+
+	p.token(s.Token, "while")
+	p.write(" (")
+	p.expr(s.Condition)
+	p.write(") ")
+	p.block(s.Body, oneLine)
+}
+
+// for (init; cond; update) 省略的部分不留空格，如 for (;;)
+func (p *printer) forStatement(s *ast.ForStatement) {
+	oneLine := p.oneLine(s.Body)
+	if oneLine && p.width > 0 {
+		oneLine = p.fits(p.measure(func(q *printer) { q.forStatement(s) }))
+	}
+
+	p.token(s.Token, "for")
+	p.write(" (")
+	if s.Init != nil {
+		p.clause(s.Init)
+	}
+	p.write(";")
+	if s.Condition != nil {
+		p.write(" ")
+		p.expr(s.Condition)
+	}
+	p.write(";")
+	if s.Update != nil {
+		p.write(" ")
+		p.clause(s.Update)
+	}
+	p.write(") ")
+	p.block(s.Body, oneLine)
+}
+
+// 输出块语句 oneLine时写成 { x } 的形式
+func (p *printer) block(b *ast.BlockStatement, oneLine bool) {
+	if b == nil {
+		p.fail("format: missing block statement")
+		return
+	}
+	p.token(b.Token, "{")
+	if oneLine {
+		if len(b.Statements) == 1 {
+			p.write(" ")
+			p.statement(b.Statements[0], false)
+			p.write(" ")
+		}
+		p.token(b.RBrace, "}")
+		return
+	}
+
+	l := p.push()
+	p.indent++
+	p.statements(b.Statements, true)
+	p.linebreak(b.RBrace.Pos)
+	p.flush(b.RBrace.Pos)
+	p.indent--
+	p.token(b.RBrace, "}")
+	p.pop(l)
+}
+
+// 这些块语句能否都写在一行
+// 空块总是写成 {}；只有一条语句时源码中也要写在一行，并且块中没有注释
+func (p *printer) oneLine(blocks ...*ast.BlockStatement) bool {
+	for _, b := range blocks {
+		if b == nil {
+			continue
+		}
+		if p.hasComments(b.Token.Pos, b.RBrace.End) {
+			return false
+		}
+		switch len(b.Statements) {
+		case 0:
+			continue
+		case 1:
+		default:
+			return false
+		}
+		if b.Token.Pos.IsValid() && b.RBrace.Pos.IsValid() && b.Token.Pos.Line != b.RBrace.Pos.Line {
+			return false
+		}
+		s := b.Statements[0]
+		if strings.Contains(p.measure(func(q *printer) { q.statement(s, false) }), "\n") {
+			return false
+		}
+	}
+	return true
+}
+
+// endregion
+
+// region 表达式
+
+func precedence(e ast.Expression) int {
+	switch e := e.(type) {
+	case *ast.InfixExpression:
+		if prec, ok := infixPrecedences[e.Operator]; ok {
+			return prec
+		}
+		return parser.LOWEST
+	case *ast.PrefixExpression:
+		return prefixPrecedence
+	case *ast.CallExpression, *ast.IndexExpression:
+		return postfixPrecedence
+	}
+	return atomPrecedence
+}
+
+// 输出子表达式 优先级低于min时加括号
+func (p *printer) operand(e ast.Expression, min int) {
+	if precedence(e) < min {
+		p.write("(")
+		p.expr(e)
+		p.write(")")
+		return
+	}
+	p.expr(e)
+}
+
+func (p *printer) expr(e ast.Expression) {
+	switch e := e.(type) {
+	case *ast.Identifier:
+		if e == nil {
+			p.fail("format: missing identifier")
+			return
+		}
+		p.token(e.Token, e.Value)
+	case *ast.IntegerLiteral:
+		text := e.Token.Literal
+		if text == "" {
+			text = strconv.FormatInt(e.Value, 10)
+		}
+		p.token(e.Token, text)
+	case *ast.FloatLiteral:
+		text := e.Token.Literal
+		if text == "" {
+			text = formatFloat(e.Value)
+		}
+		p.token(e.Token, text)
+	case *ast.BooleanLiteral:
+		p.token(e.Token, strconv.FormatBool(e.Value))
+	case *ast.StringLiteral:
+		p.token(e.Token, p.stringLiteral(e))
+	case *ast.TemplateLiteral:
+		p.templateLiteral(e)
+	case *ast.ArrayLiteral:
+		p.list(e.Token, "[", expressionItems(e.Elements), e.RBracket, "]", false)
+	case *ast.HashLiteral:
+		var items []item
+		for _, key := range e.Keys() {
+			items = append(items, item{key: key, value: e.Pairs[key]})
+		}
+		p.list(e.Token, "{", items, e.RBrace, "}", false)
+	case *ast.IndexExpression:
+		p.operand(e.Left, postfixPrecedence)
+		p.token(e.Token, "[")
+		p.expr(e.Index)
+		p.token(e.RBracket, "]")
+	case *ast.CallExpression:
+		p.operand(e.Function, postfixPrecedence)
+		p.list(e.Token, "(", expressionItems(e.Arguments), e.RParen, ")", true)
+	case *ast.PrefixExpression:
+		p.token(e.Token, e.Operator)
+		if e.Operator == "-" && p.startsWithMinus(e.Right) {
+			// 避免写成 --x
+			p.write(" ")
+		}
+		p.operand(e.Right, prefixPrecedence)
+	case *ast.InfixExpression:
+		prec := precedence(e)
+		p.operand(e.Left, prec)
+		p.write(" ")
+		p.token(e.Token, e.Operator)
+		p.write(" ")
+		// 运算符都是左结合的 右边优先级相同时也要加括号
+		p.operand(e.Right, prec+1)
+	case *ast.IfExpression:
+		p.ifExpression(e)
+	case *ast.FnExpression:
+		p.fnExpression(e)
+	case nil:
+		p.fail("format: missing expression")
+	default:
+		p.fail("format: unexpected expression type %T", e)
+	}
+}
+
+func (p *printer) startsWithMinus(e ast.Expression) bool {
+	switch e := e.(type) {
+	case *ast.PrefixExpression:
+		return e.Operator == "-"
+	case *ast.IntegerLiteral:
+		return strings.HasPrefix(e.Token.Literal, "-")
+	}
+	return false
+}
+
+func (p *printer) ifExpression(e *ast.IfExpression) {
+	oneLine := p.oneLine(e.Consequence, e.Alternative)
+	if oneLine && p.width > 0 {
+		oneLine = p.fits(p.measure(func(q *printer) { q.ifExpression(e) }))
+	}
+
+	p.token(e.Token, "if")
+	p.write(" (")
+	p.expr(e.Condition)
+	p.write(") ")
+	p.block(e.Consequence, oneLine)
+	if e.Alternative != nil {
+		p.write(" else ")
+		p.block(e.Alternative, oneLine)
+	}
+}
+
+func (p *printer) fnExpression(e *ast.FnExpression) {
+	oneLine := p.oneLine(e.Body)
+	if oneLine && p.width > 0 {
+		oneLine = p.fits(p.measure(func(q *printer) { q.fnExpression(e) }))
+	}
+
+	p.token(e.Token, "fn")
+	p.write("(")
+	for i, param := range e.Parameters {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.expr(param)
+	}
+	p.write(") ")
+	p.block(e.Body, oneLine)
+}
+
+// 字符串字面量保持源码中的写法 没有源码时按值重新转义
+func (p *printer) stringLiteral(s *ast.StringLiteral) string {
+	from, to := s.Token.Pos.Offset, s.Token.End.Offset
+	if p.src != nil && s.Token.Pos.IsValid() && from < to && to <= len(p.src) {
+		if text := string(p.src[from:to]); text[0] == '"' || text[0] == '`' {
+			return text
+		}
+	}
+	return `"` + escape(s.Value) + `"`
+}
+
+// 模板字符串中的插值不拆行
+func (p *printer) templateLiteral(t *ast.TemplateLiteral) {
+	if len(t.Strings) != len(t.Values)+1 {
+		p.fail("format: template literal has %d strings for %d values", len(t.Strings), len(t.Values))
+		return
+	}
+	width := p.width
+	p.width = 0
+	defer func() { p.width = width }()
+
+	p.token(t.Token, `"`+escape(t.Strings[0])+"${")
+	for i, value := range t.Values {
+		p.expr(value)
+		tail := escape(t.Strings[i+1])
+		if i == len(t.Values)-1 {
+			p.token(t.Tail, "}"+tail+`"`)
+		} else {
+			p.write("}" + tail + "${")
+		}
+	}
+}
+
+// 列表中的一项 数组元素、调用参数或者哈希的键值对
+type item struct {
+	key   ast.Expression // 只有哈希有
+	value ast.Expression
+}
+
+func expressionItems(list []ast.Expression) []item {
+	items := make([]item, len(list))
+	for i, e := range list {
+		items[i] = item{value: e}
+	}
+	return items
+}
+
+func (it item) pos() token.Position {
+	if it.key != nil {
+		return it.key.Pos()
+	}
+	if it.value == nil {
+		return token.Position{}
+	}
+	return it.value.Pos()
+}
+
+func (p *printer) item(it item) {
+	if it.key != nil {
+		p.expr(it.key)
+		p.write(": ")
+	}
+	p.expr(it.value)
+}
+
+// 输出逗号分隔的列表
+// 放得下时写在一行；hugLast时最后一项是函数字面量的话，只要第一行放得下就不拆开列表
+// 否则每项一行
+func (p *printer) list(open token.Token, openText string, items []item, close token.Token, closeText string, hugLast bool) {
+	p.token(open, openText)
+	if !p.hasComments(open.End, close.Pos) && p.flat(items, closeText, hugLast) {
+		for i, it := range items {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.item(it)
+		}
+		p.token(close, closeText)
+		return
+	}
+
+	l := p.push()
+	p.indent++
+	for i, it := range items {
+		p.linebreak(it.pos())
+		p.flush(it.pos())
+		p.item(it)
+		if i < len(items)-1 {
+			p.write(",")
+		}
+	}
+	p.linebreak(close.Pos)
+	p.flush(close.Pos)
+	p.indent--
+	p.token(close, closeText)
+	p.pop(l)
+}
+
+// 列表能否写在一行
+func (p *printer) flat(items []item, closeText string, hugLast bool) bool {
+	if p.width <= 0 || len(items) == 0 {
+		return true
+	}
+	text := p.measure(func(q *printer) {
+		for i, it := range items {
+			if i > 0 {
+				q.write(", ")
+			}
+			q.item(it)
+		}
+		q.write(closeText)
+	})
+	i := strings.IndexByte(text, '\n')
+	if i < 0 {
+		return p.fits(text)
+	}
+	if !hugLast {
+		return false
+	}
+	if _, ok := items[len(items)-1].value.(*ast.FnExpression); !ok {
+		return false
+	}
+	// 前面的项都在第一行
+	last := p.measure(func(q *printer) { q.item(items[len(items)-1]) })
+	return strings.HasSuffix(text, last+closeText) &&
+		!strings.Contains(strings.TrimSuffix(text, last+closeText), "\n") &&
+		p.fits(text[:i])
+}
+
+// endregion
+
+// region 字符串
+
+// 把字符串的值转义成可以放在双引号中的形式
+func escape(s string) string {
+	var out strings.Builder
+	for i, r := range s {
+		switch r {
+		case '"':
+			out.WriteString(`\"`)
+		case '\\':
+			out.WriteString(`\\`)
+		case '\n':
+			out.WriteString(`\n`)
+		case '\t':
+			out.WriteString(`\t`)
+		case '\r':
+			out.WriteString(`\r`)
+		case '\b':
+			out.WriteString(`\b`)
+		case 0:
+			out.WriteString(`\0`)
+		case '$':
+			if strings.HasPrefix(s[i+1:], "{") {
+				out.WriteString(`\$`)
+			} else {
+				out.WriteByte('$')
+			}
+		default:
+			if r < 0x20 || r == 0x7F {
+				fmt.Fprintf(&out, `\x%02X`, r)
+			} else {
+				out.WriteRune(r)
+			}
+		}
+	}
+	return out.String()
+}
+
+// 没有源码字面量的浮点数 保证写出来仍然是浮点数
+func formatFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
+// endregion
+
+func (p *printer) fail(format string, a ...interface{}) {
+	if p.err == nil {
+		p.err = fmt.Errorf(format, a...)
+	}
+}
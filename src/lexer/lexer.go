@@ -3,30 +3,126 @@ package lexer
 import (
 	"MyCompiler/src/token"
 	"bytes"
+	"io"
+	"unicode"
+	"unicode/utf8"
 )
 
-// TODO: 支持Unicode， 需要把ch改成rune，并且要更换readChar的逻辑
+// 从io.Reader每次读取的字节数
+const readSize = 4096
+
+// Lexer 按UTF-8解码输入 每次读取一个码点
+// position和readPosition是从输入开头算起的字节偏移，列号按码点计数
 type Lexer struct {
-	input        string // 输入字符串
-	position     int    // 指向当前位置
-	readPosition int    // 指向当前位置之后的一个字符
-	ch           byte   // 当前字符 (只支持ASCII)
+	// 输入的缓冲区 buf[0]是输入中偏移为base的字节
+	// 从io.Reader读取时按需补充，并丢弃当前词法单元之前已经用完的部分
+	buf  []byte
+	base int
+	mark int       // 当前词法单元开始的偏移 补充缓冲区时保留它之后的内容
+	src  io.Reader // 字符串输入时为nil
+	err  error     // 读取src时遇到的错误 io.EOF不算错误
+
+	position     int  // 指向当前字符的第一个字节
+	readPosition int  // 指向当前字符之后的一个字节
+	ch           rune // 当前字符
+	invalid      bool // 当前字符是不合法的UTF-8字节
+
+	filename string // 报错时使用的文件名
+	line     int    // 当前字符所在的行
+	column   int    // 当前字符所在的列
+
+	mode Mode
+
+	// 正在读取的模板插值 ${...} 每层记录插值内部尚未闭合的 { 个数
+	// 遇到与 ${ 配对的 } 时继续读取模板字符串的剩余部分
+	templates []int
 }
 
+// Mode 控制词法分析器的可选行为
+type Mode uint
+
+const (
+	// KeepComments 把注释作为trivia挂到其后第一个词法单元的Leading上
+	// 不设置时注释直接丢弃
+	KeepComments Mode = 1 << iota
+)
+
 // 构造函数，使用input创建Lexer实例
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewWithFilename("", input)
+}
+
+// NewWithFilename 创建Lexer实例 词法单元的位置会带上文件名
+func NewWithFilename(filename string, input string) *Lexer {
+	l := &Lexer{buf: []byte(input), filename: filename, line: 1}
 	// 初始化
 	l.readChar()
 	return l
 }
 
+// NewReader 从r中边读边分析，不需要事先读入全部输入
+// 得到的词法单元和位置与把全部输入传给New时完全相同
+func NewReader(r io.Reader) *Lexer {
+	return NewReaderWithFilename("", r)
+}
+
+// NewReaderWithFilename 从r中读取输入 词法单元的位置会带上文件名
+func NewReaderWithFilename(filename string, r io.Reader) *Lexer {
+	l := &Lexer{src: r, filename: filename, line: 1}
+	l.readChar()
+	return l
+}
+
+// Err 返回读取输入时遇到的第一个错误 读到io.EOF正常结束时返回nil
+// 出错后输入被当作在出错的位置结束
+func (l *Lexer) Err() error {
+	return l.err
+}
+
+// SetMode 设置词法分析器的模式 应在读取第一个词法单元之前调用
+func (l *Lexer) SetMode(mode Mode) {
+	l.mode = mode
+}
+
 func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
+	var comments []token.Comment
 
-	// 空白类字符不检测
-	l.skipWhitespace()
+	// 之前的词法单元都已经复制成了字符串 缓冲区中当前字符之前的内容不再需要
+	l.mark = l.position
 
+	// 跳过空白和注释
+	for {
+		l.skipWhitespace()
+		if l.ch != '/' || (l.peekChar() != '/' && l.peekChar() != '*') {
+			break
+		}
+
+		comment, ok := l.readComment()
+		if !ok {
+			// 块注释没有结束 Literal为整段注释
+			return token.Token{
+				Type:    token.ILLEGAL,
+				Literal: comment.Text,
+				Pos:     comment.Pos,
+				End:     comment.End,
+				Leading: comments,
+			}
+		}
+		if l.mode&KeepComments != 0 {
+			comments = append(comments, comment)
+		}
+	}
+
+	tok := l.readToken()
+	tok.Leading = comments
+	return tok
+}
+
+// 读取一个词法单元 调用前已经跳过了空白和注释
+func (l *Lexer) readToken() token.Token {
+	var tok token.Token
+
+	start := l.pos()
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
@@ -52,9 +148,34 @@ func (l *Lexer) NextToken() token.Token {
 			tok = tokenFactory(token.BANG, l.ch)
 		}
 	case '>':
-		tok = tokenFactory(token.GT, l.ch)
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.GT_EQ, Literal: ">="}
+		} else {
+			tok = tokenFactory(token.GT, l.ch)
+		}
 	case '<':
-		tok = tokenFactory(token.LT, l.ch)
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.LT_EQ, Literal: "<="}
+		} else {
+			tok = tokenFactory(token.LT, l.ch)
+		}
+	case '&':
+		// 单独的&不是合法的运算符
+		if l.peekChar() == '&' {
+			l.readChar()
+			tok = token.Token{Type: token.AND, Literal: "&&"}
+		} else {
+			tok = tokenFactory(token.ILLEGAL, l.ch)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			l.readChar()
+			tok = token.Token{Type: token.OR, Literal: "||"}
+		} else {
+			tok = tokenFactory(token.ILLEGAL, l.ch)
+		}
 	case ',':
 		tok = tokenFactory(token.COMMA, l.ch)
 	case ';':
@@ -64,12 +185,31 @@ func (l *Lexer) NextToken() token.Token {
 	case ')':
 		tok = tokenFactory(token.RPAREN, l.ch)
 	case '{':
+		if n := len(l.templates); n > 0 {
+			l.templates[n-1]++
+		}
 		tok = tokenFactory(token.LBRACE, l.ch)
 	case '}':
+		n := len(l.templates)
+		if n > 0 && l.templates[n-1] == 0 {
+			// 插值结束 继续读取模板字符串
+			l.templates = l.templates[:n-1]
+			return l.readStringToken(start, true)
+		}
+		if n > 0 {
+			l.templates[n-1]--
+		}
 		tok = tokenFactory(token.RBRACE, l.ch)
 	case '"':
+		return l.readStringToken(start, false)
+	case '`':
+		literal, ok := l.readRawString()
+		if !ok {
+			// 没有结尾的反引号 Literal为从开头反引号到文件末尾的全部内容
+			return token.Token{Type: token.ILLEGAL, Literal: literal, Pos: start, End: l.pos()}
+		}
 		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		tok.Literal = literal
 	case '[':
 		tok = tokenFactory(token.LBRACKET, l.ch)
 	case ']':
@@ -78,7 +218,8 @@ func (l *Lexer) NextToken() token.Token {
 		tok = tokenFactory(token.COLON, l.ch)
 	case 0:
 		// 读到的字符为空 - 返回空字符串（这里需要特殊处理）
-		tok = token.Token{Type: token.EOF}
+		// EOF没有长度 也不再移动指针
+		return token.Token{Type: token.EOF, Pos: start, End: start}
 	default:
 		if isLetter(l.ch) {
 			// 如果是字符
@@ -86,12 +227,16 @@ func (l *Lexer) NextToken() token.Token {
 			// 根据字符值匹配关键词，从而决定Type
 			tok.Type = token.LookupIdent(tok.Literal)
 			// 这个时候不继续读下一个字符，直接返回
+			tok.Pos, tok.End = start, l.pos()
 			return tok
 		} else if isDigit(l.ch) {
 			// 如果是数字
-			tok.Type = token.INT
-			tok.Literal = l.readNum()
+			tok.Literal, tok.Type = l.readNum()
+			tok.Pos, tok.End = start, l.pos()
 			return tok
+		} else if l.invalid {
+			// 不合法的UTF-8 Literal保留原始字节 由语法分析器报告
+			tok = token.Token{Type: token.ILLEGAL, Literal: l.text(l.position, l.readPosition)}
 		} else {
 			tok = tokenFactory(token.ILLEGAL, l.ch)
 		}
@@ -99,9 +244,61 @@ func (l *Lexer) NextToken() token.Token {
 
 	// 指针移动到下一个字符
 	l.readChar()
+	tok.Pos, tok.End = start, l.pos()
 	return tok
 }
 
+// 当前字符的位置
+func (l *Lexer) pos() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.position,
+		Line:     l.line,
+		Column:   l.column,
+	}
+}
+
+// 读取一段注释 当前字符是注释开头的 /
+// 行注释到行尾为止(不包括换行)；块注释可以嵌套，没有结束时返回false
+func (l *Lexer) readComment() (token.Comment, bool) {
+	start := l.pos()
+	l.readChar()
+
+	if l.ch == '/' {
+		for l.ch != '\n' && l.ch != 0 {
+			l.readChar()
+		}
+		return l.comment(start), true
+	}
+
+	// 块注释 depth是尚未闭合的 /* 个数
+	l.readChar()
+	depth := 1
+	for depth > 0 {
+		switch {
+		case l.ch == 0:
+			return l.comment(start), false
+		case l.ch == '/' && l.peekChar() == '*':
+			l.readChar()
+			depth++
+		case l.ch == '*' && l.peekChar() == '/':
+			l.readChar()
+			depth--
+		}
+		l.readChar()
+	}
+	return l.comment(start), true
+}
+
+// 从start到当前位置的注释
+func (l *Lexer) comment(start token.Position) token.Comment {
+	return token.Comment{
+		Text: l.text(start.Offset, l.position),
+		Pos:  start,
+		End:  l.pos(),
+	}
+}
+
 // 跳过空白字符
 func (l *Lexer) skipWhitespace() {
 	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
@@ -116,91 +313,334 @@ func (l *Lexer) readIdentifier() string {
 		l.readChar()
 	}
 	endPos := l.position
-	return l.input[startPos:endPos]
+	return l.text(startPos, endPos)
 }
 
-// 读取数字
-func (l *Lexer) readNum() string {
+// 读取数字 返回字面量和INT或FLOAT
+// 整数: 十进制，或带0x 0o 0b前缀的十六/八/二进制，数字之间可以用_分隔
+// 浮点数: 整数部分 [. 小数部分] [e|E [+|-] 指数]，小数点后必须有数字
+// 这里只切分出字面量，数字是否合法(如0b102 1__0 1e)由语法分析器检查
+func (l *Lexer) readNum() (string, token.TokenType) {
 	startPos := l.position
-	for isDigit(l.ch) {
+	tokenType := token.TokenType(token.INT)
+
+	if l.ch == '0' && isBasePrefix(l.peekChar()) {
+		l.readChar()
+		l.readChar()
+		// 把后面所有的字母数字都读进来，好在报错时给出完整的字面量
+		for isASCIIAlnum(l.ch) || l.ch == '_' {
+			l.readChar()
+		}
+		return l.text(startPos, l.position), tokenType
+	}
+
+	l.readDigits()
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar()
+		l.readDigits()
+	}
+	if l.ch == 'e' || l.ch == 'E' {
+		tokenType = token.FLOAT
+		l.readChar()
+		if l.ch == '+' || l.ch == '-' {
+			l.readChar()
+		}
+		l.readDigits()
+	}
+
+	return l.text(startPos, l.position), tokenType
+}
+
+// 读取十进制数字和分隔符_
+func (l *Lexer) readDigits() {
+	for isDigit(l.ch) || l.ch == '_' {
 		l.readChar()
 	}
-	endPos := l.position
-	return l.input[startPos:endPos]
 }
 
-// 判断是否是数字
-func isDigit(c byte) bool {
-	// 只接受 a-zA-Z_ 作为标识符
+// 0x 0o 0b 前缀的第二个字符
+func isBasePrefix(c rune) bool {
+	switch c {
+	case 'x', 'X', 'o', 'O', 'b', 'B':
+		return true
+	}
+	return false
+}
+
+func isASCIIAlnum(c rune) bool {
+	return isDigit(c) || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// 判断是否是数字 只接受ASCII数字
+func isDigit(c rune) bool {
 	return '0' <= c && c <= '9'
 }
 
 // 判断是否是有效字母
-func isLetter(c byte) bool {
-	// 只接受 a-zA-Z_ 作为标识符
-	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_'
+// 标识符由Unicode字母(unicode.IsLetter，包括汉字等)和下划线组成
+func isLetter(c rune) bool {
+	return unicode.IsLetter(c) || c == '_'
 }
 
 // 读取字符 移动指针
 func (l *Lexer) readChar() {
-	if l.readPosition >= len(l.input) {
-		// 如果指针到底了, 置ch为0
-		l.ch = 0
+	// 上一个字符是换行时进入下一行
+	if l.ch == '\n' {
+		l.line++
+		l.column = 1
 	} else {
-		l.ch = l.input[l.readPosition]
+		l.column++
 	}
+
 	// 指向下一个位置
 	l.position = l.readPosition
-	l.readPosition += 1
+	next := l.lookahead()
+	if len(next) == 0 {
+		// 如果指针到底了, 置ch为0
+		l.ch = 0
+		l.invalid = false
+		return
+	}
+
+	r, size := utf8.DecodeRune(next)
+	l.ch = r
+	// 解码失败时返回RuneError且只消耗一个字节
+	l.invalid = r == utf8.RuneError && size == 1
+	l.readPosition += size
 }
 
 // 查看下一个字符，不移动指针
-func (l *Lexer) peekChar() byte {
-	if l.readPosition >= len(l.input) {
+func (l *Lexer) peekChar() rune {
+	next := l.lookahead()
+	if len(next) == 0 {
 		return 0
-	} else {
-		return l.input[l.readPosition]
 	}
+	r, _ := utf8.DecodeRune(next)
+	return r
 }
 
-func (l *Lexer) readString() string {
-	// position := l.position + 1
-	var out bytes.Buffer
+// 是否已经读到输入末尾 当前字符为0时用来区分输入中的0字节
+func (l *Lexer) atEOF() bool {
+	return l.position == l.readPosition
+}
+
+// 缓冲区中从readPosition开始的字节 至少包含一个完整的UTF-8字符，除非输入已经结束
+func (l *Lexer) lookahead() []byte {
+	for l.src != nil && l.readPosition-l.base+utf8.UTFMax > len(l.buf) {
+		l.fill()
+	}
+	return l.buf[l.readPosition-l.base:]
+}
+
+// 从src再读一块到缓冲区 读到末尾或出错后把src置为nil
+func (l *Lexer) fill() {
+	// 丢弃当前词法单元之前的内容
+	if drop := l.mark - l.base; drop > 0 {
+		l.buf = append(l.buf[:0], l.buf[drop:]...)
+		l.base = l.mark
+	}
+
+	n := len(l.buf)
+	if cap(l.buf)-n < readSize {
+		grown := make([]byte, n, 2*cap(l.buf)+readSize)
+		copy(grown, l.buf)
+		l.buf = grown
+	}
+	read, err := l.src.Read(l.buf[n : n+readSize])
+	l.buf = l.buf[:n+read]
+	if err != nil {
+		if err != io.EOF {
+			l.err = err
+		}
+		l.src = nil
+	}
+}
+
+// 输入中[from, to)之间的文本 from不能在当前词法单元开始之前
+func (l *Lexer) text(from, to int) string {
+	return string(l.buf[from-l.base : to-l.base])
+}
+
+// 读取字符串或模板字符串的一段 当前字符是开头的引号，或者是结束插值的 }
+// resuming表示是在插值之后继续读取模板字符串
+func (l *Lexer) readStringToken(start token.Position, resuming bool) token.Token {
+	literal, interpolation, illegal := l.readString(start)
+	// 跳过结尾的引号或 ${ 的 {
+	l.readChar()
+	if illegal != nil {
+		return *illegal
+	}
+
+	tok := token.Token{Literal: literal, Pos: start, End: l.pos()}
+	switch {
+	case interpolation && !resuming:
+		tok.Type = token.TEMPLATE_HEAD
+	case interpolation:
+		tok.Type = token.TEMPLATE_MIDDLE
+	case resuming:
+		tok.Type = token.TEMPLATE_TAIL
+	default:
+		tok.Type = token.STRING
+	}
+	if interpolation {
+		l.templates = append(l.templates, 0)
+	}
+	return tok
+}
+
+// 读取反引号包围的原始字符串 可以跨行，不处理转义和插值
+// 没有结尾的反引号时返回从开头到文件末尾的内容和false
+func (l *Lexer) readRawString() (string, bool) {
+	start := l.position
 	for {
 		l.readChar()
-		if l.ch == '\\' {
-			// 如果当前是\,就往后读两次
-			l.readChar()
-			handleEscape(&out, l.ch)
-			continue
+		if l.ch == '`' {
+			return l.text(start+1, l.position), true
 		}
-		if l.ch == '"' || l.ch == 0 {
-			break
+		if l.ch == 0 && l.atEOF() {
+			return l.text(start, l.position), false
 		}
-		out.WriteByte(l.ch)
 	}
-	return out.String()
 }
 
-// 创建Token的工厂方法
-func tokenFactory(tokenType token.TokenType, ch byte) token.Token {
-	return token.Token{Type: tokenType, Literal: string(ch)}
+// 读取字符串字面量 start是开头引号的位置
+// 返回时当前字符是结尾的引号，或者遇到插值时是 ${ 的 {，这时interpolation为true
+// 出错时返回ILLEGAL词法单元 Literal为出错的源码:
+//   - 不合法的UTF-8: 出错的字节
+//   - 不合法的转义: 从\开始的转义序列
+//   - 没有结尾的引号: 从开头引号到文件末尾的全部内容
+//
+// 字符串中间出错时仍读到结尾的引号，只报告第一个错误
+func (l *Lexer) readString(start token.Position) (literal string, interpolation bool, illegal *token.Token) {
+	var out bytes.Buffer
+
+	for {
+		l.readChar()
+		switch {
+		case l.ch == 0 && l.atEOF():
+			return "", false, &token.Token{
+				Type:    token.ILLEGAL,
+				Literal: l.text(start.Offset, l.position),
+				Pos:     start,
+				End:     l.pos(),
+			}
+		case l.ch == '"' || l.ch == '$' && l.peekChar() == '{':
+			interpolation = l.ch == '$'
+			if interpolation {
+				l.readChar()
+			}
+			if illegal != nil {
+				return "", interpolation, illegal
+			}
+			return out.String(), interpolation, nil
+		case l.invalid:
+			if illegal == nil {
+				illegal = l.illegalFrom(l.pos())
+			}
+		case l.ch == '\\':
+			escStart := l.pos()
+			if !l.readEscape(&out) && illegal == nil {
+				illegal = l.illegalFrom(escStart)
+			}
+		default:
+			out.WriteRune(l.ch)
+		}
+	}
 }
 
-// 处理转义字符
-func handleEscape(out *bytes.Buffer, ch byte) {
-	if ch == 'n' {
+// 从from到当前字符(包括当前字符)的ILLEGAL词法单元
+func (l *Lexer) illegalFrom(from token.Position) *token.Token {
+	end := l.pos()
+	end.Offset = l.readPosition
+	end.Column++
+	return &token.Token{
+		Type:    token.ILLEGAL,
+		Literal: l.text(from.Offset, l.readPosition),
+		Pos:     from,
+		End:     end,
+	}
+}
+
+// 读取一个转义序列 当前字符是\ 返回时当前字符是转义序列的最后一个字符
+// 支持 \n \t \r \b \0 \" \\ \$ \xHH(只允许ASCII 00-7F) \u{H...}(1到6位十六进制的Unicode码点)
+// 不认识的转义或格式错误时返回false
+func (l *Lexer) readEscape(out *bytes.Buffer) bool {
+	l.readChar()
+	switch l.ch {
+	case 'n':
 		out.WriteByte('\n')
-	} else if ch == '"' {
+	case 't':
+		out.WriteByte('\t')
+	case 'r':
+		out.WriteByte('\r')
+	case 'b':
+		out.WriteByte('\b')
+	case '0':
+		out.WriteByte(0)
+	case '"':
 		out.WriteByte('"')
-	} else if ch == '\\' {
+	case '\\':
 		out.WriteByte('\\')
-	} else if ch == 'b' {
-		out.WriteByte('\b')
-	} else if ch == '\r' {
-		out.WriteByte('\r')
-	} else if ch == '\t' {
-		out.WriteByte('\t')
+	case '$':
+		// 用 \${ 写出不是插值的 ${
+		out.WriteByte('$')
+	case 'x':
+		// 高于7F的单个字节不是合法的UTF-8 要用\u{...}
+		value, n := l.readHexDigits(2)
+		if n != 2 || value > 0x7F {
+			return false
+		}
+		out.WriteByte(byte(value))
+	case 'u':
+		if l.peekChar() != '{' {
+			return false
+		}
+		l.readChar()
+		value, n := l.readHexDigits(6)
+		if n == 0 || l.peekChar() != '}' {
+			return false
+		}
+		l.readChar()
+		r := rune(value)
+		if !utf8.ValidRune(r) {
+			// 超出范围或者是代理码点
+			return false
+		}
+		out.WriteRune(r)
+	default:
+		return false
 	}
+	return true
+}
 
+// 读取最多max个十六进制数字 返回数值和读到的个数
+func (l *Lexer) readHexDigits(max int) (int, int) {
+	value, n := 0, 0
+	for n < max && isHexDigit(l.peekChar()) {
+		l.readChar()
+		value = value*16 + hexValue(l.ch)
+		n++
+	}
+	return value, n
+}
+
+func isHexDigit(c rune) bool {
+	return isDigit(c) || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func hexValue(c rune) int {
+	switch {
+	case isDigit(c):
+		return int(c - '0')
+	case 'a' <= c && c <= 'f':
+		return int(c-'a') + 10
+	default:
+		return int(c-'A') + 10
+	}
+}
+
+// 创建Token的工厂方法
+func tokenFactory(tokenType token.TokenType, ch rune) token.Token {
+	return token.Token{Type: tokenType, Literal: string(ch)}
 }
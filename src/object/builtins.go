@@ -0,0 +1,77 @@
+package object
+
+import "fmt"
+
+// Builtins 所有的内置函数
+// 求值器按名称查找；编译器和虚拟机按在这个列表中的下标引用，所以只能在末尾追加
+var Builtins = []*Builtin{
+	{
+		Name:  "len",
+		Arity: 1,
+		Doc:   "len(x) 返回字符串的码点个数或数组的元素个数",
+		Fn: func(args ...Object) (Object, error) {
+			switch arg := args[0].(type) {
+			case *String:
+				return &Integer{Value: int64(arg.Len())}, nil
+			case *Array:
+				return &Integer{Value: int64(len(arg.Elements))}, nil
+			default:
+				return nil, fmt.Errorf("argument to `len` not supported, got %s", args[0].Type())
+			}
+		},
+	},
+	{
+		Name:  "first",
+		Arity: 1,
+		Doc:   "first(arr) 返回数组的第一个元素，数组为空时返回null",
+		Fn: func(args ...Object) (Object, error) {
+			if args[0].Type() != ARRAY_OBJ {
+				return nil, fmt.Errorf("argument to `first` must be ARRAY, got %s", args[0].Type())
+			}
+
+			arr := args[0].(*Array)
+			if len(arr.Elements) > 0 {
+				return arr.Elements[0], nil
+			}
+			return nil, nil
+		},
+	},
+	{
+		Name:  "last",
+		Arity: 1,
+		Doc:   "last(arr) 返回数组的最后一个元素，数组为空时返回null",
+		Fn: func(args ...Object) (Object, error) {
+			if args[0].Type() != ARRAY_OBJ {
+				return nil, fmt.Errorf("argument to `last` must be ARRAY, got %s", args[0].Type())
+			}
+
+			arr := args[0].(*Array)
+			if len(arr.Elements) > 0 {
+				return arr.Elements[len(arr.Elements)-1], nil
+			}
+			return nil, nil
+		},
+	},
+	{
+		Name:  "print",
+		Arity: -1,
+		Doc:   "print(args...) 逐行输出每个参数，返回null",
+		Fn: func(args ...Object) (Object, error) {
+			for _, arg := range args {
+				// 挨个输出即可
+				fmt.Println(arg.Inspect())
+			}
+			return nil, nil
+		},
+	},
+}
+
+// GetBuiltinByName 按名称查找内置函数 找不到时返回nil
+func GetBuiltinByName(name string) *Builtin {
+	for _, b := range Builtins {
+		if b.Name == name {
+			return b
+		}
+	}
+	return nil
+}
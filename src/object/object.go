@@ -2,10 +2,13 @@ package object
 
 import (
 	"MyCompiler/src/ast"
+	"MyCompiler/src/code"
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 // 值类型对象
@@ -15,15 +18,21 @@ type ObjectType string
 
 const (
 	INTEGER_OBJ      = "INTEGER"
+	FLOAT_OBJ        = "FLOAT"
 	BOOLEAN_OBJ      = "BOOLEAN"
 	NULL_OBJ         = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
+	BREAK_OBJ        = "BREAK"
+	CONTINUE_OBJ     = "CONTINUE"
 	ERROR_OBJ        = "ERROR"
 	FUNCTION_OBJ     = "FUNCTION"
 	STRING_OBJ       = "STRING"
 	BUILTIN_OBJ      = "BUILTIN"
 	ARRAY_OBJ        = "ARRAY"
 	HASH_OBJ         = "HASH"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	CLOSURE_OBJ           = "CLOSURE"
 )
 
 type Object interface {
@@ -43,6 +52,38 @@ func (i *Integer) Inspect() string { return fmt.Sprintf("%d", i.Value) }
 
 // endregion
 
+// region Float
+
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+// Inspect 输出最短的能还原出同一个值的形式
+// 没有小数点和指数时补上".0"，保证再读回来仍是浮点数
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
+// ToFloat 把整数或浮点数转换成float64 其他类型返回false
+// 整数和浮点数混合运算时先把整数转成浮点数
+func ToFloat(obj Object) (float64, bool) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return float64(obj.Value), true
+	case *Float:
+		return obj.Value, true
+	}
+	return 0, false
+}
+
+// endregion
+
 // region Boolean
 
 type Boolean struct {
@@ -77,6 +118,24 @@ func (r *ReturnValue) Inspect() string { return r.Value.Inspect() }
 
 // endregion
 
+// region Break Continue
+
+// Break 解释器执行break语句的结果 向外传递到最内层的循环
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+
+func (b *Break) Inspect() string { return "break" }
+
+// Continue 解释器执行continue语句的结果 向外传递到最内层的循环
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+
+func (c *Continue) Inspect() string { return "continue" }
+
+// endregion
+
 // region Function
 
 type Function struct {
@@ -107,6 +166,39 @@ func (f *Function) Inspect() string {
 
 // endregion
 
+// region CompiledFunction
+
+// CompiledFunction 编译后的函数 作为常量保存在常量池中
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	NumLocals     int // 局部变量个数(包含参数)
+	NumParameters int // 参数个数
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// endregion
+
+// region Closure
+
+// Closure 闭包 编译后的函数加上它捕获的自由变量
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+func (c *Closure) Type() ObjectType { return CLOSURE_OBJ }
+
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
+// endregion
+
 // region Error
 
 type Error struct {
@@ -129,19 +221,49 @@ func (s String) Type() ObjectType { return STRING_OBJ }
 
 func (s String) Inspect() string { return s.Value }
 
+// Len 字符串的长度 按Unicode码点计数
+func (s String) Len() int { return utf8.RuneCountInString(s.Value) }
+
+// CharAt 返回第i个码点组成的字符串 越界时ok为false
+func (s String) CharAt(i int64) (ch *String, ok bool) {
+	if i < 0 {
+		return nil, false
+	}
+	for _, r := range s.Value {
+		if i == 0 {
+			return &String{Value: string(r)}, true
+		}
+		i--
+	}
+	return nil, false
+}
+
 // endregion
 
 // region Builtin function
 
-type BuiltinFunction func(args ...Object) Object
+// BuiltinFunction 内置函数的实现
+// 出错时返回error，由求值器和虚拟机各自转换成自己的错误；返回nil对象表示null
+type BuiltinFunction func(args ...Object) (Object, error)
 
 type Builtin struct {
-	Fn BuiltinFunction
+	Name  string
+	Arity int    // 参数个数 -1表示参数个数可变
+	Doc   string // 说明文档
+	Fn    BuiltinFunction
 }
 
 func (b Builtin) Type() ObjectType { return BUILTIN_OBJ }
 
-func (b Builtin) Inspect() string { return "builtin function" }
+func (b Builtin) Inspect() string { return "builtin function: " + b.Name }
+
+// Call 检查参数个数后调用内置函数
+func (b *Builtin) Call(args ...Object) (Object, error) {
+	if b.Arity >= 0 && len(args) != b.Arity {
+		return nil, fmt.Errorf("wrong number of arguments. got=%d, want=%d", len(args), b.Arity)
+	}
+	return b.Fn(args...)
+}
 
 // endregion
 
@@ -4,14 +4,23 @@ import (
 	"MyCompiler/src/ast"
 	"MyCompiler/src/lexer"
 	"MyCompiler/src/token"
+	"errors"
 	"fmt"
+	"math"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 type Parser struct {
 	l      *lexer.Lexer
 	errors []string // 错误信息
 
+	// 出错之后到跳到下一条语句之前为true 这期间的错误都是连带产生的，不再记录
+	recovering bool
+	braceDepth int // 到当前词法单元为止未闭合的 { 个数 用来判断右花括号属于哪一层
+	parenDepth int // 到当前词法单元为止未闭合的 ( 个数 括号中的分号(如for的头部)不是语句的边界
+
 	curToken  token.Token
 	peekToken token.Token
 
@@ -28,8 +37,10 @@ type (
 const (
 	_ int = iota
 	LOWEST
+	LOGICAL_OR  // ||
+	LOGICAL_AND // &&
 	EQUALS      // ==
-	LESSGREATER // > or <
+	LESSGREATER // > or < or >= or <=
 	SUM         // +
 	PRODUCT     // *
 	PREFIX      // -X or !X
@@ -45,6 +56,10 @@ var precedences = map[token.TokenType]int{
 	token.NOT_EQ:   EQUALS,
 	token.LT:       LESSGREATER,
 	token.GT:       LESSGREATER,
+	token.LT_EQ:    LESSGREATER,
+	token.GT_EQ:    LESSGREATER,
+	token.AND:      LOGICAL_AND,
+	token.OR:       LOGICAL_OR,
 	token.PLUS:     SUM,
 	token.MINUS:    SUM,
 	token.SLASH:    PRODUCT,
@@ -84,6 +99,7 @@ func New(l *lexer.Lexer) *Parser {
 	// 注册前缀解析函数(用来解析字面量表达式和！ -这样的前缀表达式)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
@@ -92,8 +108,10 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.IF, p.parseIfExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFnExpression)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.TEMPLATE_HEAD, p.parseTemplateLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefix(token.ILLEGAL, p.parseIllegal)
 
 	// 注册中缀解析函数
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
@@ -105,6 +123,10 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
 	// 用中缀解析左括号 用作解析调用函数
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	// 解析左方括号
@@ -116,6 +138,18 @@ func New(l *lexer.Lexer) *Parser {
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+
+	switch {
+	case p.curTokenIs(token.LBRACE):
+		p.braceDepth++
+	case p.curTokenIs(token.RBRACE) && p.braceDepth > 0:
+		// 多余的右花括号不计入 出错后仍能正常分析后面的代码
+		p.braceDepth--
+	case p.curTokenIs(token.LPAREN):
+		p.parenDepth++
+	case p.curTokenIs(token.RPAREN) && p.parenDepth > 0:
+		p.parenDepth--
+	}
 }
 
 // ParseProgram 解析返回ast
@@ -123,27 +157,123 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program := &ast.Program{Statement: []ast.Statement{}}
 
 	for p.curToken.Type != token.EOF {
-		stmt := p.parseStatement()
-		if stmt != nil {
-			program.Statement = append(program.Statement, stmt)
-		}
+		stmt := p.parseStatementWithRecovery()
+		program.Statement = append(program.Statement, stmt)
 		p.nextToken()
 	}
 
 	return program
 }
 
-// Error 返回错误信息
+// Error 返回错误信息 每条信息都以 file:line:col 开头
 func (p *Parser) Error() []string {
 	return p.errors
 }
 
+// 在pos位置记录一条错误 同一条语句中只记录第一个错误
+func (p *Parser) addError(pos token.Position, format string, a ...interface{}) {
+	if p.recovering {
+		return
+	}
+	msg := fmt.Sprintf(format, a...)
+	p.errors = append(p.errors, pos.String()+": "+msg)
+	p.recovering = true
+}
+
+// region 错误恢复
+
+// 解析一条语句 出错时跳到语句的边界，用BadStatement标记跳过的部分
+// 返回时当前词法单元是语句的最后一个词法单元，或者是外层块语句的右花括号
+func (p *Parser) parseStatementWithRecovery() ast.Statement {
+	from := p.curToken
+	// 语句开始之前的花括号层数
+	depth := p.braceDepth
+	if p.curTokenIs(token.LBRACE) {
+		depth--
+	}
+	// 语句开始之前的圆括号层数 前面的语句可能留下没有闭合的圆括号，所以只比较相对的层数
+	parens := p.parenDepth
+	if p.curTokenIs(token.LPAREN) {
+		parens--
+	}
+
+	stmt := p.parseStatement()
+	if !p.recovering {
+		return stmt
+	}
+
+	p.synchronize(depth, parens)
+	p.recovering = false
+	to := p.curToken
+	if p.braceDepth < depth {
+		// 当前的右花括号属于外层的块语句 出错的部分到它之前为止
+		to = token.Token{Type: token.ILLEGAL, Pos: to.Pos, End: to.Pos}
+	}
+	return &ast.BadStatement{From: from, To: to}
+}
+
+// 跳过出错的语句 depth和parens是语句开始之前的花括号和圆括号层数
+// 停在下面的位置，语句中成对的花括号整体跳过:
+//   - 不在语句自己的圆括号中的分号
+//   - 外层的右花括号之前 右花括号留给块语句
+//   - let return while for break continue之前
+//   - 文件末尾之前
+//
+// 出错的位置就是外层的右花括号时不再移动
+func (p *Parser) synchronize(depth, parens int) {
+	for p.braceDepth >= depth && !p.peekTokenIs(token.EOF) {
+		if p.braceDepth == depth {
+			if p.curTokenIs(token.SEMICOLON) && p.parenDepth <= parens {
+				return
+			}
+			if p.peekStartsStatement() {
+				return
+			}
+			if p.peekTokenIs(token.RBRACE) && depth > 0 {
+				return
+			}
+		}
+		p.nextToken()
+	}
+}
+
+// 下一个词法单元是否是语句开头的关键字
+func (p *Parser) peekStartsStatement() bool {
+	switch p.peekToken.Type {
+	case token.LET, token.RETURN, token.WHILE, token.FOR, token.BREAK, token.CONTINUE:
+		return true
+	}
+	return false
+}
+
+// 跳过语句末尾可选的分号
+// 出错之后不再前进，出错的位置可能是右花括号，要留给错误恢复处理
+func (p *Parser) skipSemicolon() {
+	if !p.recovering && p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+}
+
+// endregion
+
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
 	case token.LET:
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.WHILE:
+		return p.parseWhileStatement()
+	case token.FOR:
+		return p.parseForStatement()
+	case token.BREAK:
+		stmt := &ast.BreakStatement{Token: p.curToken}
+		p.skipSemicolon()
+		return stmt
+	case token.CONTINUE:
+		stmt := &ast.ContinueStatement{Token: p.curToken}
+		p.skipSemicolon()
+		return stmt
 	default:
 		// 如果是其他情况，按照表达式语句处理
 		return p.parseExpressionStatement()
@@ -151,6 +281,16 @@ func (p *Parser) parseStatement() ast.Statement {
 }
 
 func (p *Parser) parseLetStatement() *ast.LetStatement {
+	stmt := p.parseLetClause()
+
+	// 分号可选
+	p.skipSemicolon()
+
+	return stmt
+}
+
+// 解析不带分号的let语句 for语句的头部中分号是分隔符
+func (p *Parser) parseLetClause() *ast.LetStatement {
 	stmt := &ast.LetStatement{Token: p.curToken}
 
 	// 解析标识符
@@ -169,8 +309,9 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
 	stmt.Value = p.parseExpression(LOWEST)
 
-	for !p.curTokenIs(token.SEMICOLON) {
-		p.nextToken()
+	// 记录函数名，编译器用它来支持函数内部的递归调用
+	if fn, ok := stmt.Value.(*ast.FnExpression); ok {
+		fn.Name = stmt.Name.Value
 	}
 
 	return stmt
@@ -192,9 +333,8 @@ func (p *Parser) peekTokenIs(t token.TokenType) bool { return p.peekToken.Type =
 func (p *Parser) curTokenIs(t token.TokenType) bool  { return p.curToken.Type == t }
 
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
+	p.addError(p.peekToken.Pos, "expected next token to be %s, got %s instead",
 		t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
 }
 
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
@@ -202,13 +342,84 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 
 	p.nextToken()
 
-	// TODO: 跳过对表达式的处理，直接到分号
 	stmt.ReturnValue = p.parseExpression(LOWEST)
 
-	for !p.curTokenIs(token.SEMICOLON) {
+	// 分号可选
+	p.skipSemicolon()
+
+	return stmt
+}
+
+func (p *Parser) parseWhileStatement() ast.Statement {
+	stmt := &ast.WhileStatement{Token: p.curToken}
+
+	// while 后应该是左括号
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+
+	p.skipSemicolon()
+	return stmt
+}
+
+// for (Init; Condition; Update) { Body } 括号中的三个部分都可以为空
+func (p *Parser) parseForStatement() ast.Statement {
+	stmt := &ast.ForStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	if !p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
+		stmt.Init = p.parseForClause()
+	}
+	if !p.expectPeek(token.SEMICOLON) {
+		return nil
 	}
 
+	if !p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+		stmt.Condition = p.parseExpression(LOWEST)
+	}
+	if !p.expectPeek(token.SEMICOLON) {
+		return nil
+	}
+
+	if !p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		stmt.Update = p.parseForClause()
+	}
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+
+	p.skipSemicolon()
+	return stmt
+}
+
+// for语句头部的Init或Update let语句或表达式语句，都不带分号
+func (p *Parser) parseForClause() ast.Statement {
+	if p.curTokenIs(token.LET) {
+		return p.parseLetClause()
+	}
+	stmt := &ast.ExpressionStatement{Token: p.curToken}
+	stmt.Expression = p.parseExpression(LOWEST)
 	return stmt
 }
 
@@ -225,9 +436,7 @@ func (p *Parser) parseExpressionStatement() ast.Statement {
 	stmt.Expression = p.parseExpression(LOWEST)
 
 	// 表达式分号可选
-	if p.peekTokenIs(token.SEMICOLON) {
-		p.nextToken()
-	}
+	p.skipSemicolon()
 	return stmt
 
 }
@@ -259,8 +468,28 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 }
 
 func (p *Parser) noPreFixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.addError(p.curToken.Pos, "no prefix parse function for %s found", t)
+}
+
+// 词法分析器无法识别的字符 报告错误
+func (p *Parser) parseIllegal() ast.Expression {
+	lit := p.curToken.Literal
+	switch {
+	case strings.HasPrefix(lit, "/*"):
+		p.addError(p.curToken.Pos, "unterminated block comment")
+	case strings.HasPrefix(lit, `"`), strings.HasPrefix(lit, "}"):
+		// 以 } 开头的是插值之后没有结束的模板字符串
+		p.addError(p.curToken.Pos, "unterminated string literal")
+	case strings.HasPrefix(lit, "`"):
+		p.addError(p.curToken.Pos, "unterminated raw string literal")
+	case strings.HasPrefix(lit, `\`):
+		p.addError(p.curToken.Pos, "invalid escape sequence %q", lit)
+	case !utf8.ValidString(lit):
+		p.addError(p.curToken.Pos, "invalid UTF-8 encoding: byte 0x%02x", lit[0])
+	default:
+		p.addError(p.curToken.Pos, "illegal character %q", lit)
+	}
+	return nil
 }
 
 // 解析标识符
@@ -275,11 +504,62 @@ func (p *Parser) parseIdentifier() ast.Expression {
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curToken}
 
-	// 将字符串转化为int64保存
+	// 将字符串转化为int64保存 base为0时按前缀识别进制并允许_分隔
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.numberError(p.curToken, "integer", err)
+		return nil
+	}
+
+	lit.Value = value
+	return lit
+}
+
+// 报告数字字面量的错误 区分超出范围和格式错误
+func (p *Parser) numberError(tok token.Token, kind string, err error) {
+	if errors.Is(err, strconv.ErrRange) {
+		p.addError(tok.Pos, "%s literal %s out of range", kind, tok.Literal)
+	} else {
+		p.addError(tok.Pos, "invalid %s literal %q", kind, tok.Literal)
+	}
+}
+
+// 负号后紧跟的整数字面量只有带上负号才放得进int64时(如-9223372036854775808)
+// 合并成一个负的整数字面量，否则返回nil按普通前缀表达式处理
+func (p *Parser) parseNegativeIntegerLiteral() ast.Expression {
+	if !p.curTokenIs(token.MINUS) || !p.peekTokenIs(token.INT) {
+		return nil
+	}
+	_, err := strconv.ParseInt(p.peekToken.Literal, 0, 64)
+	if !errors.Is(err, strconv.ErrRange) {
+		return nil
+	}
+	value, err := strconv.ParseInt("-"+p.peekToken.Literal, 0, 64)
+	if err != nil || value != math.MinInt64 {
+		return nil
+	}
+
+	minus := p.curToken
+	p.nextToken()
+	return &ast.IntegerLiteral{
+		Token: token.Token{
+			Type:    token.INT,
+			Literal: "-" + p.curToken.Literal,
+			Pos:     minus.Pos,
+			End:     p.curToken.End,
+			Leading: minus.Leading,
+		},
+		Value: value,
+	}
+}
+
+// 解析浮点数字面量
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		p.numberError(p.curToken, "float", err)
 		return nil
 	}
 
@@ -288,6 +568,10 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 }
 
 func (p *Parser) parsePrefixExpression() ast.Expression {
+	if lit := p.parseNegativeIntegerLiteral(); lit != nil {
+		return lit
+	}
+
 	expression := &ast.PrefixExpression{
 		Token:    p.curToken,
 		Operator: p.curToken.Literal,
@@ -374,16 +658,27 @@ func (p *Parser) parseIfExpression() ast.Expression {
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
+	// 包括这个块的左花括号在内的层数
+	depth := p.braceDepth
 	// 跳过左花括号
 	p.nextToken()
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
-		stmt := p.parseStatement()
-		if stmt != nil {
-			block.Statements = append(block.Statements, stmt)
+		stmt := p.parseStatementWithRecovery()
+		block.Statements = append(block.Statements, stmt)
+		if p.curTokenIs(token.RBRACE) && p.braceDepth < depth {
+			// 出错的语句停在了这个块的右花括号上
+			break
 		}
 		p.nextToken()
 	}
+
+	if !p.curTokenIs(token.RBRACE) {
+		p.addError(p.curToken.Pos, "expected } to close block opened at %d:%d, got %s instead",
+			block.Token.Pos.Line, block.Token.Pos.Column, p.curToken.Type)
+		return block
+	}
+	block.RBrace = p.curToken
 	return block
 }
 
@@ -446,6 +741,9 @@ func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 		Function: function,
 	}
 	expression.Arguments = p.parseExpressionList(token.RPAREN)
+	if p.curTokenIs(token.RPAREN) {
+		expression.RParen = p.curToken
+	}
 	return expression
 }
 
@@ -453,10 +751,49 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+// 解析模板字符串 当前词法单元是TEMPLATE_HEAD
+func (p *Parser) parseTemplateLiteral() ast.Expression {
+	template := &ast.TemplateLiteral{
+		Token:   p.curToken,
+		Strings: []string{p.curToken.Literal},
+	}
+
+	for {
+		p.nextToken()
+		if p.curTokenIs(token.TEMPLATE_MIDDLE) || p.curTokenIs(token.TEMPLATE_TAIL) {
+			p.addError(p.curToken.Pos, "empty interpolation in template string")
+			return nil
+		}
+		template.Values = append(template.Values, p.parseExpression(LOWEST))
+
+		switch {
+		case p.peekTokenIs(token.TEMPLATE_MIDDLE):
+			p.nextToken()
+			template.Strings = append(template.Strings, p.curToken.Literal)
+		case p.peekTokenIs(token.TEMPLATE_TAIL):
+			p.nextToken()
+			template.Strings = append(template.Strings, p.curToken.Literal)
+			template.Tail = p.curToken
+			return template
+		case p.peekTokenIs(token.ILLEGAL):
+			// 模板字符串没有结束 由parseIllegal报告具体原因
+			p.nextToken()
+			p.parseIllegal()
+			return nil
+		default:
+			p.peekError(token.TEMPLATE_TAIL)
+			return nil
+		}
+	}
+}
+
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}
 
 	array.Elements = p.parseExpressionList(token.RBRACKET)
+	if p.curTokenIs(token.RBRACKET) {
+		array.RBracket = p.curToken
+	}
 
 	return array
 }
@@ -494,6 +831,7 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
 	}
+	exp.RBracket = p.curToken
 	return exp
 }
 
@@ -522,6 +860,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		return nil
 	}
 	p.nextToken()
+	hash.RBrace = p.curToken
 
 	return hash
 }
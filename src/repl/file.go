@@ -0,0 +1,198 @@
+package repl
+
+import (
+	"MyCompiler/src/bytecode"
+	"MyCompiler/src/compiler"
+	"MyCompiler/src/disasm"
+	"MyCompiler/src/lexer"
+	"MyCompiler/src/parser"
+	"MyCompiler/src/vm"
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BuildStart 编译源文件并写出字节码文件
+// 用法: liu build file.mk [-o file.mkc]
+func BuildStart(args []string, out io.Writer) {
+	var input, output string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-o" {
+			if i+1 >= len(args) {
+				fmt.Fprintln(out, "build: -o requires an output file")
+				return
+			}
+			output = args[i+1]
+			i++
+			continue
+		}
+		if input != "" {
+			fmt.Fprintln(out, "build: only one source file is allowed")
+			return
+		}
+		input = args[i]
+	}
+	if input == "" {
+		fmt.Fprintln(out, "usage: liu build file.mk [-o file.mkc]")
+		return
+	}
+	if output == "" {
+		// 默认把扩展名换成.mkc
+		output = strings.TrimSuffix(input, filepath.Ext(input)) + ".mkc"
+	}
+
+	source, err := os.Open(input)
+	if err != nil {
+		fmt.Fprintf(out, "build: %s\n", err)
+		return
+	}
+	defer source.Close()
+
+	bc, err := compileSource(input, source)
+	if err != nil {
+		fmt.Fprintf(out, "%s: %s\n", input, err)
+		return
+	}
+
+	data, err := bytecode.Encode(bc, &bytecode.DebugInfo{SourceName: filepath.Base(input)})
+	if err != nil {
+		fmt.Fprintf(out, "build: %s\n", err)
+		return
+	}
+
+	err = ioutil.WriteFile(output, data, 0644)
+	if err != nil {
+		fmt.Fprintf(out, "build: %s\n", err)
+	}
+}
+
+// RunStart 加载字节码文件并在虚拟机中执行
+// 用法: liu run file.mkc
+func RunStart(args []string, out io.Writer) {
+	if len(args) != 1 {
+		fmt.Fprintln(out, "usage: liu run file.mkc")
+		return
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		fmt.Fprintf(out, "run: %s\n", err)
+		return
+	}
+	defer f.Close()
+
+	bc, _, err := bytecode.Read(f)
+	if err != nil {
+		fmt.Fprintf(out, "run: %s: %s\n", args[0], err)
+		return
+	}
+	// 文件可能是损坏的或者手工构造的 执行之前检查指令
+	err = bytecode.Verify(bc)
+	if err != nil {
+		fmt.Fprintf(out, "run: %s: invalid bytecode: %s\n", args[0], err)
+		return
+	}
+
+	machine := vm.New(bc)
+	err = machine.Run()
+	if err != nil {
+		fmt.Fprintf(out, "Executing bytecode failed:\n %s\n", err)
+	}
+}
+
+// DisasmStart 反汇编源文件或字节码文件
+// 用法: liu disasm [--format=text|dot] file.mk|file.mkc
+// dot格式输出Graphviz的控制流图
+func DisasmStart(args []string, out io.Writer) {
+	const usage = "usage: liu disasm [--format=text|dot] file.mk|file.mkc"
+	format := formatText
+	var paths []string
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--format="):
+			var err error
+			format, err = parseFormat("disasm", arg, []string{formatText, formatDot})
+			if err != nil {
+				fmt.Fprintln(out, err)
+				return
+			}
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprintf(out, "disasm: unknown flag %s\n", arg)
+			return
+		default:
+			paths = append(paths, arg)
+		}
+	}
+	if len(paths) != 1 {
+		fmt.Fprintln(out, usage)
+		return
+	}
+
+	bc, err := loadBytecode(paths[0])
+	if err != nil {
+		fmt.Fprintf(out, "disasm: %s: %s\n", paths[0], err)
+		return
+	}
+
+	if format == formatDot {
+		if err := disasm.Graph(out, bc); err != nil {
+			fmt.Fprintf(out, "disasm: %s: %s\n", paths[0], err)
+		}
+		return
+	}
+	disasm.Disassemble(out, bc)
+}
+
+// region 帮助函数
+
+// 加载字节码 以Magic开头的文件按字节码文件解码并检查，否则当作源代码编译
+func loadBytecode(path string) (*compiler.ByteCode, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	// 文件比Magic短时Peek返回错误 这时一定不是字节码文件
+	header, _ := r.Peek(len(bytecode.Magic))
+	if string(header) == bytecode.Magic {
+		bc, _, err := bytecode.Read(r)
+		if err != nil {
+			return nil, err
+		}
+		// 文件可能是损坏的或者手工构造的 反汇编之前先检查指令
+		if err := bytecode.Verify(bc); err != nil {
+			return nil, fmt.Errorf("invalid bytecode: %s", err)
+		}
+		return bc, nil
+	}
+	return compileSource(path, r)
+}
+
+// 把源代码编译成字节码 语法错误会合并成一个error返回
+// 源代码边读边分析，不会一次读入整个文件
+func compileSource(filename string, source io.Reader) (*compiler.ByteCode, error) {
+	l := lexer.NewReaderWithFilename(filename, source)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if err := l.Err(); err != nil {
+		return nil, err
+	}
+	if len(p.Error()) != 0 {
+		return nil, fmt.Errorf("syntax errors:\n\t%s", strings.Join(p.Error(), "\n\t"))
+	}
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		return nil, fmt.Errorf("compilation failed: %s", err)
+	}
+	return comp.Bytecode(), nil
+}
+
+// endregion
@@ -0,0 +1,124 @@
+package repl
+
+import (
+	"MyCompiler/src/format"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// 格式化时处理的源文件扩展名
+const sourceExt = ".mk"
+
+// fmt命令的选项
+type formatOptions struct {
+	list  bool // -l 列出格式不规范的文件
+	write bool // -w 把结果写回文件
+}
+
+// FormatStart 格式化源文件
+// 用法: liu fmt [-l] [-w] [file.mk|dir ...]
+// 没有指定文件时格式化标准输入；指定目录时处理其中所有的.mk文件
+// 不带选项时把格式化的结果写到out
+func FormatStart(args []string, in io.Reader, out io.Writer) {
+	var opts formatOptions
+	var paths []string
+	for _, arg := range args {
+		switch arg {
+		case "-l":
+			opts.list = true
+		case "-w":
+			opts.write = true
+		default:
+			if len(arg) > 1 && arg[0] == '-' {
+				fmt.Fprintf(out, "fmt: unknown flag %s\n", arg)
+				return
+			}
+			paths = append(paths, arg)
+		}
+	}
+
+	if len(paths) == 0 {
+		if opts.write {
+			fmt.Fprintln(out, "fmt: cannot use -w with standard input")
+			return
+		}
+		src, err := ioutil.ReadAll(in)
+		if err != nil {
+			fmt.Fprintf(out, "fmt: %s\n", err)
+			return
+		}
+		formatSource("", src, opts, out)
+		return
+	}
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintf(out, "fmt: %s\n", err)
+			continue
+		}
+		if !info.IsDir() {
+			formatFile(path, opts, out)
+			continue
+		}
+		err = filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && filepath.Ext(path) == sourceExt {
+				formatFile(path, opts, out)
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(out, "fmt: %s\n", err)
+		}
+	}
+}
+
+func formatFile(path string, opts formatOptions, out io.Writer) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(out, "fmt: %s\n", err)
+		return
+	}
+	result, ok := formatSource(path, src, opts, out)
+	if !ok || !opts.write || bytes.Equal(src, result) {
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		fmt.Fprintf(out, "fmt: %s\n", err)
+		return
+	}
+	err = ioutil.WriteFile(path, result, info.Mode().Perm())
+	if err != nil {
+		fmt.Fprintf(out, "fmt: %s\n", err)
+	}
+}
+
+// 格式化一段源码 按选项输出文件名或者结果 有语法错误时输出错误并返回false
+func formatSource(filename string, src []byte, opts formatOptions, out io.Writer) ([]byte, bool) {
+	result, err := format.Source(filename, src)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return nil, false
+	}
+
+	if opts.list && !bytes.Equal(src, result) {
+		name := filename
+		if name == "" {
+			name = "<standard input>"
+		}
+		fmt.Fprintln(out, name)
+	}
+	if !opts.list && !opts.write {
+		out.Write(result)
+	}
+	return result, true
+}
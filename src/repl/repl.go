@@ -3,6 +3,7 @@ package repl
 import (
 	"MyCompiler/src/compiler"
 	"MyCompiler/src/lexer"
+	"MyCompiler/src/object"
 	"MyCompiler/src/parser"
 	"MyCompiler/src/token"
 	"MyCompiler/src/vm"
@@ -10,6 +11,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 )
 
 const PROMPT = ">> "
@@ -26,16 +28,28 @@ Usage:
 
 The commands are:
 
-	lexer/lex       show the lexer structure
-	parser/ast      show the ast structure
+	lexer/lex       show the lexer structure (lex [--format=text|json] [file.mk])
+	parser/ast      show the ast structure (ast [--format=text|json|dot] [file.mk])
+	build           compile a source file to bytecode (build file.mk -o file.mkc)
+	run             run a compiled bytecode file (run file.mkc)
+	disasm          disassemble a source or bytecode file (disasm [--format=text|dot] file.mk|file.mkc)
+	fmt             format source files (fmt [-l] [-w] [file.mk|dir ...])
 	[default]       evaluate the expression
 	
 `
 	switch os.Args[1] {
 	case "lexer", "lex":
-		LexerStart(in, out)
+		LexerStart(os.Args[2:], in, out)
 	case "parser", "ast":
-		ParserStart(in, out)
+		ParserStart(os.Args[2:], in, out)
+	case "build":
+		BuildStart(os.Args[2:], out)
+	case "run":
+		RunStart(os.Args[2:], out)
+	case "disasm":
+		DisasmStart(os.Args[2:], out)
+	case "fmt":
+		FormatStart(os.Args[2:], in, out)
 	case "help":
 		fmt.Println(helpMsg)
 	default:
@@ -43,17 +57,29 @@ The commands are:
 	}
 }
 
-func LexerStart(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
+// LexerStart 输出词法单元
+// 不带参数时逐行交互；带--format=json或文件名时分析整个输入
+func LexerStart(args []string, in io.Reader, out io.Writer) {
+	format, source, err := parseSourceArgs("lex", []string{formatText, formatJSON}, args, in)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return
+	}
+	if source != nil {
+		defer source.close()
+		lexSource(format, source, out)
+		return
+	}
+
+	reader := bufio.NewReader(in)
 
 	for {
 		fmt.Fprintf(out, PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		line, ok := readLine(reader)
+		if !ok {
 			return
 		}
 
-		line := scanner.Text()
 		l := lexer.New(line)
 
 		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
@@ -62,17 +88,29 @@ func LexerStart(in io.Reader, out io.Writer) {
 	}
 }
 
-func ParserStart(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
+// ParserStart 输出语法树
+// 不带参数时逐行交互；带--format=json|dot或文件名时分析整个输入
+func ParserStart(args []string, in io.Reader, out io.Writer) {
+	format, source, err := parseSourceArgs("ast", []string{formatText, formatJSON, formatDot}, args, in)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return
+	}
+	if source != nil {
+		defer source.close()
+		parseSource(format, source, out)
+		return
+	}
+
+	reader := bufio.NewReader(in)
 
 	for {
 		fmt.Fprintf(out, PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		line, ok := readLine(reader)
+		if !ok {
 			return
 		}
 
-		line := scanner.Text()
 		l := lexer.New(line)
 		p := parser.New(l)
 		program := p.ParseProgram()
@@ -86,17 +124,21 @@ func ParserStart(in io.Reader, out io.Writer) {
 }
 
 func EvaluateStart(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
-	// 创建变量表
-	// env := object.NewEnvironment()
+	reader := bufio.NewReader(in)
+	// 常量池 符号表 全局变量在每行输入之间共享
+	constants := []object.Object{}
+	globals := make([]object.Object, vm.GlobalsSize)
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
 	for {
 		fmt.Fprintf(out, PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		line, ok := readLine(reader)
+		if !ok {
 			return
 		}
 
-		line := scanner.Text()
 		l := lexer.New(line)
 		p := parser.New(l)
 		program := p.ParseProgram()
@@ -105,30 +147,50 @@ func EvaluateStart(in io.Reader, out io.Writer) {
 			continue
 		}
 		// 换成编译 + 解释器模式
-		comp := compiler.New()
+		// 这一行编译失败时恢复符号表，否则其中定义的变量没有值
+		// 运行失败时不恢复: 已经执行的let保留它们的值；没有执行到的let的变量没有值，使用时虚拟机会报错
+		snapshot := symbolTable.Clone()
+		comp := compiler.NewWithState(symbolTable, constants)
 		err := comp.Compile(program)
 		if err != nil {
+			symbolTable = snapshot
 			fmt.Fprintf(out, "Compilation failed:\n %s\n", err)
 			continue
 		}
+		bytecode := comp.Bytecode()
+		constants = bytecode.Constants
 		// 运行虚拟机
-		machine := vm.New(comp.Bytecode())
+		machine := vm.NewWithGlobals(bytecode, globals)
 		err = machine.Run()
 		if err != nil {
 			fmt.Fprintf(out, "Executing bytecode failed:\n %s\n", err)
 			continue
 		}
-		// 把栈顶取出来输出
-		stackTop := machine.StackTop()
+		// 表达式语句的结果会被弹出栈，取最后弹出的对象输出
+		lastPopped := machine.LastPoppedStackElem()
+		if lastPopped == nil {
+			continue
+		}
 
 		// evaluated := evaluator.Eval(program, env)
-		io.WriteString(out, stackTop.Inspect())
+		io.WriteString(out, lastPopped.Inspect())
 		io.WriteString(out, "\n")
 	}
 }
 
 // region 帮助函数
 
+// 读取一行输入 不包括结尾的换行 行的长度没有限制
+// 最后一行没有换行时也会返回；没有更多输入时返回false
+func readLine(reader *bufio.Reader) (string, bool) {
+	line, err := reader.ReadString('\n')
+	if err != nil && line == "" {
+		return "", false
+	}
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r"), true
+}
+
 func printParserErrors(out io.Writer, errors []string) {
 	for _, msg := range errors {
 		io.WriteString(out, "\t"+msg+"\n")
@@ -0,0 +1,146 @@
+package repl
+
+import (
+	"MyCompiler/src/astdot"
+	"MyCompiler/src/astjson"
+	"MyCompiler/src/lexer"
+	"MyCompiler/src/parser"
+	"MyCompiler/src/token"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// 输出格式
+const (
+	formatText = "text"
+	formatJSON = "json"
+	formatDot  = "dot"
+)
+
+// 解析--format=参数 格式必须是formats中的一个
+func parseFormat(command, arg string, formats []string) (string, error) {
+	format := strings.TrimPrefix(arg, "--format=")
+	for _, f := range formats {
+		if format == f {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("%s: unknown format %q (want %s)", command, format, strings.Join(formats, " or "))
+}
+
+// 解析lex和ast命令的参数 [--format=...] [file.mk] formats是命令支持的格式
+// 指定了文件时从文件读取；只指定了格式时从in读取整个输入
+// 两者都没有时返回nil的source，表示逐行交互
+func parseSourceArgs(command string, formats []string, args []string, in io.Reader) (format string, source *sourceInput, err error) {
+	format = formatText
+	formatSet := false
+	filename := ""
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--format="):
+			format, err = parseFormat(command, arg, formats)
+			if err != nil {
+				return "", nil, err
+			}
+			formatSet = true
+		case strings.HasPrefix(arg, "-"):
+			return "", nil, fmt.Errorf("%s: unknown flag %s", command, arg)
+		case filename != "":
+			return "", nil, fmt.Errorf("%s: only one source file is allowed", command)
+		default:
+			filename = arg
+		}
+	}
+
+	if filename != "" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: %s", command, err)
+		}
+		return format, &sourceInput{name: filename, r: f, close: f.Close}, nil
+	}
+	if formatSet {
+		return format, &sourceInput{r: in, close: func() error { return nil }}, nil
+	}
+	return format, nil, nil
+}
+
+// 要分析的整个输入
+type sourceInput struct {
+	name  string // 文件名 标准输入时为空
+	r     io.Reader
+	close func() error
+}
+
+// 输出整个输入的词法单元 最后的EOF也会输出，用来给出输入结束的位置
+// 文本格式边分析边输出；JSON格式要输出一个完整的数组，先收集全部词法单元
+func lexSource(format string, source *sourceInput, out io.Writer) {
+	l := lexer.NewReaderWithFilename(source.name, source.r)
+	if format != formatJSON {
+		for {
+			tok := l.NextToken()
+			if err := l.Err(); err != nil {
+				fmt.Fprintf(out, "lex: %s\n", err)
+				return
+			}
+			fmt.Fprintf(out, "%+v\n", tok)
+			if tok.Type == token.EOF {
+				return
+			}
+		}
+	}
+
+	var tokens []token.Token
+	for {
+		tok := l.NextToken()
+		tokens = append(tokens, tok)
+		if tok.Type == token.EOF {
+			break
+		}
+	}
+	if err := l.Err(); err != nil {
+		fmt.Fprintf(out, "lex: %s\n", err)
+		return
+	}
+
+	data, err := astjson.EncodeTokens(tokens)
+	if err != nil {
+		fmt.Fprintf(out, "lex: %s\n", err)
+		return
+	}
+	out.Write(data)
+}
+
+// 输出整个输入的语法树 有语法错误时只输出错误
+func parseSource(format string, source *sourceInput, out io.Writer) {
+	l := lexer.NewReaderWithFilename(source.name, source.r)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if err := l.Err(); err != nil {
+		fmt.Fprintf(out, "ast: %s\n", err)
+		return
+	}
+	if len(p.Error()) != 0 {
+		printParserErrors(out, p.Error())
+		return
+	}
+
+	switch format {
+	case formatJSON:
+		err := astjson.Write(out, program)
+		if err != nil {
+			fmt.Fprintf(out, "ast: %s\n", err)
+		}
+		return
+	case formatDot:
+		err := astdot.Write(out, program)
+		if err != nil {
+			fmt.Fprintf(out, "ast: %s\n", err)
+		}
+		return
+	}
+	io.WriteString(out, program.String())
+	io.WriteString(out, "\n")
+}
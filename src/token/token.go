@@ -1,5 +1,7 @@
 package token
 
+import "fmt"
+
 // TokenType Token的类型
 // 使用string是为了方便调试，如果使用int之类的可读性会变差，但是性能会更高
 type TokenType string
@@ -7,9 +9,41 @@ type TokenType string
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position  // 词法单元第一个字符的位置
+	End     Position  // 词法单元之后第一个字符的位置
+	Leading []Comment // 词法单元之前的注释 只在lexer.KeepComments模式下记录
+}
+
+// Comment 一段注释 Text包括 // 或 /* */ 本身
+type Comment struct {
+	Text string
+	Pos  Position
+	End  Position
+}
+
+// Position 源码中的位置
+type Position struct {
+	Filename string // 文件名 没有文件时为空
+	Offset   int    // 字节偏移 从0开始
+	Line     int    // 行号 从1开始
+	Column   int    // 列号 从1开始
+}
+
+// IsValid 行号为0的位置表示未知位置
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+// String 格式化为 file:line:col
+func (p Position) String() string {
+	filename := p.Filename
+	if filename == "" {
+		filename = "<input>"
+	}
+	if !p.IsValid() {
+		return filename
+	}
+	return fmt.Sprintf("%s:%d:%d", filename, p.Line, p.Column)
 }
 
-// TODO: 支持字符串字面量 支持浮点数
 // 所有的类型
 const (
 	// 特殊类型
@@ -19,8 +53,15 @@ const (
 	// 标识符 字面量
 	IDENT  = "IDENT"
 	INT    = "INT"
+	FLOAT  = "FLOAT"
 	STRING = "STRING"
 
+	// 模板字符串 "a ${x} b ${y} c" 被切分成
+	// TEMPLATE_HEAD("a ") x TEMPLATE_MIDDLE(" b ") y TEMPLATE_TAIL(" c")
+	TEMPLATE_HEAD   = "TEMPLATE_HEAD"
+	TEMPLATE_MIDDLE = "TEMPLATE_MIDDLE"
+	TEMPLATE_TAIL   = "TEMPLATE_TAIL"
+
 	// 运算符
 	ASSIGN   = "="
 	PLUS     = "+"
@@ -33,6 +74,11 @@ const (
 
 	EQ     = "=="
 	NOT_EQ = "!="
+	LT_EQ  = "<="
+	GT_EQ  = ">="
+
+	AND = "&&"
+	OR  = "||"
 
 	// 分隔符
 	COMMA     = ","
@@ -55,17 +101,25 @@ const (
 	RETURN   = "return"
 	TRUE     = "true"
 	FALSE    = "false"
+	WHILE    = "while"
+	FOR      = "for"
+	BREAK    = "break"
+	CONTINUE = "continue"
 )
 
 // 所有的关键字
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
-	"true":   TRUE,
-	"false":  FALSE,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"true":     TRUE,
+	"false":    FALSE,
+	"while":    WHILE,
+	"for":      FOR,
+	"break":    BREAK,
+	"continue": CONTINUE,
 }
 
 // 关键字匹配
@@ -0,0 +1,22 @@
+package vm
+
+import (
+	"MyCompiler/src/code"
+	"MyCompiler/src/object"
+)
+
+// Frame 调用帧 记录一次函数调用的执行状态
+type Frame struct {
+	cl          *object.Closure // 正在执行的闭包
+	ip          int             // 该帧的指令指针
+	basePointer int             // 调用前的栈指针 局部变量从这里开始存放
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
+}
+
+// Instructions 返回帧中函数的指令
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
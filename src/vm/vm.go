@@ -5,28 +5,62 @@ import (
 	"MyCompiler/src/compiler"
 	"MyCompiler/src/object"
 	"fmt"
+	"strings"
 )
 
 // 栈大小
 const StackSize = 2048
 
+// 调用帧的数量上限
+const MaxFrames = 1024
+
+// 全局变量的数量上限 (OpSetGlobal的操作数有两字节宽)
+const GlobalsSize = 65536
+
+// 布尔值和空值都使用单例
+var True = &object.Boolean{Value: true}
+var False = &object.Boolean{Value: false}
+var Null = &object.Null{}
+
 // VM 虚拟机结构体
 type VM struct {
-	constants    []object.Object   // 常量池
-	instructions code.Instructions // 指令集
-	stack        []object.Object   // 虚拟机栈
-	sp           int               // 栈指针
+	constants []object.Object // 常量池
+	stack     []object.Object // 虚拟机栈
+	sp        int             // 栈指针 始终指向下一个空闲位置，栈顶为stack[sp-1]
+
+	globals []object.Object // 全局变量
+
+	frames      []*Frame // 调用帧
+	framesIndex int      // 下一个空闲帧的位置
 }
 
 func New(bytecode *compiler.ByteCode) *VM {
+	// 顶层的指令也当作一个函数，放在主帧中执行
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
+
+	frames := make([]*Frame, MaxFrames)
+	frames[0] = mainFrame
+
 	return &VM{
-		instructions: bytecode.Instructions,
-		constants:    bytecode.Constants,
-		stack:        make([]object.Object, StackSize),
-		sp:           0,
+		constants:   bytecode.Constants,
+		stack:       make([]object.Object, StackSize),
+		sp:          0,
+		globals:     make([]object.Object, GlobalsSize),
+		frames:      frames,
+		framesIndex: 1,
 	}
 }
 
+// NewWithGlobals 使用已有的全局变量创建虚拟机
+// REPL中每行输入都会新建虚拟机，需要沿用之前的全局变量
+func NewWithGlobals(bytecode *compiler.ByteCode, s []object.Object) *VM {
+	vm := New(bytecode)
+	vm.globals = s
+	return vm
+}
+
 // 返回栈顶对象
 func (vm *VM) StackTop() object.Object {
 	if vm.sp == 0 {
@@ -35,33 +69,634 @@ func (vm *VM) StackTop() object.Object {
 	return vm.stack[vm.sp-1]
 }
 
+// LastPoppedStackElem 返回最近一次弹出栈的对象
+// 表达式语句执行完后会被OpPop弹出，其值仍留在sp指向的位置上
+func (vm *VM) LastPoppedStackElem() object.Object {
+	return vm.stack[vm.sp]
+}
+
 func (vm *VM) Run() error {
-	for ip := 0; ip < len(vm.instructions); ip++ {
-		op := code.Opcode(vm.instructions[ip])
+	var ip int
+	var ins code.Instructions
+	var op code.Opcode
+
+	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		vm.currentFrame().ip++
+
+		ip = vm.currentFrame().ip
+		ins = vm.currentFrame().Instructions()
+		op = code.Opcode(ins[ip])
+
+		// OpWide前缀: 读取真正的操作码，它的操作数宽度加倍
+		wide := false
+		if op == code.OpWide {
+			wide = true
+			vm.currentFrame().ip++
+			ip = vm.currentFrame().ip
+			op = code.Opcode(ins[ip])
+		}
+
 		// 分别处理每种操作码
 		switch op {
-		case code.OpAdd:
-			// 弹出操作数栈的头两个，相加后压入栈中
-			right := vm.pop()
-			left := vm.pop()
-			leftVal := left.(*object.Integer).Value
-			rightVal := right.(*object.Integer).Value
-			result := leftVal + rightVal
-			vm.push(&object.Integer{Value: result})
 		case code.OpConstant:
 			// 获取常量索引
-			constIndex := code.ReadUint16(vm.instructions[ip+1:])
-			ip += 2
+			constIndex := vm.readOperand(2, wide)
 			// 找到常量，并压入栈中
 			err := vm.push(vm.constants[constIndex])
 			if err != nil {
 				return err
 			}
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
+			err := vm.executeBinaryOperation(op)
+			if err != nil {
+				return err
+			}
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanOrEqual:
+			err := vm.executeComparison(op)
+			if err != nil {
+				return err
+			}
+		case code.OpTrue:
+			err := vm.push(True)
+			if err != nil {
+				return err
+			}
+		case code.OpFalse:
+			err := vm.push(False)
+			if err != nil {
+				return err
+			}
+		case code.OpBang:
+			err := vm.executeBangOperator()
+			if err != nil {
+				return err
+			}
+		case code.OpMinus:
+			err := vm.executeMinusOperator()
+			if err != nil {
+				return err
+			}
+		case code.OpPop:
+			vm.pop()
+		case code.OpJump:
+			pos := vm.readOperand(2, wide)
+			// 循环开始会ip++，所以这里要减一
+			vm.currentFrame().ip = pos - 1
+		case code.OpJumpNotTruthy:
+			pos := vm.readOperand(2, wide)
+
+			condition := vm.pop()
+			if !isTruthy(condition) {
+				vm.currentFrame().ip = pos - 1
+			}
+		case code.OpSetGlobal:
+			globalIndex := vm.readOperand(2, wide)
+			if globalIndex >= len(vm.globals) {
+				return fmt.Errorf("global index out of range: %d", globalIndex)
+			}
+
+			vm.globals[globalIndex] = vm.pop()
+		case code.OpGetGlobal:
+			globalIndex := vm.readOperand(2, wide)
+			if globalIndex >= len(vm.globals) {
+				return fmt.Errorf("global index out of range: %d", globalIndex)
+			}
+
+			// 编译失败的输入可能留下没有赋值的全局变量
+			global := vm.globals[globalIndex]
+			if global == nil {
+				return fmt.Errorf("global variable %d is not set", globalIndex)
+			}
+			err := vm.push(global)
+			if err != nil {
+				return err
+			}
+		case code.OpConcat:
+			count := vm.readOperand(2, wide)
+
+			str := vm.concat(vm.sp-count, vm.sp)
+			vm.sp = vm.sp - count
+
+			err := vm.push(str)
+			if err != nil {
+				return err
+			}
+		case code.OpArray:
+			numElements := vm.readOperand(2, wide)
+
+			array := vm.buildArray(vm.sp-numElements, vm.sp)
+			// 把元素从栈上移除
+			vm.sp = vm.sp - numElements
+
+			err := vm.push(array)
+			if err != nil {
+				return err
+			}
+		case code.OpHash:
+			numElements := vm.readOperand(2, wide)
+
+			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
+			if err != nil {
+				return err
+			}
+			vm.sp = vm.sp - numElements
+
+			err = vm.push(hash)
+			if err != nil {
+				return err
+			}
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+
+			err := vm.executeIndexExpression(left, index)
+			if err != nil {
+				return err
+			}
+		case code.OpSetLocal:
+			localIndex := vm.readOperand(1, wide)
+
+			frame := vm.currentFrame()
+			// 局部变量存放在栈上 从basePointer开始
+			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+		case code.OpGetLocal:
+			localIndex := vm.readOperand(1, wide)
+
+			frame := vm.currentFrame()
+			err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
+			if err != nil {
+				return err
+			}
+		case code.OpCall:
+			numArgs := vm.readOperand(1, wide)
+
+			err := vm.executeCall(int(numArgs))
+			if err != nil {
+				return err
+			}
+		case code.OpGetBuiltin:
+			builtinIndex := vm.readOperand(1, wide)
+
+			definition := object.Builtins[builtinIndex]
+			err := vm.push(definition)
+			if err != nil {
+				return err
+			}
+		case code.OpClosure:
+			constIndex := vm.readOperand(2, wide)
+			numFree := vm.readOperand(1, wide)
+
+			err := vm.pushClosure(int(constIndex), int(numFree))
+			if err != nil {
+				return err
+			}
+		case code.OpGetFree:
+			freeIndex := vm.readOperand(1, wide)
+
+			currentClosure := vm.currentFrame().cl
+			err := vm.push(currentClosure.Free[freeIndex])
+			if err != nil {
+				return err
+			}
+		case code.OpCurrentClosure:
+			currentClosure := vm.currentFrame().cl
+			err := vm.push(currentClosure)
+			if err != nil {
+				return err
+			}
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+
+			if vm.framesIndex == 1 {
+				// 顶层的return语句直接结束执行
+				// 返回值刚刚被弹出，仍可以通过LastPoppedStackElem取到
+				return nil
+			}
+
+			frame := vm.popFrame()
+			// 弹出局部变量和函数本身
+			vm.sp = frame.basePointer - 1
+
+			err := vm.push(returnValue)
+			if err != nil {
+				return err
+			}
+		case code.OpReturn:
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			err := vm.push(Null)
+			if err != nil {
+				return err
+			}
+		case code.OpNull:
+			err := vm.push(Null)
+			if err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
+// region 调用帧
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+// 读取当前指令的下一个操作数并让ip跳过它 wide为true时宽度加倍
+func (vm *VM) readOperand(width int, wide bool) int {
+	frame := vm.currentFrame()
+	if wide {
+		width *= 2
+	}
+	operand := code.ReadOperand(frame.Instructions()[frame.ip+1:], width)
+	frame.ip += width
+	return operand
+}
+
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex >= MaxFrames {
+		return fmt.Errorf("frame overflow")
+	}
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+	return nil
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+// 执行函数调用 栈上依次为被调用者和numArgs个参数
+func (vm *VM) executeCall(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return fmt.Errorf("calling non-function")
+	}
+}
+
+// 调用闭包
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
+			cl.Fn.NumParameters, numArgs)
+	}
+
+	// 参数已经在栈上，正好作为前几个局部变量
+	frame := NewFrame(cl, vm.sp-numArgs)
+	err := vm.pushFrame(frame)
+	if err != nil {
+		return err
+	}
+
+	// 为局部变量预留空间
+	if frame.basePointer+cl.Fn.NumLocals >= StackSize {
+		return fmt.Errorf("stack overflow")
+	}
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+
+	return nil
+}
+
+// 调用内置函数
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	args := vm.stack[vm.sp-numArgs : vm.sp]
+
+	result, err := builtin.Call(args...)
+	if err != nil {
+		return err
+	}
+	// 弹出参数和内置函数本身
+	vm.sp = vm.sp - numArgs - 1
+
+	if result == nil {
+		return vm.push(Null)
+	}
+	return vm.push(result)
+}
+
+// 用常量池中的函数和栈顶的numFree个自由变量创建闭包
+func (vm *VM) pushClosure(constIndex int, numFree int) error {
+	constant := vm.constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+
+	free := make([]object.Object, numFree)
+	for i := 0; i < numFree; i++ {
+		free[i] = vm.stack[vm.sp-numFree+i]
+	}
+	vm.sp = vm.sp - numFree
+
+	closure := &object.Closure{Fn: function, Free: free}
+	return vm.push(closure)
+}
+
+// endregion
+
+// region 运算
+
+// 执行二元运算
+func (vm *VM) executeBinaryOperation(op code.Opcode) error {
+	// 弹出操作数栈的头两个，计算后压入栈中
+	right := vm.pop()
+	left := vm.pop()
+
+	leftType := left.Type()
+	rightType := right.Type()
+
+	switch {
+	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
+		return vm.executeBinaryIntegerOperation(op, left, right)
+	case isNumber(left) && isNumber(right):
+		// 至少有一边是浮点数 整数先转成浮点数
+		return vm.executeBinaryFloatOperation(op, left, right)
+	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
+		return vm.executeBinaryStringOperation(op, left, right)
+	default:
+		return fmt.Errorf("unsupported types for binary operation: %s %s", leftType, rightType)
+	}
+}
+
+func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Object) error {
+	// 字符串只支持拼接
+	if op != code.OpAdd {
+		return fmt.Errorf("unknown string operator: %d", op)
+	}
+
+	leftVal := left.(*object.String).Value
+	rightVal := right.(*object.String).Value
+
+	return vm.push(&object.String{Value: leftVal + rightVal})
+}
+
+func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.Object) error {
+	leftVal := left.(*object.Integer).Value
+	rightVal := right.(*object.Integer).Value
+
+	var result int64
+
+	switch op {
+	case code.OpAdd:
+		result = leftVal + rightVal
+	case code.OpSub:
+		result = leftVal - rightVal
+	case code.OpMul:
+		result = leftVal * rightVal
+	case code.OpDiv:
+		if rightVal == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		result = leftVal / rightVal
+	default:
+		return fmt.Errorf("unknown integer operator: %d", op)
+	}
+
+	return vm.push(&object.Integer{Value: result})
+}
+
+func (vm *VM) executeBinaryFloatOperation(op code.Opcode, left, right object.Object) error {
+	leftVal, _ := object.ToFloat(left)
+	rightVal, _ := object.ToFloat(right)
+
+	var result float64
+
+	switch op {
+	case code.OpAdd:
+		result = leftVal + rightVal
+	case code.OpSub:
+		result = leftVal - rightVal
+	case code.OpMul:
+		result = leftVal * rightVal
+	case code.OpDiv:
+		if rightVal == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		result = leftVal / rightVal
+	default:
+		return fmt.Errorf("unknown float operator: %d", op)
+	}
+
+	return vm.push(&object.Float{Value: result})
+}
+
+// 执行比较运算
+func (vm *VM) executeComparison(op code.Opcode) error {
+	right := vm.pop()
+	left := vm.pop()
+
+	switch {
+	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
+		return vm.executeIntegerComparison(op, left, right)
+	case isNumber(left) && isNumber(right):
+		return vm.executeFloatComparison(op, left, right)
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return vm.executeStringComparison(op, left, right)
+	}
+
+	// 其他类型直接比较指针 (布尔值是单例)
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(right == left))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(right != left))
+	default:
+		return fmt.Errorf("unknown operator: %d (%s %s)", op, left.Type(), right.Type())
+	}
+}
+
+func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object) error {
+	leftVal := left.(*object.Integer).Value
+	rightVal := right.(*object.Integer).Value
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(leftVal == rightVal))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(leftVal != rightVal))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(leftVal > rightVal))
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(leftVal >= rightVal))
+	default:
+		return fmt.Errorf("unknown operator: %d", op)
+	}
+}
+
+func (vm *VM) executeFloatComparison(op code.Opcode, left, right object.Object) error {
+	leftVal, _ := object.ToFloat(left)
+	rightVal, _ := object.ToFloat(right)
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(leftVal == rightVal))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(leftVal != rightVal))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(leftVal > rightVal))
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(leftVal >= rightVal))
+	default:
+		return fmt.Errorf("unknown operator: %d", op)
+	}
+}
+
+func (vm *VM) executeStringComparison(op code.Opcode, left, right object.Object) error {
+	leftVal := left.(*object.String).Value
+	rightVal := right.(*object.String).Value
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(leftVal == rightVal))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(leftVal != rightVal))
+	default:
+		return fmt.Errorf("unknown operator: %d (%s %s)", op, left.Type(), right.Type())
+	}
+}
+
+// 执行取反运算
+func (vm *VM) executeBangOperator() error {
+	operand := vm.pop()
+
+	switch operand {
+	case True:
+		return vm.push(False)
+	case False:
+		return vm.push(True)
+	case Null:
+		// null的反是true
+		return vm.push(True)
+	default:
+		// 其他对象的反都是false
+		return vm.push(False)
+	}
+}
+
+// 执行取负运算
+func (vm *VM) executeMinusOperator() error {
+	operand := vm.pop()
+
+	if f, ok := operand.(*object.Float); ok {
+		return vm.push(&object.Float{Value: -f.Value})
+	}
+	if operand.Type() != object.INTEGER_OBJ {
+		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
+	}
+
+	value := operand.(*object.Integer).Value
+	return vm.push(&object.Integer{Value: -value})
+}
+
+// endregion
+
+// region 复合数据类型
+
+// 把栈上[startIndex, endIndex)之间的值按Inspect拼接成字符串
+func (vm *VM) concat(startIndex, endIndex int) object.Object {
+	var out strings.Builder
+	for i := startIndex; i < endIndex; i++ {
+		out.WriteString(vm.stack[i].Inspect())
+	}
+	return &object.String{Value: out.String()}
+}
+
+// 用栈上[startIndex, endIndex)之间的元素构建数组
+func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
+	elements := make([]object.Object, endIndex-startIndex)
+
+	for i := startIndex; i < endIndex; i++ {
+		elements[i-startIndex] = vm.stack[i]
+	}
+
+	return &object.Array{Elements: elements}
+}
+
+// 用栈上[startIndex, endIndex)之间的元素构建哈希 元素按键、值交替排列
+func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
+	hashedPairs := make(map[object.HashKey]object.HashPair)
+
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		pair := object.HashPair{Key: key, Value: value}
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, fmt.Errorf("the key is not hashable, key: %s", key.Type())
+		}
+
+		hashedPairs[hashKey.HashKey()] = pair
+	}
+
+	return &object.Hash{Pairs: hashedPairs}, nil
+}
+
+// 执行索引运算
+func (vm *VM) executeIndexExpression(left, index object.Object) error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeArrayIndex(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeStringIndex(left, index)
+	case left.Type() == object.HASH_OBJ:
+		return vm.executeHashIndex(left, index)
+	default:
+		return fmt.Errorf("index operator not supported: %s", left.Type())
+	}
+}
+
+func (vm *VM) executeArrayIndex(array, index object.Object) error {
+	arrayObject := array.(*object.Array)
+	i := index.(*object.Integer).Value
+	max := int64(len(arrayObject.Elements) - 1)
+
+	// 越界返回null
+	if i < 0 || i > max {
+		return vm.push(Null)
+	}
+
+	return vm.push(arrayObject.Elements[i])
+}
+
+// 字符串按码点索引 越界返回null
+func (vm *VM) executeStringIndex(str, index object.Object) error {
+	ch, ok := str.(*object.String).CharAt(index.(*object.Integer).Value)
+	if !ok {
+		return vm.push(Null)
+	}
+	return vm.push(ch)
+}
+
+func (vm *VM) executeHashIndex(hash, index object.Object) error {
+	hashObject := hash.(*object.Hash)
+
+	key, ok := index.(object.Hashable)
+	if !ok {
+		return fmt.Errorf("the key is not hashable, key: %s", index.Type())
+	}
+
+	pair, ok := hashObject.Pairs[key.HashKey()]
+	if !ok {
+		// 没找到就返回null
+		return vm.push(Null)
+	}
+
+	return vm.push(pair.Value)
+}
+
+// endregion
+
 func (vm *VM) push(obj object.Object) error {
 	if vm.sp >= StackSize {
 		// 栈溢出
@@ -81,3 +716,32 @@ func (vm *VM) pop() object.Object {
 	vm.sp--
 	return o
 }
+
+// region 帮助函数
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return True
+	}
+	return False
+}
+
+// 是否是整数或浮点数
+func isNumber(obj object.Object) bool {
+	_, ok := object.ToFloat(obj)
+	return ok
+}
+
+// 判断对象是否为真 只有false和null为假
+func isTruthy(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		return obj.Value
+	case *object.Null:
+		return false
+	default:
+		return true
+	}
+}
+
+// endregion
@@ -7,6 +7,7 @@ import (
 	"MyCompiler/src/object"
 	"MyCompiler/src/parser"
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -22,11 +23,473 @@ func TestIntegerArithmetic(t *testing.T) {
 		{"100", 100},
 		{"65535", 65535},
 		{"1 + 2", 3},
+		{"1 - 2", -1},
+		{"1 * 2", 2},
+		{"4 / 2", 2},
+		{"50 / 2 * 2 + 10 - 5", 55},
+		{"5 * (2 + 10)", 60},
+		{"-5", -5},
+		{"-50 + 100 + -50", 0},
+		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"0xFF + 0o7 + 0b11", 265},
+		{"1_000 * 2", 2000},
+		{"-9223372036854775808", -9223372036854775808},
+		{"-9223372036854775808 < -9223372036854775807", true},
 	}
 	runVmTests(t, tests)
 }
 
-// region
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"1.5", 1.5},
+		{"2.5e2", 250.0},
+		{"1e-3", 0.001},
+		{"0.5 + 0.25", 0.75},
+		{"1 + 0.5", 1.5},
+		{"3 / 2.0", 1.5},
+		{"2.0 * 3", 6.0},
+		{"-1.5 - 1", -2.5},
+		{"7 / 2", 3},
+		{"1.0 == 1", true},
+		{"1 != 1.5", true},
+		{"0.1 + 0.2 > 0.3", true},
+		{"2 < 2.5", true},
+		{"2.5 > 3", false},
+	}
+	runVmTests(t, tests)
+}
+
+func TestBooleanExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"true", true},
+		{"false", false},
+		{"1 < 2", true},
+		{"1 > 2", false},
+		{"1 < 1", false},
+		{"1 == 1", true},
+		{"1 != 1", false},
+		{"1 != 2", true},
+		{"true == true", true},
+		{"true != false", true},
+		{"(1 < 2) == true", true},
+		{"(1 > 2) == true", false},
+		{"!true", false},
+		{"!false", true},
+		{"!5", false},
+		{"!!true", true},
+		{"!!5", true},
+		{"!(if (false) { 5; })", true},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"2.5 >= 2", true},
+		{"1 <= 0.5", false},
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && \"a\"", true},
+		{"0 || if (false) { 1 }", true},
+		{"if (false) { 1 } || false", false},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 2 > 3", false},
+		// 短路: 右边不会执行，否则会报类型错误
+		{"false && (1 + true)", false},
+		{"true || (1 + true)", true},
+	}
+	runVmTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []vmTestCase{
+		{"if (true) { 10 }", 10},
+		{"if (true) { 10 } else { 20 }", 10},
+		{"if (false) { 10 } else { 20 } ", 20},
+		{"if (1) { 10 }", 10},
+		{"if (1 < 2) { 10 }", 10},
+		{"if (1 < 2) { 10 } else { 20 }", 10},
+		{"if (1 > 2) { 10 } else { 20 }", 20},
+		{"if (1 > 2) { 10 }", Null},
+		{"if (false) { 10 }", Null},
+		{"if (true) { }", Null},
+		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+	}
+	runVmTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let i = 0; while (i < 5) { let i = i + 1; } i", 5},
+		{"let sum = 0; for (let i = 1; i <= 10; let i = i + 1) { let sum = sum + i; } sum", 55},
+		{"let i = 0; while (true) { if (i == 3) { break; } let i = i + 1; } i", 3},
+		{"let n = 0; for (let i = 0; i < 10; let i = i + 1) { if (i < 5) { continue; } let n = n + 1; } n", 5},
+		{"let n = 0; for (;;) { let n = n + 1; if (n > 4) { break; } } n", 5},
+		{"let i = 0; while (false) { let i = 1; } i", 0},
+		{`
+		let count = fn(n) {
+			let c = 0;
+			for (let i = 0; i < n; let i = i + 1) {
+				for (let j = 0; j < n; let j = j + 1) {
+					if (j == i) { break; }
+					let c = c + 1;
+				}
+			}
+			c;
+		};
+		count(4)
+		`, 6},
+		{"let f = fn() { while (true) { return 7; } }; f()", 7},
+		// 循环的值为null 不是最后一次计算的条件
+		{"let i = 0; while (i < 3) { let i = i + 1; }", Null},
+		{"for (let i = 0; i < 3; let i = i + 1) {}", Null},
+		{"let f = fn() { for (;;) { break; } }; f()", Null},
+	}
+	runVmTests(t, tests)
+}
+
+// 语句位置的if中跳转时栈上没有多余的值 迭代多次也不会栈溢出
+func TestLoopControlInIfStatement(t *testing.T) {
+	tests := []vmTestCase{
+		{"let n = 0; for (let i = 0; i < 5000; let i = i + 1) { if (i > 0) { continue; } let n = n + 1; } n", 1},
+		{"let n = 0; while (true) { let n = n + 1; if (n < 5000) { if (true) { continue; } } else { break; } } n", 5000},
+	}
+	runVmTests(t, tests)
+}
+
+// 表达式中的break和continue会在栈上留下算了一半的操作数 编译期就报错
+func TestLoopControlInExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"let r = 0; let c = true; for (let i = 0; i < 5000; let i = i + 1) { let r = r + if (c) { continue; } else { 1 }; } r",
+			"continue inside expression at <input>:1:90",
+		},
+		{
+			"let s = 0; let a = 1; for (let i = 0; i < 3; let i = i + 1) { let s = s + [a, if (i == 1) { continue; } else { i }][1]; } s",
+			"continue inside expression at <input>:1:93",
+		},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err == nil {
+			t.Fatalf("input: %s, expected compiler error but resulted in none", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong compiler error. want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"let one = 1; one", 1},
+		{"let one = 1; let two = 2; one + two", 3},
+		{"let one = 1; let two = one + one; one + two", 3},
+	}
+	runVmTests(t, tests)
+}
+
+func TestStringExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`"monkey"`, "monkey"},
+		{`"mon" + "key"`, "monkey"},
+		{`"mon" + "key" + "banana"`, "monkeybanana"},
+		{`"a" == "a"`, true},
+		{`"a" != "b"`, true},
+	}
+	runVmTests(t, tests)
+}
+
+func TestTemplateLiterals(t *testing.T) {
+	tests := []vmTestCase{
+		{`"a${1}b"`, "a1b"},
+		{`let name = "monkey"; "hello, ${name}!"`, "hello, monkey!"},
+		{`"${1 + 2} ${1.5 * 2} ${true} ${[1, "x"]}"`, `3 3.0 true [1, x]`},
+		{`let f = fn(x) { x * 2 }; "${f(2)}${f(3)}"`, "46"},
+		{`"outer ${"inner ${1}"}"`, "outer inner 1"},
+		{`fn(a, b) { "${a}-${b}" }(1, 2)`, "1-2"},
+		{"`raw ${x}`", "raw ${x}"},
+	}
+	runVmTests(t, tests)
+}
+
+func TestArrayLiterals(t *testing.T) {
+	tests := []vmTestCase{
+		{"[]", []int{}},
+		{"[1, 2, 3]", []int{1, 2, 3}},
+		{"[1 + 2, 3 * 4, 5 + 6]", []int{3, 12, 11}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestHashLiterals(t *testing.T) {
+	tests := []vmTestCase{
+		{"{}", map[object.HashKey]int64{}},
+		{
+			"{1: 2, 2: 3}",
+			map[object.HashKey]int64{
+				(&object.Integer{Value: 1}).HashKey(): 2,
+				(&object.Integer{Value: 2}).HashKey(): 3,
+			},
+		},
+		{
+			"{1 + 1: 2 * 2, 3 + 3: 4 * 4}",
+			map[object.HashKey]int64{
+				(&object.Integer{Value: 2}).HashKey(): 4,
+				(&object.Integer{Value: 6}).HashKey(): 16,
+			},
+		},
+		// 重复的键 后面的覆盖前面的
+		{
+			"{1: 2, 1: 3}",
+			map[object.HashKey]int64{
+				(&object.Integer{Value: 1}).HashKey(): 3,
+			},
+		},
+	}
+	runVmTests(t, tests)
+}
+
+func TestIndexExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"[1, 2, 3][1]", 2},
+		{"[1, 2, 3][0 + 2]", 3},
+		{"[[1, 1, 1]][0][0]", 1},
+		{"[][0]", Null},
+		{"[1, 2, 3][99]", Null},
+		{"[1][-1]", Null},
+		{"{1: 1, 2: 2}[1]", 1},
+		{"{1: 1, 2: 2}[2]", 2},
+		{"{1: 1}[0]", Null},
+		{"{}[0]", Null},
+		{`{"a": 5}["a"]`, 5},
+		{`"abc"[0]`, "a"},
+		{`"héllo"[1]`, "é"},
+		{`"你好世界"[3]`, "界"},
+		{`"你好"[2]`, Null},
+		{`"abc"[-1]`, Null},
+	}
+	runVmTests(t, tests)
+}
+
+func TestCallingFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let fivePlusTen = fn() { 5 + 10; }; fivePlusTen();", 15},
+		{"let a = fn() { 1 }; let b = fn() { a() + 1 }; b();", 2},
+		{"let early = fn() { return 99; 100; }; early();", 99},
+		{"let noReturn = fn() { }; noReturn();", Null},
+		{"let one = fn() { let one = 1; one }; one();", 1},
+		{"let sum = fn(a, b) { let c = a + b; c; }; sum(1, 2) + sum(3, 4);", 10},
+		{"let identity = fn(a) { a; }; identity(4);", 4},
+		{"fn(x) { x; }(5)", 5},
+		{"let g = 50; let minusOne = fn() { let n = 1; g - n }; minusOne();", 49},
+		{"return 10; 1 + 1;", 10},
+	}
+	runVmTests(t, tests)
+}
+
+func TestRecursiveFibonacci(t *testing.T) {
+	tests := []vmTestCase{
+		{`
+		let fibonacci = fn(x) {
+			if (x == 0) {
+				return 0;
+			} else {
+				if (x == 1) {
+					return 1;
+				} else {
+					fibonacci(x - 1) + fibonacci(x - 2);
+				}
+			}
+		};
+		fibonacci(15);`, 610},
+	}
+	runVmTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{`
+		let newClosure = fn(a) { fn() { a; }; };
+		let closure = newClosure(99);
+		closure();`, 99},
+		{`
+		let newAdder = fn(a, b) {
+			let c = a + b;
+			fn(d) { c + d };
+		};
+		let adder = newAdder(1, 2);
+		adder(8);`, 11},
+		{`
+		let newAdderOuter = fn(a, b) {
+			let c = a + b;
+			fn(d) {
+				let e = d + c;
+				fn(f) { e + f; };
+			};
+		};
+		let newAdderInner = newAdderOuter(1, 2);
+		let adder = newAdderInner(3);
+		adder(8);`, 14},
+		{`
+		let newCounter = fn(start) {
+			let next = fn() { start + 1 };
+			next;
+		};
+		let counter = newCounter(41);
+		counter();`, 42},
+	}
+	runVmTests(t, tests)
+}
+
+func TestRecursiveClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{`
+		let countDown = fn(x) {
+			if (x == 0) { return 0; } else { countDown(x - 1); }
+		};
+		let wrapper = fn() { countDown(1); };
+		wrapper();`, 0},
+		{`
+		let wrapper = fn() {
+			let countDown = fn(x) {
+				if (x == 0) { return 0; } else { countDown(x - 1); }
+			};
+			countDown(1);
+		};
+		wrapper();`, 0},
+		{`
+		let wrapper = fn() {
+			let fib = fn(x) {
+				if (x < 2) { return x; }
+				fib(x - 1) + fib(x - 2);
+			};
+			fib(10);
+		};
+		wrapper();`, 55},
+	}
+	runVmTests(t, tests)
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("")`, 0},
+		{`len("four")`, 4},
+		{`len("你好")`, 2},
+		{`len([1, 2, 3])`, 3},
+		{`len([])`, 0},
+		{`first([1, 2, 3])`, 1},
+		{`first([])`, Null},
+		{`last([1, 2, 3])`, 3},
+		{`last([])`, Null},
+		{`let l = fn(arr) { len(arr) }; l([1, 2])`, 2},
+	}
+	runVmTests(t, tests)
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"a" - "b"`, "unknown string operator: 3"},
+		{"1 + true", "unsupported types for binary operation: INTEGER BOOLEAN"},
+		{"1.5 + true", "unsupported types for binary operation: FLOAT BOOLEAN"},
+		{"1 / 0.0", "division by zero"},
+		{"{[1]: 2}", "the key is not hashable, key: ARRAY"},
+		{"{1: 2}[[1]]", "the key is not hashable, key: ARRAY"},
+		{"1[0]", "index operator not supported: INTEGER"},
+		{"fn() { 1; }(1);", "wrong number of arguments: want=0, got=1"},
+		{"fn(a, b) { a + b; }(1);", "wrong number of arguments: want=2, got=1"},
+		{"1();", "calling non-function"},
+		{"len(1)", "argument to `len` not supported, got INTEGER"},
+		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+		{"first(1)", "argument to `first` must be ARRAY, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("input: %s, expected VM error but resulted in none", tt.input)
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error. want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+// 符号表中有定义但没有赋值的全局变量 (REPL中编译失败的输入可能留下)
+func TestUnsetGlobal(t *testing.T) {
+	symbolTable := compiler.NewSymbolTable()
+	symbolTable.Define("a")
+	comp := compiler.NewWithState(symbolTable, []object.Object{})
+	err := comp.Compile(parse("a + 1"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := NewWithGlobals(comp.Bytecode(), make([]object.Object, GlobalsSize))
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none")
+	}
+	if err.Error() != "global variable 0 is not set" {
+		t.Errorf("wrong VM error. got=%q", err)
+	}
+}
+
+func TestWideOperands(t *testing.T) {
+	// 常量超过65536个后 常量索引和跳转位置都要用OpWide编码
+	statements := make([]string, 65540)
+	for i := range statements {
+		statements[i] = fmt.Sprint(i)
+	}
+	prefix := strings.Join(statements, "; ") + "; "
+
+	tests := []vmTestCase{
+		{prefix, 65539},
+		{prefix + "if (65538 > 1) { 65537 } else { 0 }", 65537},
+		{prefix + "let a = [65536, 65537]; a[1]", 65537},
+	}
+	runVmTests(t, tests)
+}
+
+// 向前跳转的目标超过两字节 回填时要改用宽跳转
+func TestWideForwardJumps(t *testing.T) {
+	// 每条语句4字节 25000条一共约10万字节
+	body := strings.Repeat("x; ", 25000)
+
+	tests := []vmTestCase{
+		{"let x = 1; if (true) { " + body + "7 }", 7},
+		{"let x = 1; if (false) { " + body + "7 } else { 8 }", 8},
+		{"let x = 1; let r = false || if (true) { " + body + "false }; r", false},
+		{"let x = 1; let i = 0; while (true) { let i = i + 1; if (i > 2) { break; } " + body + "} i", 3},
+		{"let x = 1; let n = 0; for (let i = 0; i < 3; let i = i + 1) { if (i == 1) { continue; } let n = n + 1; " + body + "} n", 2},
+		// 重新编译的if中的continue和break 不能留下第一次编译时记录的位置
+		{"let x = 1; let i = 0; while (i < 2) { let i = i + 1; if (true) { continue; " + body + "} } i", 2},
+		{"let x = 1; let i = 0; while (true) { let i = i + 1; if (i > 1) { break; " + body + "} } i", 2},
+		{"let f = fn(x) { let i = 0; for (; i < 3; let i = i + 1) { if (i > 0) { continue; " + body + "} } i }; f(1)", 3},
+		// 重新编译时len仍然是内置函数 不是后面定义的变量
+		{"let x = 1; if (true) { let r = len([1]); let len = 5; " + body + "r + len }", 6},
+	}
+	runVmTests(t, tests)
+}
+
+// endregion
 
 // region 帮助函数
 func parse(input string) *ast.Program {
@@ -54,7 +517,7 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 			t.Fatalf("vm error: %s", err)
 		}
 
-		stackElem := vm.StackTop()
+		stackElem := vm.LastPoppedStackElem()
 		testExpectedObject(t, tt.expected, stackElem)
 	}
 }
@@ -69,6 +532,70 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 		if err != nil {
 			t.Errorf("testIntegerObject failed: %s", err)
 		}
+	case float64:
+		f, ok := actual.(*object.Float)
+		if !ok {
+			t.Errorf("object is not Float. got=%T (%+v)", actual, actual)
+		} else if f.Value != expected {
+			t.Errorf("object has wrong value. got=%v, want=%v", f.Value, expected)
+		}
+	case bool:
+		err := testBooleanObject(expected, actual)
+		if err != nil {
+			t.Errorf("testBooleanObject failed: %s", err)
+		}
+	case string:
+		err := testStringObject(expected, actual)
+		if err != nil {
+			t.Errorf("testStringObject failed: %s", err)
+		}
+	case []int:
+		array, ok := actual.(*object.Array)
+		if !ok {
+			t.Errorf("object not Array: %T (%+v)", actual, actual)
+			return
+		}
+
+		if len(array.Elements) != len(expected) {
+			t.Errorf("wrong num of elements. want=%d, got=%d",
+				len(expected), len(array.Elements))
+			return
+		}
+
+		for i, expectedElem := range expected {
+			err := testIntegerObject(int64(expectedElem), array.Elements[i])
+			if err != nil {
+				t.Errorf("testIntegerObject failed: %s", err)
+			}
+		}
+	case map[object.HashKey]int64:
+		hash, ok := actual.(*object.Hash)
+		if !ok {
+			t.Errorf("object is not Hash. got=%T (%+v)", actual, actual)
+			return
+		}
+
+		if len(hash.Pairs) != len(expected) {
+			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d",
+				len(expected), len(hash.Pairs))
+			return
+		}
+
+		for expectedKey, expectedValue := range expected {
+			pair, ok := hash.Pairs[expectedKey]
+			if !ok {
+				t.Errorf("no pair for given key in Pairs")
+			}
+
+			err := testIntegerObject(expectedValue, pair.Value)
+			if err != nil {
+				t.Errorf("testIntegerObject failed: %s", err)
+			}
+		}
+	case *object.Null:
+		if actual != Null {
+			t.Errorf("object is not Null: %T (%+v)", actual, actual)
+		}
 	}
 }
 
@@ -87,4 +614,34 @@ func testIntegerObject(expected int64, actual object.Object) error {
 	return nil
 }
 
+// 测试布尔对象
+func testBooleanObject(expected bool, actual object.Object) error {
+	result, ok := actual.(*object.Boolean)
+	if !ok {
+		return fmt.Errorf("object is not Boolean. got=%T (%+v) ", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%t, expect=%t",
+			result.Value, expected)
+	}
+
+	return nil
+}
+
+// 测试字符串对象
+func testStringObject(expected string, actual object.Object) error {
+	result, ok := actual.(*object.String)
+	if !ok {
+		return fmt.Errorf("object is not String. got=%T (%+v) ", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%q, expect=%q",
+			result.Value, expected)
+	}
+
+	return nil
+}
+
 // endregion
@@ -0,0 +1,325 @@
+package ast
+
+import (
+	"MyCompiler/src/ast"
+	"MyCompiler/src/lexer"
+	"MyCompiler/src/parser"
+	"MyCompiler/src/token"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// 记录访问顺序的Visitor
+type recorder struct {
+	visited []string
+}
+
+func (r *recorder) Visit(node ast.Node) ast.Visitor {
+	if node == nil {
+		r.visited = append(r.visited, "end")
+	} else {
+		r.visited = append(r.visited, describe(node))
+	}
+	return r
+}
+
+func TestWalkOrder(t *testing.T) {
+	program := parse(t, `let f = fn(a) { if (a) { [1, "s${a}"] } }; f({"k": 2})[0]`)
+
+	r := &recorder{}
+	ast.Walk(r, program)
+
+	// 每个节点的子节点访问完之后都有一个end 叶子节点也不例外
+	expected := []string{
+		"*ast.Program",
+		"*ast.LetStatement", "f", "end", "*ast.FnExpression", "a", "end",
+		"*ast.BlockStatement", "*ast.ExpressionStatement", "*ast.IfExpression", "a", "end",
+		"*ast.BlockStatement", "*ast.ExpressionStatement", "*ast.ArrayLiteral", "1", "end",
+		"*ast.TemplateLiteral", "a", "end",
+		"end", "end", "end", "end", "end", // TemplateLiteral ArrayLiteral ExpressionStatement BlockStatement IfExpression
+		"end", "end", "end", "end", // ExpressionStatement BlockStatement FnExpression LetStatement
+		"*ast.ExpressionStatement", "*ast.IndexExpression", "*ast.CallExpression", "f", "end",
+		"*ast.HashLiteral", "k", "end", "2", "end", "end", "end", "0", "end", "end", "end",
+		"end",
+	}
+	if strings.Join(r.visited, " ") != strings.Join(expected, " ") {
+		t.Errorf("wrong visit order.\nwant=%v\ngot =%v", expected, r.visited)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	program := parse(t, `let x = 1 + y; let f = fn(z) { z * w };`)
+
+	// 不进入函数字面量 只收集外层的标识符
+	var names []string
+	ast.Inspect(program, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.FnExpression:
+			return false
+		case *ast.Identifier:
+			names = append(names, n.Value)
+		}
+		return true
+	})
+
+	expected := "x y f"
+	if strings.Join(names, " ") != expected {
+		t.Errorf("wrong identifiers. want=%q, got=%q", expected, strings.Join(names, " "))
+	}
+}
+
+func TestHashKeysInSourceOrder(t *testing.T) {
+	program := parse(t, `{"c": 1, "a": 2, "b": 3}`)
+	hash := program.Statement[0].(*ast.ExpressionStatement).Expression.(*ast.HashLiteral)
+
+	var keys []string
+	for _, k := range hash.Keys() {
+		keys = append(keys, k.String())
+	}
+	if strings.Join(keys, " ") != "c a b" {
+		t.Errorf("wrong key order. got=%v", keys)
+	}
+}
+
+func TestApplyConstantFolding(t *testing.T) {
+	program := parse(t, `let x = 1 + 2 * 3; f(4 - 1, y + 1);`)
+
+	result := ast.Apply(program, nil, func(c *ast.Cursor) bool {
+		infix, ok := c.Node().(*ast.InfixExpression)
+		if !ok {
+			return true
+		}
+		left, ok1 := infix.Left.(*ast.IntegerLiteral)
+		right, ok2 := infix.Right.(*ast.IntegerLiteral)
+		if !ok1 || !ok2 {
+			return true
+		}
+		var value int64
+		switch infix.Operator {
+		case "+":
+			value = left.Value + right.Value
+		case "-":
+			value = left.Value - right.Value
+		case "*":
+			value = left.Value * right.Value
+		default:
+			return true
+		}
+		c.Replace(&ast.IntegerLiteral{
+			Token: token.Token{Type: token.INT, Literal: fmt.Sprint(value), Pos: left.Pos(), End: right.End()},
+			Value: value,
+		})
+		return true
+	})
+
+	if result != program {
+		t.Fatalf("root should not be replaced")
+	}
+	expected := "let x = 7;f(3,(y + 1))"
+	if program.String() != expected {
+		t.Errorf("wrong program. want=%q, got=%q", expected, program.String())
+	}
+}
+
+func TestApplyCursor(t *testing.T) {
+	program := parse(t, `let x = a[0]; add(b, c);`)
+
+	var got []string
+	ast.Apply(program, func(c *ast.Cursor) bool {
+		parent := "nil"
+		if c.Parent() != nil {
+			parent = fmt.Sprintf("%T", c.Parent())
+		}
+		got = append(got, fmt.Sprintf("%s %s %s[%d]", describe(c.Node()), parent, c.Name(), c.Index()))
+		return true
+	}, nil)
+
+	expected := []string{
+		"*ast.Program nil Root[-1]",
+		"*ast.LetStatement *ast.Program Statement[0]",
+		"x *ast.LetStatement Name[-1]",
+		"*ast.IndexExpression *ast.LetStatement Value[-1]",
+		"a *ast.IndexExpression Left[-1]",
+		"0 *ast.IndexExpression Index[-1]",
+		"*ast.ExpressionStatement *ast.Program Statement[1]",
+		"*ast.CallExpression *ast.ExpressionStatement Expression[-1]",
+		"add *ast.CallExpression Function[-1]",
+		"b *ast.CallExpression Arguments[0]",
+		"c *ast.CallExpression Arguments[1]",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("wrong cursors.\nwant=%s\ngot =%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestApplyDeleteAndInsert(t *testing.T) {
+	program := parse(t, `let a = 1; let b = 2; let c = 3; [1, 2, 3, 4];`)
+
+	var indexes []int
+	ast.Apply(program, func(c *ast.Cursor) bool {
+		switch n := c.Node().(type) {
+		case *ast.LetStatement:
+			indexes = append(indexes, c.Index())
+			switch n.Name.Value {
+			case "a":
+				c.InsertBefore(parse(t, `first;`).Statement[0])
+			case "b":
+				c.Delete()
+				return false
+			case "c":
+				c.InsertAfter(parse(t, `last;`).Statement[0])
+			}
+		case *ast.IntegerLiteral:
+			// 删除数组中的偶数
+			if _, ok := c.Parent().(*ast.ArrayLiteral); ok && n.Value%2 == 0 {
+				c.Delete()
+			}
+		}
+		return true
+	}, nil)
+
+	expected := "firstlet a = 1;let c = 3;last[1, 3]"
+	if program.String() != expected {
+		t.Errorf("wrong program. want=%q, got=%q", expected, program.String())
+	}
+	// 下标在插入和删除之前读取 在a之前插入了一条语句，b和c依次占据下标2
+	if fmt.Sprint(indexes) != "[0 2 2]" {
+		t.Errorf("wrong indexes. got=%v", indexes)
+	}
+}
+
+func TestApplyHashLiteral(t *testing.T) {
+	program := parse(t, `{"a": 1, "b": 2, "c": 3}`)
+
+	ast.Apply(program, func(c *ast.Cursor) bool {
+		str, ok := c.Node().(*ast.StringLiteral)
+		if !ok || c.Name() != "Pairs.Key" {
+			return true
+		}
+		switch str.Value {
+		case "b":
+			c.Delete()
+		case "c":
+			tok := str.Token
+			tok.Literal = "d"
+			c.Replace(&ast.StringLiteral{Token: tok, Value: "d"})
+		}
+		return true
+	}, nil)
+
+	expected := `{a:1, d:3}`
+	if program.String() != expected {
+		t.Errorf("wrong program. want=%q, got=%q", expected, program.String())
+	}
+}
+
+func TestApplyStop(t *testing.T) {
+	program := parse(t, `a; b; c;`)
+
+	var names []string
+	ast.Apply(program, nil, func(c *ast.Cursor) bool {
+		if ident, ok := c.Node().(*ast.Identifier); ok {
+			names = append(names, ident.Value)
+			return ident.Value != "b"
+		}
+		return true
+	})
+
+	if strings.Join(names, " ") != "a b" {
+		t.Errorf("traversal should stop after b. got=%v", names)
+	}
+}
+
+func TestApplyReplaceRoot(t *testing.T) {
+	program := parse(t, `x`)
+	expr := program.Statement[0].(*ast.ExpressionStatement).Expression
+
+	result := ast.Apply(expr, func(c *ast.Cursor) bool {
+		c.Replace(&ast.BooleanLiteral{Token: token.Token{Type: token.TRUE, Literal: "true"}, Value: true})
+		return false
+	}, nil)
+
+	if result.String() != "true" {
+		t.Errorf("root not replaced. got=%q", result.String())
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	program := parse(t, `let y = fn(x) { if (x) { -x } else { !x } };`)
+
+	// 把所有标识符x改名为n
+	ast.Rewrite(program, func(node ast.Node) ast.Node {
+		if ident, ok := node.(*ast.Identifier); ok && ident.Value == "x" {
+			return &ast.Identifier{Token: ident.Token, Value: "n"}
+		}
+		return node
+	})
+
+	expected := "let y = fn(n)ifn (-n)else (!n);"
+	if program.String() != expected {
+		t.Errorf("wrong program. want=%q, got=%q", expected, program.String())
+	}
+}
+
+func TestApplyPanics(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		action   func(c *ast.Cursor)
+		expected string
+	}{
+		{"replace with wrong type", `let x = 1;`, func(c *ast.Cursor) {
+			if c.Name() == "Name" {
+				c.Replace(&ast.IntegerLiteral{})
+			}
+		}, "*ast.IntegerLiteral is not an *Identifier"},
+		{"delete field", `1 + 2`, func(c *ast.Cursor) {
+			if c.Name() == "Left" {
+				c.Delete()
+			}
+		}, "Left is not a list element"},
+		{"insert into template", `"a${b}c"`, func(c *ast.Cursor) {
+			if c.Name() == "Values" {
+				c.InsertAfter(&ast.Identifier{})
+			}
+		}, "Values is not a list element"},
+	}
+
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				r := recover()
+				if r == nil {
+					t.Errorf("%s: expected panic", tt.name)
+					return
+				}
+				if !strings.Contains(fmt.Sprint(r), tt.expected) {
+					t.Errorf("%s: wrong panic. want=%q, got=%q", tt.name, tt.expected, r)
+				}
+			}()
+			ast.Apply(parse(t, tt.input), func(c *ast.Cursor) bool {
+				tt.action(c)
+				return true
+			}, nil)
+		}()
+	}
+}
+
+// 叶子节点用字符串形式表示 其余节点用类型名
+func describe(node ast.Node) string {
+	switch node.(type) {
+	case *ast.Identifier, *ast.IntegerLiteral, *ast.StringLiteral, *ast.BooleanLiteral:
+		return node.String()
+	}
+	return fmt.Sprintf("%T", node)
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Error()) != 0 {
+		t.Fatalf("parser errors: %v", p.Error())
+	}
+	return program
+}
@@ -0,0 +1,85 @@
+package astdot
+
+import (
+	"MyCompiler/src/ast"
+	"MyCompiler/src/astdot"
+	"MyCompiler/src/lexer"
+	"MyCompiler/src/parser"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	input := "let s = \"a\\\"b\";\n-x"
+	p := parser.New(lexer.NewWithFilename("main.mk", input))
+	program := p.ParseProgram()
+	if len(p.Error()) != 0 {
+		t.Fatalf("parser errors: %v", p.Error())
+	}
+
+	var out bytes.Buffer
+	err := astdot.Write(&out, program)
+	if err != nil {
+		t.Fatalf("write error: %s", err)
+	}
+
+	expected := `digraph "ast" {
+  node [fontname="monospace"];
+  edge [fontname="monospace", fontsize="10"];
+  "n0" [label="Program\nmain.mk\n1:1", shape="box"];
+  "n1" [label="LetStatement\n1:1", shape="box"];
+  "n0" -> "n1" [label="Statement[0]"];
+  "n2" [label="Identifier\ns\n1:5", shape="ellipse"];
+  "n1" -> "n2" [label="Name"];
+  "n3" [label="StringLiteral\n\"a\\\"b\"\n1:9", shape="ellipse"];
+  "n1" -> "n3" [label="Value"];
+  "n4" [label="ExpressionStatement\n2:1", shape="box"];
+  "n0" -> "n4" [label="Statement[1]"];
+  "n5" [label="PrefixExpression\n-\n2:1", shape="ellipse"];
+  "n4" -> "n5" [label="Expression"];
+  "n6" [label="Identifier\nx\n2:2", shape="ellipse"];
+  "n5" -> "n6" [label="Right"];
+}
+`
+	if out.String() != expected {
+		t.Errorf("wrong output.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
+
+// 每个节点都画出来 除了根以外每个节点正好有一条入边
+func TestWriteTree(t *testing.T) {
+	input := `let add = fn(a, b) { if (a > b) { return [a, b][0]; } else { {"k": "${a}-${b}"} } }; add(1, 2.5)`
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Error()) != 0 {
+		t.Fatalf("parser errors: %v", p.Error())
+	}
+
+	var out bytes.Buffer
+	err := astdot.Write(&out, program)
+	if err != nil {
+		t.Fatalf("write error: %s", err)
+	}
+
+	nodes := 0
+	ast.Inspect(program, func(n ast.Node) bool {
+		if n != nil {
+			nodes++
+		}
+		return true
+	})
+
+	dot := out.String()
+	if got := strings.Count(dot, "shape="); got != nodes {
+		t.Errorf("wrong number of nodes. want=%d, got=%d", nodes, got)
+	}
+	if got := strings.Count(dot, " -> "); got != nodes-1 {
+		t.Errorf("wrong number of edges. want=%d, got=%d", nodes-1, got)
+	}
+	for _, label := range []string{`FnExpression\nadd`, `InfixExpression\n>`, `FloatLiteral\n2.5`, `\"${…}-${…}\"`, `label="Pairs.Value[0]"`, `label="Elements[1]"`} {
+		if !strings.Contains(dot, label) {
+			t.Errorf("output does not contain %s:\n%s", label, dot)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package astjson
+
+import (
+	"MyCompiler/src/ast"
+	"MyCompiler/src/astjson"
+	"MyCompiler/src/evaluator"
+	"MyCompiler/src/lexer"
+	"MyCompiler/src/object"
+	"MyCompiler/src/parser"
+	"MyCompiler/src/token"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	input := `let add = fn(a, b) { a + b <= 3 };
+let h = {"k": [1, 0x1F, 2.5e1][0], true: "s${x + 1}t"};
+if (!h && 1 >= 2) { add(1, 2)[0] } else { return -1; }
+let s = "名字 <&>";
+fn() {}();
+while (x) { break; }
+for (let i = 0; i < 3; i + 1) { continue }
+for (;;) {}
+`
+	program := parse(t, "main.mk", input)
+
+	data, err := astjson.Encode(program)
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+
+	decoded, err := astjson.Decode(data)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if decoded.String() != program.String() {
+		t.Errorf("wrong program.\nwant=%s\ngot =%s", program.String(), decoded.String())
+	}
+	if decoded.Pos() != program.Pos() || decoded.End() != program.End() {
+		t.Errorf("wrong program range. want=%s-%s, got=%s-%s", program.Pos(), program.End(), decoded.Pos(), decoded.End())
+	}
+
+	// 所有位置都保留下来 再次编码得到相同的结果
+	again, err := astjson.Encode(decoded)
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	if string(again) != string(data) {
+		t.Errorf("re-encoded JSON differs.\nwant=%s\ngot =%s", data, again)
+	}
+
+	if !strings.Contains(string(data), `"operator": "<="`) || !strings.Contains(string(data), `"名字 <&>"`) {
+		t.Errorf("expected unescaped operators and strings in JSON, got=%s", data)
+	}
+	if !strings.Contains(string(data), `"literal": "0x1F"`) {
+		t.Errorf("expected source literal of integer in JSON, got=%s", data)
+	}
+}
+
+func TestEncodeKinds(t *testing.T) {
+	program := parse(t, "", `let f = fn(x) { x }; f(1)`)
+
+	data, err := astjson.Encode(program)
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+
+	var root map[string]interface{}
+	err = json.Unmarshal(data, &root)
+	if err != nil {
+		t.Fatalf("invalid JSON: %s", err)
+	}
+	if root["kind"] != astjson.KindProgram {
+		t.Fatalf("wrong root kind. got=%v", root["kind"])
+	}
+	if _, ok := root["filename"]; ok {
+		t.Errorf("filename should be omitted when empty")
+	}
+
+	statements := root["statements"].([]interface{})
+	let := statements[0].(map[string]interface{})
+	if let["kind"] != astjson.KindLetStatement {
+		t.Errorf("wrong kind. want=%s, got=%v", astjson.KindLetStatement, let["kind"])
+	}
+	fn := let["value"].(map[string]interface{})
+	if fn["kind"] != astjson.KindFnExpression || fn["name"] != "f" {
+		t.Errorf("wrong function. got=%v", fn)
+	}
+	call := statements[1].(map[string]interface{})["expression"].(map[string]interface{})
+	if call["kind"] != astjson.KindCallExpression {
+		t.Errorf("wrong kind. want=%s, got=%v", astjson.KindCallExpression, call["kind"])
+	}
+	pos := call["tokenPos"].(map[string]interface{})
+	if pos["line"] != 1.0 || pos["column"] != 23.0 || pos["offset"] != 22.0 {
+		t.Errorf("wrong tokenPos. got=%v", pos)
+	}
+}
+
+// 外部工具生成的JSON可以不带位置和源码字面量
+func TestDecodeWithoutPositions(t *testing.T) {
+	input := `{
+  "kind": "Program",
+  "statements": [
+    {
+      "kind": "LetStatement",
+      "name": {"kind": "Identifier", "value": "double"},
+      "value": {
+        "kind": "FnExpression",
+        "parameters": [{"kind": "Identifier", "value": "x"}],
+        "body": {"kind": "BlockStatement", "statements": [
+          {"kind": "ExpressionStatement", "expression": {
+            "kind": "InfixExpression", "operator": "*",
+            "left": {"kind": "Identifier", "value": "x"},
+            "right": {"kind": "IntegerLiteral", "value": 2}
+          }}
+        ]}
+      }
+    },
+    {
+      "kind": "ExpressionStatement",
+      "expression": {
+        "kind": "TemplateLiteral",
+        "strings": ["result: ", ""],
+        "values": [{
+          "kind": "CallExpression",
+          "function": {"kind": "Identifier", "value": "double"},
+          "arguments": [{"kind": "IntegerLiteral", "value": 21}]
+        }]
+      }
+    }
+  ]
+}`
+
+	program, err := astjson.Decode([]byte(input))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+
+	expected := `let double = fn(x)(x * 2);"result: ${double(21)}"`
+	if program.String() != expected {
+		t.Errorf("wrong program. want=%q, got=%q", expected, program.String())
+	}
+
+	let := program.Statement[0].(*ast.LetStatement)
+	if fn := let.Value.(*ast.FnExpression); fn.Name != "double" {
+		t.Errorf("function name not recorded. got=%q", fn.Name)
+	}
+	if program.Pos().IsValid() {
+		t.Errorf("expected unknown position, got=%s", program.Pos())
+	}
+
+	result := evaluator.Eval(program, object.NewEnvironment())
+	str, ok := result.(*object.String)
+	if !ok || str.Value != "result: 42" {
+		t.Errorf("wrong result. got=%#v", result)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`[]`, "json: cannot unmarshal array"},
+		{`{"statements": []}`, "node without kind"},
+		{`{"kind": "Identifier", "value": "x"}`, "root node must be Program, got *ast.Identifier"},
+		{`{"kind": "Program"}`, `Program: missing field "statements"`},
+		{`{"kind": "Program", "statements": [{"kind": "Loop"}]}`, "Loop: unknown node kind"},
+		{`{"kind": "Program", "statements": [{"kind": "Identifier", "value": "x"}]}`,
+			"Program: statements[0]: expected a statement, got *ast.Identifier"},
+		{`{"kind": "Program", "statements": [{"kind": "ExpressionStatement", "expression":
+			{"kind": "InfixExpression", "operator": "+", "left": {"kind": "IntegerLiteral", "value": 1}}}]}`,
+			`InfixExpression: missing field "right"`},
+		{`{"kind": "Program", "statements": [{"kind": "ExpressionStatement", "expression":
+			{"kind": "IntegerLiteral", "value": "1"}}]}`,
+			`IntegerLiteral: field "value"`},
+		{`{"kind": "Program", "statements": [{"kind": "ExpressionStatement", "expression":
+			{"kind": "TemplateLiteral", "strings": ["a"], "values": [{"kind": "Identifier", "value": "x"}]}}]}`,
+			"TemplateLiteral: expected 2 strings for 1 values, got 1"},
+	}
+
+	for _, tt := range tests {
+		_, err := astjson.Decode([]byte(tt.input))
+		if err == nil {
+			t.Errorf("input %s: expected error, got none", tt.input)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("input %s: wrong error. want=%q, got=%q", tt.input, tt.expected, err.Error())
+		}
+	}
+}
+
+func TestBadStatement(t *testing.T) {
+	p := parser.New(lexer.New("let x 5;\nlet y = 1;"))
+	program := p.ParseProgram()
+
+	data, err := astjson.Encode(program)
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	decoded, err := astjson.Decode(data)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+
+	bad, ok := decoded.Statement[0].(*ast.BadStatement)
+	if !ok {
+		t.Fatalf("statement is not ast.BadStatement. got=%T", decoded.Statement[0])
+	}
+	if bad.Pos() != program.Statement[0].Pos() || bad.End() != program.Statement[0].End() {
+		t.Errorf("wrong range. want=%s-%s, got=%s-%s",
+			program.Statement[0].Pos(), program.Statement[0].End(), bad.Pos(), bad.End())
+	}
+	if decoded.String() != program.String() {
+		t.Errorf("wrong program. want=%q, got=%q", program.String(), decoded.String())
+	}
+}
+
+func TestEncodeTokens(t *testing.T) {
+	l := lexer.NewWithFilename("main.mk", "// 注释\nx >= 1")
+	l.SetMode(lexer.KeepComments)
+	var tokens []token.Token
+	for tok := l.NextToken(); ; tok = l.NextToken() {
+		tokens = append(tokens, tok)
+		if tok.Type == token.EOF {
+			break
+		}
+	}
+
+	data, err := astjson.EncodeTokens(tokens)
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+
+	var decoded []struct {
+		Type    string
+		Literal string
+		Pos     struct{ Line, Column, Offset int }
+		End     struct{ Line, Column, Offset int }
+		Leading []struct{ Text string }
+	}
+	err = json.Unmarshal(data, &decoded)
+	if err != nil {
+		t.Fatalf("invalid JSON: %s", err)
+	}
+	if len(decoded) != len(tokens) {
+		t.Fatalf("wrong number of tokens. want=%d, got=%d", len(tokens), len(decoded))
+	}
+	for i, tok := range tokens {
+		got := decoded[i]
+		if got.Type != string(tok.Type) || got.Literal != tok.Literal {
+			t.Errorf("%d - wrong token. want=%s %q, got=%s %q", i, tok.Type, tok.Literal, got.Type, got.Literal)
+		}
+		if got.Pos.Line != tok.Pos.Line || got.Pos.Column != tok.Pos.Column || got.End.Offset != tok.End.Offset {
+			t.Errorf("%d - wrong position. want=%s-%s, got=%+v-%+v", i, tok.Pos, tok.End, got.Pos, got.End)
+		}
+	}
+	if len(decoded[0].Leading) != 1 || decoded[0].Leading[0].Text != "// 注释" {
+		t.Errorf("leading comments not encoded. got=%+v", decoded[0].Leading)
+	}
+	if !strings.Contains(string(data), `">="`) {
+		t.Errorf("operator should not be escaped. got=%s", data)
+	}
+}
+
+func parse(t *testing.T, filename, input string) *ast.Program {
+	p := parser.New(lexer.NewWithFilename(filename, input))
+	program := p.ParseProgram()
+	if len(p.Error()) != 0 {
+		t.Fatalf("parser errors: %v", p.Error())
+	}
+	return program
+}
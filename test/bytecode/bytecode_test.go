@@ -0,0 +1,295 @@
+package bytecode
+
+import (
+	"MyCompiler/src/bytecode"
+	"MyCompiler/src/code"
+	"MyCompiler/src/compiler"
+	"MyCompiler/src/lexer"
+	"MyCompiler/src/object"
+	"MyCompiler/src/parser"
+	"MyCompiler/src/vm"
+	"bytes"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	input := `
+let greet = fn(name) { "hello " + name };
+let add = fn(a) { fn(b) { a + b } };
+let h = {"one": 1, "two": 2};
+greet("monkey");
+let ratio = 1.5e3 / 4;
+add(-3)(h["two"]);
+`
+	bc := compile(t, input)
+
+	data, err := bytecode.Encode(bc, &bytecode.DebugInfo{SourceName: "test.mk"})
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+
+	decoded, debug, err := bytecode.Decode(data)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+
+	if debug == nil || debug.SourceName != "test.mk" {
+		t.Errorf("wrong debug info. got=%+v", debug)
+	}
+
+	if !bytes.Equal(decoded.Instructions, bc.Instructions) {
+		t.Errorf("wrong instructions.\nwant=%q\ngot =%q", bc.Instructions, decoded.Instructions)
+	}
+
+	if len(decoded.Constants) != len(bc.Constants) {
+		t.Fatalf("wrong number of constants. want=%d, got=%d",
+			len(bc.Constants), len(decoded.Constants))
+	}
+
+	for i, c := range bc.Constants {
+		d := decoded.Constants[i]
+		if c.Type() != d.Type() {
+			t.Errorf("constant %d has wrong type. want=%s, got=%s", i, c.Type(), d.Type())
+			continue
+		}
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			decodedFn := d.(*object.CompiledFunction)
+			if !bytes.Equal(fn.Instructions, decodedFn.Instructions) ||
+				fn.NumLocals != decodedFn.NumLocals ||
+				fn.NumParameters != decodedFn.NumParameters {
+				t.Errorf("constant %d: function differs after decoding", i)
+			}
+			continue
+		}
+		if c.Inspect() != d.Inspect() {
+			t.Errorf("constant %d differs. want=%s, got=%s", i, c.Inspect(), d.Inspect())
+		}
+	}
+
+	// 解码后的字节码可以直接执行
+	machine := vm.New(decoded)
+	err = machine.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	result, ok := machine.LastPoppedStackElem().(*object.Integer)
+	if !ok || result.Value != -1 {
+		t.Errorf("wrong result. got=%+v", machine.LastPoppedStackElem())
+	}
+}
+
+func TestDeterministicEncoding(t *testing.T) {
+	inputs := []string{
+		`let h = {"b": 2, "a": 1, "c": 3, 4: 5}; h["a"]`,
+		// 重复的键
+		`{1: "a", 1: "b"}[1]`,
+	}
+
+	for _, input := range inputs {
+		first, err := bytecode.Encode(compile(t, input), nil)
+		if err != nil {
+			t.Fatalf("encode error: %s", err)
+		}
+
+		for i := 0; i < 20; i++ {
+			data, err := bytecode.Encode(compile(t, input), nil)
+			if err != nil {
+				t.Fatalf("encode error: %s", err)
+			}
+			if !bytes.Equal(first, data) {
+				t.Fatalf("encoding is not deterministic for %q", input)
+			}
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	valid, err := bytecode.Encode(compile(t, "1 + 2"), nil)
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+
+	badVersion := append([]byte{}, valid...)
+	badVersion[5] = 99
+
+	tests := []struct {
+		name     string
+		input    []byte
+		expected string
+	}{
+		{"empty", []byte{}, "not a bytecode file: bad magic header"},
+		{"magic", []byte("ELF\x00\x00\x01"), "not a bytecode file: bad magic header"},
+		{"version", badVersion, "unsupported bytecode version 99 (want 1)"},
+		{"truncated", valid[:len(valid)-2], "unexpected end of file at offset 33"},
+		{"trailing", append(append([]byte{}, valid...), 0), "unexpected 1 trailing bytes"},
+	}
+
+	for _, tt := range tests {
+		_, _, err := bytecode.Decode(tt.input)
+		if err == nil {
+			t.Errorf("%s: expected error, got none", tt.name)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("%s: wrong error. want=%q, got=%q", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	inputs := []string{
+		"1 + 2",
+		`let greet = fn(name) { "hello ${name}" }; greet("monkey")`,
+		"let add = fn(a) { fn(b) { a + b } }; add(1)(2)",
+		"let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) }; fib(10)",
+		`let h = {"a": [1, 2], "b": len("xy")}; h["a"][1]`,
+		"let s = 0; for (let i = 0; i < 10; let i = i + 1) { if (i > 5) { break; } let s = s + i; } s",
+		"let f = fn() { let n = 0; while (n < 3) { let n = n + 1; } n }; f()",
+		"fn() {}",
+		"return 1; 2",
+	}
+
+	for _, input := range inputs {
+		if err := bytecode.Verify(compile(t, input)); err != nil {
+			t.Errorf("input %q: unexpected error: %s", input, err)
+		}
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	fn := func(numLocals, numParameters int, ins ...[]byte) *object.CompiledFunction {
+		return &object.CompiledFunction{
+			Instructions:  concat(ins...),
+			NumLocals:     numLocals,
+			NumParameters: numParameters,
+		}
+	}
+
+	tests := []struct {
+		name         string
+		instructions []byte
+		constants    []object.Object
+		expected     string
+	}{
+		{
+			"constant index",
+			concat(code.Make(code.OpConstant, 9), code.Make(code.OpPop)),
+			nil,
+			"instructions: 0000: constant index 9 out of range",
+		},
+		{
+			"unknown opcode",
+			[]byte{200},
+			nil,
+			"instructions: 0000: invalid opcode: 200",
+		},
+		{
+			"truncated operands",
+			[]byte{byte(code.OpConstant), 0},
+			[]object.Object{&object.Integer{Value: 1}},
+			"instructions: 0000: truncated operands for OpConstant: want 2 bytes, got 1",
+		},
+		{
+			"empty stack",
+			code.Make(code.OpPop),
+			nil,
+			"instructions: 0000: OpPop pops 1 values but the stack may have only 0",
+		},
+		{
+			"builtin index",
+			code.Make(code.OpGetBuiltin, 99),
+			nil,
+			"instructions: 0000: builtin index 99 out of range",
+		},
+		{
+			"jump target",
+			code.Make(code.OpJump, 100),
+			nil,
+			"instructions: jump to 0100, which is not an instruction boundary",
+		},
+		{
+			"local in main",
+			code.Make(code.OpGetLocal, 0),
+			nil,
+			"instructions: 0000: OpGetLocal outside a function",
+		},
+		{
+			"return in main",
+			code.Make(code.OpReturn),
+			nil,
+			"instructions: 0000: OpReturn outside a function",
+		},
+		{
+			// 每次循环多弹出一个值
+			"loop underflow",
+			concat(code.Make(code.OpTrue), code.Make(code.OpPop), code.Make(code.OpJump, 1)),
+			nil,
+			"instructions: 0001: OpPop pops 1 values but the stack may have only 0",
+		},
+		{
+			"closure of non-function",
+			code.Make(code.OpClosure, 0, 0),
+			[]object.Object{&object.Integer{Value: 1}},
+			"instructions: 0000: constant 0 is not a function",
+		},
+		{
+			"local index",
+			code.Make(code.OpClosure, 0, 0),
+			[]object.Object{fn(1, 1, code.Make(code.OpGetLocal, 1), code.Make(code.OpReturnValue))},
+			"constant 0: 0000: local index 1 out of range",
+		},
+		{
+			"free variables",
+			code.Make(code.OpClosure, 0, 0),
+			[]object.Object{fn(0, 0, code.Make(code.OpGetFree, 0), code.Make(code.OpReturnValue))},
+			"0000: closure of constant 0 needs 1 free variables, got 0",
+		},
+		{
+			"parameters",
+			code.Make(code.OpClosure, 0, 0),
+			[]object.Object{fn(0, 1, code.Make(code.OpReturn))},
+			"constant 0: 1 parameters but only 0 locals",
+		},
+	}
+
+	for _, tt := range tests {
+		bc := &compiler.ByteCode{Instructions: tt.instructions, Constants: tt.constants}
+		err := bytecode.Verify(bc)
+		if err == nil {
+			t.Errorf("%s: expected error, got none", tt.name)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("%s: wrong error. want=%q, got=%q", tt.name, tt.expected, err)
+		}
+	}
+}
+
+// region 帮助函数
+
+func compile(t *testing.T, input string) *compiler.ByteCode {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Error()) != 0 {
+		t.Fatalf("parser errors: %v", p.Error())
+	}
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return comp.Bytecode()
+}
+
+func concat(ins ...[]byte) code.Instructions {
+	out := code.Instructions{}
+	for _, i := range ins {
+		out = append(out, i...)
+	}
+	return out
+}
+
+// endregion
@@ -0,0 +1,194 @@
+package cfg
+
+import (
+	"MyCompiler/src/cfg"
+	"MyCompiler/src/code"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type expectedBlock struct {
+	start, end int
+	succs      []cfg.Edge
+	preds      []int
+}
+
+func TestBuild(t *testing.T) {
+	tests := []struct {
+		name     string
+		ins      []code.Instructions
+		expected []expectedBlock
+	}{
+		{
+			"straight line",
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+			[]expectedBlock{
+				{0, 4, []cfg.Edge{{To: cfg.Exit, Kind: cfg.Fallthrough}}, nil},
+			},
+		},
+		{
+			// if (true) { 10 } else { 20 }; 30
+			"if else",
+			[]code.Instructions{
+				code.Make(code.OpTrue),              // 0000
+				code.Make(code.OpJumpNotTruthy, 10), // 0001
+				code.Make(code.OpConstant, 0),       // 0004
+				code.Make(code.OpJump, 13),          // 0007
+				code.Make(code.OpConstant, 1),       // 0010
+				code.Make(code.OpPop),               // 0013
+				code.Make(code.OpConstant, 2),       // 0014
+				code.Make(code.OpPop),               // 0017
+			},
+			[]expectedBlock{
+				{0, 4, []cfg.Edge{{To: 2, Kind: cfg.Branch}, {To: 1, Kind: cfg.Fallthrough}}, nil},
+				{4, 10, []cfg.Edge{{To: 3, Kind: cfg.Jump}}, []int{0}},
+				{10, 13, []cfg.Edge{{To: 3, Kind: cfg.Fallthrough}}, []int{0}},
+				{13, 18, []cfg.Edge{{To: cfg.Exit, Kind: cfg.Fallthrough}}, []int{1, 2}},
+			},
+		},
+		{
+			// 返回之后的指令不可达 没有前驱
+			"return",
+			[]code.Instructions{
+				code.Make(code.OpGetLocal, 0),      // 0000
+				code.Make(code.OpJumpNotTruthy, 7), // 0002
+				code.Make(code.OpReturnValue),      // 0005
+				code.Make(code.OpNull),             // 0006
+				code.Make(code.OpReturnValue),      // 0007
+			},
+			[]expectedBlock{
+				{0, 5, []cfg.Edge{{To: 3, Kind: cfg.Branch}, {To: 1, Kind: cfg.Fallthrough}}, nil},
+				{5, 6, []cfg.Edge{{To: cfg.Exit, Kind: cfg.Return}}, []int{0}},
+				{6, 7, []cfg.Edge{{To: 3, Kind: cfg.Fallthrough}}, nil},
+				{7, 8, []cfg.Edge{{To: cfg.Exit, Kind: cfg.Return}}, []int{0, 2}},
+			},
+		},
+		{
+			// 跳到指令末尾就是离开这段指令 跳回自身的块是自己的前驱
+			"loop and jump to end",
+			[]code.Instructions{
+				code.Make(code.OpTrue),             // 0000
+				code.Make(code.OpJumpNotTruthy, 7), // 0001
+				code.Make(code.OpJump, 0),          // 0004
+			},
+			[]expectedBlock{
+				{0, 4, []cfg.Edge{{To: cfg.Exit, Kind: cfg.Branch}, {To: 1, Kind: cfg.Fallthrough}}, []int{1}},
+				{4, 7, []cfg.Edge{{To: 0, Kind: cfg.Jump}}, []int{0}},
+			},
+		},
+		{
+			"wide jump",
+			[]code.Instructions{
+				code.MakeWide(code.OpJump, 7), // 0000
+				code.Make(code.OpNull),        // 0006
+				code.Make(code.OpPop),         // 0007
+			},
+			[]expectedBlock{
+				{0, 6, []cfg.Edge{{To: 2, Kind: cfg.Jump}}, nil},
+				{6, 7, []cfg.Edge{{To: 2, Kind: cfg.Fallthrough}}, nil},
+				{7, 8, []cfg.Edge{{To: cfg.Exit, Kind: cfg.Fallthrough}}, []int{0, 1}},
+			},
+		},
+		{
+			"empty",
+			nil,
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		ins := concat(tt.ins)
+		g, err := cfg.Build(ins)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+			continue
+		}
+		if len(g.Blocks) != len(tt.expected) {
+			t.Errorf("%s: wrong number of blocks. want=%d, got=%d", tt.name, len(tt.expected), len(g.Blocks))
+			continue
+		}
+		for i, want := range tt.expected {
+			b := g.Blocks[i]
+			if b.Index != i || b.Start != want.start || b.End != want.end {
+				t.Errorf("%s: block %d has wrong range. want=%d:[%d,%d), got=%d:[%d,%d)",
+					tt.name, i, i, want.start, want.end, b.Index, b.Start, b.End)
+			}
+			if !reflect.DeepEqual(b.Succs, want.succs) {
+				t.Errorf("%s: block %d has wrong successors. want=%v, got=%v", tt.name, i, want.succs, b.Succs)
+			}
+			if !reflect.DeepEqual(b.Preds, want.preds) {
+				t.Errorf("%s: block %d has wrong predecessors. want=%v, got=%v", tt.name, i, want.preds, b.Preds)
+			}
+		}
+	}
+}
+
+func TestBlockInstructions(t *testing.T) {
+	ins := concat([]code.Instructions{
+		code.Make(code.OpConstant, 0),
+		code.MakeWide(code.OpGetGlobal, 70000),
+		code.Make(code.OpAdd),
+		code.Make(code.OpReturnValue),
+	})
+	g, err := cfg.Build(ins)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	positions := g.Blocks[0].Instructions(ins)
+	expected := []int{0, 3, 9, 10}
+	if !reflect.DeepEqual(positions, expected) {
+		t.Errorf("wrong positions. want=%v, got=%v", expected, positions)
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	tests := []struct {
+		ins      code.Instructions
+		expected string
+	}{
+		{concat([]code.Instructions{code.Make(code.OpNull), {255}}), "0001: "},
+		{concat([]code.Instructions{code.Make(code.OpJump, 2), code.Make(code.OpConstant, 0)}), "jump to 0002, which is not an instruction boundary"},
+		{concat([]code.Instructions{code.Make(code.OpJump, 9)}), "jump to 0009, which is not an instruction boundary"},
+	}
+
+	for _, tt := range tests {
+		_, err := cfg.Build(tt.ins)
+		if err == nil {
+			t.Errorf("expected error for %v, got none", tt.ins)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), tt.expected) {
+			t.Errorf("wrong error. want prefix %q, got=%q", tt.expected, err.Error())
+		}
+	}
+}
+
+func TestEdgeKindString(t *testing.T) {
+	kinds := map[cfg.EdgeKind]string{
+		cfg.Fallthrough: "fallthrough",
+		cfg.Jump:        "jump",
+		cfg.Branch:      "branch",
+		cfg.Return:      "return",
+	}
+	for kind, expected := range kinds {
+		if kind.String() != expected {
+			t.Errorf("wrong name. want=%q, got=%q", expected, kind.String())
+		}
+	}
+}
+
+// region 帮助函数
+
+func concat(list []code.Instructions) code.Instructions {
+	out := code.Instructions{}
+	for _, ins := range list {
+		out = append(out, ins...)
+	}
+	return out
+}
+
+// endregion
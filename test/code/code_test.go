@@ -30,4 +30,153 @@ func TestMake(t *testing.T) {
 			}
 		}
 	}
-}
\ No newline at end of file
+}
+
+func TestInstructionsString(t *testing.T) {
+	instructions := []code.Instructions{
+		code.Make(code.OpAdd),
+		code.Make(code.OpGetLocal, 1),
+		code.Make(code.OpConstant, 2),
+		code.Make(code.OpConstant, 65535),
+		code.Make(code.OpClosure, 65535, 255),
+	}
+
+	expected := `0000 OpAdd
+0001 OpGetLocal 1
+0003 OpConstant 2
+0006 OpConstant 65535
+0009 OpClosure 65535 255
+`
+
+	concatted := code.Instructions{}
+	for _, ins := range instructions {
+		concatted = append(concatted, ins...)
+	}
+
+	if concatted.String() != expected {
+		t.Errorf("instructions wrongly formatted.\nwant=%q\ngot =%q", expected, concatted.String())
+	}
+}
+
+func TestInstructionsStringMalformed(t *testing.T) {
+	// 未知操作码要跳过，不能死循环；不完整的操作数要报错
+	ins := code.Instructions{255, byte(code.OpAdd), byte(code.OpConstant), 1}
+
+	expected := `0000 ERROR: invalid opcode: 255
+0001 OpAdd
+0002 ERROR: truncated operands for OpConstant: want 2 bytes, got 1
+`
+
+	if ins.String() != expected {
+		t.Errorf("instructions wrongly formatted.\nwant=%q\ngot =%q", expected, ins.String())
+	}
+}
+
+func TestReadOperands(t *testing.T) {
+	tests := []struct {
+		op        code.Opcode
+		operands  []int
+		bytesRead int
+	}{
+		{code.OpConstant, []int{65535}, 2},
+		{code.OpGetLocal, []int{255}, 1},
+		{code.OpClosure, []int{65535, 255}, 3},
+	}
+
+	for _, tt := range tests {
+		instruction := code.Make(tt.op, tt.operands...)
+
+		def, err := code.Lookup(tt.op)
+		if err != nil {
+			t.Fatalf("definition not found: %q\n", err)
+		}
+
+		operandsRead, n := code.ReadOperands(def, instruction[1:])
+		if n != tt.bytesRead {
+			t.Fatalf("n wrong. want=%d, got=%d", tt.bytesRead, n)
+		}
+
+		for i, want := range tt.operands {
+			if operandsRead[i] != want {
+				t.Errorf("operand wrong. want=%d, got=%d", want, operandsRead[i])
+			}
+		}
+	}
+}
+
+func TestMakeWide(t *testing.T) {
+	tests := []struct {
+		op       code.Opcode
+		operands []int
+		expected []byte
+	}{
+		{code.OpConstant, []int{65536}, []byte{byte(code.OpWide), byte(code.OpConstant), 0, 1, 0, 0}},
+		{code.OpGetLocal, []int{256}, []byte{byte(code.OpWide), byte(code.OpGetLocal), 1, 0}},
+		{code.OpClosure, []int{70000, 300}, []byte{byte(code.OpWide), byte(code.OpClosure), 0, 1, 17, 112, 1, 44}},
+	}
+
+	for _, tt := range tests {
+		instruction := code.MakeWide(tt.op, tt.operands...)
+		if len(instruction) != len(tt.expected) {
+			t.Fatalf("instruction has wrong length. expect=%d, got=%d", len(tt.expected), len(instruction))
+		}
+		for i, b := range tt.expected {
+			if instruction[i] != b {
+				t.Errorf("wrong byte at pos %d, expect=%d, got=%d", i, b, instruction[i])
+			}
+		}
+
+		def, operands, width, err := code.ReadInstruction(instruction, 0)
+		if err != nil {
+			t.Fatalf("ReadInstruction failed: %s", err)
+		}
+		if width != len(tt.expected) {
+			t.Errorf("wrong instruction read. width=%d (%s)", width, def.Name)
+		}
+		for i, want := range tt.operands {
+			if operands[i] != want {
+				t.Errorf("operand wrong. want=%d, got=%d", want, operands[i])
+			}
+		}
+	}
+}
+
+func TestWideInstructionsString(t *testing.T) {
+	ins := code.Instructions{}
+	ins = append(ins, code.MakeWide(code.OpConstant, 65536)...)
+	ins = append(ins, code.Make(code.OpPop)...)
+	ins = append(ins, byte(code.OpWide), byte(code.OpAdd))
+	ins = append(ins, byte(code.OpWide))
+
+	expected := `0000 OpWide OpConstant 65536
+0006 OpPop
+0007 ERROR: OpWide cannot prefix OpAdd
+0009 ERROR: OpWide at end of instructions
+`
+
+	if ins.String() != expected {
+		t.Errorf("instructions wrongly formatted.\nwant=%q\ngot =%q", expected, ins.String())
+	}
+}
+
+func TestFits(t *testing.T) {
+	tests := []struct {
+		width    int
+		operand  int
+		expected bool
+	}{
+		{1, 255, true},
+		{1, 256, false},
+		{2, 65535, true},
+		{2, 65536, false},
+		{4, 65536, true},
+		{4, 1 << 32, false},
+		{2, -1, false},
+	}
+
+	for _, tt := range tests {
+		if got := code.Fits(tt.width, tt.operand); got != tt.expected {
+			t.Errorf("Fits(%d, %d) wrong. want=%t, got=%t", tt.width, tt.operand, tt.expected, got)
+		}
+	}
+}
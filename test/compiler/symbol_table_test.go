@@ -0,0 +1,156 @@
+package compiler
+
+import (
+	"MyCompiler/src/compiler"
+	"testing"
+)
+
+func TestDefine(t *testing.T) {
+	expected := map[string]compiler.Symbol{
+		"a": {Name: "a", Scope: compiler.GlobalScope, Index: 0},
+		"b": {Name: "b", Scope: compiler.GlobalScope, Index: 1},
+	}
+
+	global := compiler.NewSymbolTable()
+
+	a := global.Define("a")
+	if a != expected["a"] {
+		t.Errorf("expected a=%+v, got=%+v", expected["a"], a)
+	}
+
+	b := global.Define("b")
+	if b != expected["b"] {
+		t.Errorf("expected b=%+v, got=%+v", expected["b"], b)
+	}
+}
+
+func TestResolveGlobal(t *testing.T) {
+	global := compiler.NewSymbolTable()
+	global.Define("a")
+	global.Define("b")
+
+	expected := []compiler.Symbol{
+		{Name: "a", Scope: compiler.GlobalScope, Index: 0},
+		{Name: "b", Scope: compiler.GlobalScope, Index: 1},
+	}
+
+	for _, sym := range expected {
+		result, ok := global.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+			continue
+		}
+		if result != sym {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+		}
+	}
+
+	if _, ok := global.Resolve("c"); ok {
+		t.Errorf("name c should not be resolvable")
+	}
+}
+
+func TestClone(t *testing.T) {
+	global := compiler.NewSymbolTable()
+	global.Define("a")
+
+	clone := global.Clone()
+	b := clone.Define("b")
+	if b.Index != 1 {
+		t.Errorf("expected b to get index 1, got=%d", b.Index)
+	}
+	if _, ok := global.Resolve("b"); ok {
+		t.Errorf("name b should not be resolvable in the original table")
+	}
+	if c := global.Define("c"); c.Index != 1 {
+		t.Errorf("expected c to get index 1, got=%d", c.Index)
+	}
+	if _, ok := clone.Resolve("a"); !ok {
+		t.Errorf("name a not resolvable in the clone")
+	}
+}
+
+func TestResolveLocal(t *testing.T) {
+	global := compiler.NewSymbolTable()
+	global.Define("a")
+	global.Define("b")
+
+	local := compiler.NewEnclosedSymbolTable(global)
+	local.Define("c")
+	local.Define("d")
+
+	expected := []compiler.Symbol{
+		{Name: "a", Scope: compiler.GlobalScope, Index: 0},
+		{Name: "b", Scope: compiler.GlobalScope, Index: 1},
+		{Name: "c", Scope: compiler.LocalScope, Index: 0},
+		{Name: "d", Scope: compiler.LocalScope, Index: 1},
+	}
+
+	for _, sym := range expected {
+		result, ok := local.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+			continue
+		}
+		if result != sym {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+		}
+	}
+}
+
+func TestResolveFree(t *testing.T) {
+	global := compiler.NewSymbolTable()
+	global.Define("a")
+
+	firstLocal := compiler.NewEnclosedSymbolTable(global)
+	firstLocal.Define("b")
+
+	secondLocal := compiler.NewEnclosedSymbolTable(firstLocal)
+	secondLocal.Define("c")
+
+	expected := []compiler.Symbol{
+		{Name: "a", Scope: compiler.GlobalScope, Index: 0},
+		{Name: "b", Scope: compiler.FreeScope, Index: 0},
+		{Name: "c", Scope: compiler.LocalScope, Index: 0},
+	}
+
+	for _, sym := range expected {
+		result, ok := secondLocal.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+			continue
+		}
+		if result != sym {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+		}
+	}
+
+	expectedFree := []compiler.Symbol{
+		{Name: "b", Scope: compiler.LocalScope, Index: 0},
+	}
+	if len(secondLocal.FreeSymbols) != len(expectedFree) {
+		t.Fatalf("wrong number of free symbols. got=%d, want=%d",
+			len(secondLocal.FreeSymbols), len(expectedFree))
+	}
+	for i, sym := range expectedFree {
+		if secondLocal.FreeSymbols[i] != sym {
+			t.Errorf("wrong free symbol. got=%+v, want=%+v", secondLocal.FreeSymbols[i], sym)
+		}
+	}
+}
+
+func TestDefineAndResolveFunctionName(t *testing.T) {
+	global := compiler.NewSymbolTable()
+	global.DefineFunctionName("a")
+
+	expected := compiler.Symbol{Name: "a", Scope: compiler.FunctionScope, Index: 0}
+
+	result, ok := global.Resolve(expected.Name)
+	if !ok {
+		t.Fatalf("function name %s not resolvable", expected.Name)
+	}
+
+	if result != expected {
+		t.Errorf("expected %s to resolve to %+v, got=%+v", expected.Name, expected, result)
+	}
+}
@@ -0,0 +1,153 @@
+package disasm
+
+import (
+	"MyCompiler/src/code"
+	"MyCompiler/src/compiler"
+	"MyCompiler/src/disasm"
+	"MyCompiler/src/lexer"
+	"MyCompiler/src/object"
+	"MyCompiler/src/parser"
+	"bytes"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	input := `let f = fn(x) { if (x) { "yes" } else { 2 } }; f(true);`
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var out bytes.Buffer
+	disasm.Disassemble(&out, comp.Bytecode())
+
+	expected := `== constants ==
+   0 "yes"
+   1 2
+   2 <function params=1 locals=1>
+
+== main ==
+  0000 OpClosure 2 0            ; fn#2
+  0004 OpSetGlobal 0
+  0007 OpGetGlobal 0
+  0010 OpTrue
+  0011 OpCall 1
+  0013 OpPop
+
+== fn#2 (params=1, locals=1) ==
+  0000 OpGetLocal 0
+  0002 OpJumpNotTruthy 11       ; -> L0
+  0005 OpConstant 0             ; "yes"
+  0008 OpJump 14                ; -> L1
+L0:
+  0011 OpConstant 1             ; 2
+L1:
+  0014 OpReturnValue
+`
+
+	if out.String() != expected {
+		t.Errorf("wrong disassembly.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
+
+func TestMalformedInstructions(t *testing.T) {
+	ins := code.Instructions{}
+	ins = append(ins, code.Make(code.OpJump, 2)...)
+	ins = append(ins, 200)
+	ins = append(ins, code.Make(code.OpConstant, 7)...)
+	ins = append(ins, byte(code.OpJump), 0)
+
+	expected := `  0000 OpJump 2                 ; -> 0002 (not an instruction boundary)
+  0003 ERROR: invalid opcode: 200
+  0004 OpConstant 7             ; invalid constant index 7
+  0007 ERROR: truncated operands for OpJump: want 2 bytes, got 1
+`
+
+	got := disasm.Instructions(ins, []object.Object{})
+	if got != expected {
+		t.Errorf("wrong disassembly.\nwant=%q\ngot =%q", expected, got)
+	}
+}
+
+func TestWideInstructions(t *testing.T) {
+	ins := code.Instructions{}
+	ins = append(ins, code.MakeWide(code.OpJump, 12)...)
+	ins = append(ins, code.MakeWide(code.OpConstant, 0)...)
+	ins = append(ins, code.Make(code.OpPop)...)
+
+	expected := `  0000 OpWide OpJump 12         ; -> L0
+  0006 OpWide OpConstant 0      ; 42
+L0:
+  0012 OpPop
+`
+
+	got := disasm.Instructions(ins, []object.Object{&object.Integer{Value: 42}})
+	if got != expected {
+		t.Errorf("wrong disassembly.\nwant=%q\ngot =%q", expected, got)
+	}
+}
+
+func TestGraph(t *testing.T) {
+	input := `let f = fn(x) { if (x) { return 1; }; 2 }; f(true);`
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var out bytes.Buffer
+	err = disasm.Graph(&out, comp.Bytecode())
+	if err != nil {
+		t.Fatalf("graph error: %s", err)
+	}
+
+	expected := `digraph "bytecode" {
+  node [shape="box", fontname="monospace"];
+  edge [fontname="monospace", fontsize="10"];
+  subgraph "cluster_main" {
+    label="main";
+    "main_entry" [label="entry", shape="oval"];
+    "main_exit" [label="exit", shape="oval"];
+    "main_b0" [label="B0\l0000 OpClosure 2 0            ; fn#2\l0004 OpSetGlobal 0\l0007 OpGetGlobal 0\l0010 OpTrue\l0011 OpCall 1\l0013 OpPop\l"];
+    "main_entry" -> "main_b0";
+    "main_b0" -> "main_exit";
+  }
+  subgraph "cluster_fn2" {
+    label="fn#2 (params=1, locals=1)";
+    "fn2_entry" [label="entry", shape="oval"];
+    "fn2_exit" [label="exit", shape="oval"];
+    "fn2_b0" [label="B0\l0000 OpGetLocal 0\l0002 OpJumpNotTruthy 13       ; -> L0\l"];
+    "fn2_b1" [label="B1\l0005 OpConstant 0             ; 1\l0008 OpReturnValue\l"];
+    "fn2_b2" [label="B2\l0009 OpNull\l0010 OpJump 14                ; -> L1\l"];
+    "fn2_b3" [label="B3 (L0)\l0013 OpNull\l"];
+    "fn2_b4" [label="B4 (L1)\l0014 OpPop\l0015 OpConstant 1             ; 2\l0018 OpReturnValue\l"];
+    "fn2_entry" -> "fn2_b0";
+    "fn2_b0" -> "fn2_b3" [label="false"];
+    "fn2_b0" -> "fn2_b1" [label="true"];
+    "fn2_b1" -> "fn2_exit" [style="dashed"];
+    "fn2_b2" -> "fn2_b4" [label="jump"];
+    "fn2_b3" -> "fn2_b4";
+    "fn2_b4" -> "fn2_exit" [style="dashed"];
+  }
+}
+`
+	if out.String() != expected {
+		t.Errorf("wrong graph.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
+
+func TestGraphMalformed(t *testing.T) {
+	bc := &compiler.ByteCode{Instructions: code.Make(code.OpJump, 1)}
+	var out bytes.Buffer
+	err := disasm.Graph(&out, bc)
+	if err == nil || err.Error() != "main: jump to 0001, which is not an instruction boundary" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+}
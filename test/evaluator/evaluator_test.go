@@ -24,6 +24,12 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"-5 + 5 + -5 * 10", -50},
 		{"50 / 2 + 5 + -5 * 10", -20},
 		{"5 * (-5 + 10)", 25},
+		{"0xff", 255},
+		{"0o17 + 0b101", 20},
+		{"1_000_000", 1000000},
+		{"-9223372036854775808", -9223372036854775808},
+		{"-9223372036854775808 + 1", -9223372036854775807},
+		{"9223372036854775807", 9223372036854775807},
 	}
 
 	for _, tt := range tests {
@@ -32,6 +38,35 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 }
 
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1.5", "1.5"},
+		{"-2.5", "-2.5"},
+		{"1e3", "1000.0"},
+		{"2.5e-1 * 2", "0.5"},
+		{"1 + 0.5", "1.5"},
+		{"3 / 2.0", "1.5"},
+		{"10 / 4.0 * 2", "5.0"},
+		{"0.1 + 0.2", "0.30000000000000004"},
+		{"1e21 * 10", "1e+22"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		f, ok := evaluated.(*object.Float)
+		if !ok {
+			t.Errorf("input %s: object is not Float. got=%T (%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if f.Inspect() != tt.expected {
+			t.Errorf("input %s: wrong value. expected=%s, got=%s", tt.input, tt.expected, f.Inspect())
+		}
+	}
+}
+
 func TestBangOperator(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -74,6 +109,22 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == true", true},
 		{"false == (1 < 2)", false},
 		{"(1 < 2) == (1 < 2)", true},
+		{"1.5 < 2", true},
+		{"2 > 2.5", false},
+		{"1 == 1.0", true},
+		{"0.5 != 0.5", false},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"2 >= 3", false},
+		{"2.5 >= 2", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"1 && \"a\"", true},
+		{"if (false) { 1 } || false", false},
+		{"1 < 2 && 2 < 3", true},
+		{"false && (1 + true)", false},
+		{"true || (1 + true)", true},
+		{"let f = fn() { true }; f() && !f()", false},
 	}
 
 	for _, tt := range tests {
@@ -130,6 +181,9 @@ func TestErrorHandling(t *testing.T) {
 		expectedMessage string
 	}{
 		{"5 + true;", "type mismatch: INTEGER + BOOLEAN"},
+		{"5.5 + true;", "type mismatch: FLOAT + BOOLEAN"},
+		{"true && (1 + true)", "type mismatch: INTEGER + BOOLEAN"},
+		{"1 / 0.0", "division by zero"},
 		{"5 + true; 5;", "type mismatch: INTEGER + BOOLEAN"},
 		{"-true", "unknown operator: -BOOLEAN"},
 		{"true + true;", "unknown operator: BOOLEAN + BOOLEAN"},
@@ -143,6 +197,13 @@ if (10 > 1) {
 }
 `, "unknown operator: BOOLEAN + BOOLEAN"},
 		{"foo", "identifier not found: foo"},
+		{"while (1 + true) { 1 }", "type mismatch: INTEGER + BOOLEAN"},
+		{"for (;;) { -true }", "unknown operator: -BOOLEAN"},
+		{"break; 1", "break outside loop"},
+		{"if (true) { continue; }", "continue outside loop"},
+		{"let f = fn() { break; }; while (true) { f(); }", "break outside loop"},
+		{"let r = 0; while (true) { let r = r + if (true) { continue; } else { 1 }; }", "continue inside expression"},
+		{"while (true) { let x = if (true) { break; }; }", "break inside expression"},
 	}
 
 	for _, tt := range tests {
@@ -160,6 +221,37 @@ if (10 > 1) {
 	}
 }
 
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let i = 0; while (i < 5) { let i = i + 1; }; i", 5},
+		{"let s = 0; for (let i = 1; i <= 10; let i = i + 1) { let s = s + i; }; s", 55},
+		{"let s = 0; for (let i = 0; i < 10; let i = i + 1) { if (i == 3) { continue; } if (i == 6) { break; } let s = s + i; }; s", 12},
+		{"let i = 0; while (true) { let i = i + 1; if (i > 3) { break } }; i", 4},
+		{"let i = 0; for (;;) { let i = i + 1; if (i == 7) { break; } }; i", 7},
+		// continue在for循环中也会执行update
+		{"let n = 0; for (let i = 0; i < 5; let i = i + 1) { continue; let n = n + 1; }; [n, i][1]", 5},
+		// 内层循环的break不影响外层循环
+		{"let c = 0; for (let i = 0; i < 3; let i = i + 1) { for (let j = 0; true; let j = j + 1) { if (j == 2) { break; } let c = c + 1; } }; c", 6},
+		{"let f = fn(n) { for (let i = 0; true; let i = i + 1) { if (i * i >= n) { return i; } } }; f(50)", 8},
+		{"let f = fn() { while (false) { 1 } }; f()", nil},
+		// 迭代次数很多时也不会耗尽Go的调用栈
+		{"let i = 0; while (i < 100000) { let i = i + 1; }; i", 100000},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if !ok {
+			testNullObject(t, evaluated)
+		} else {
+			testIntegerObject(t, evaluated, int64(integer))
+		}
+	}
+}
+
 func TestLetStatement(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -229,6 +321,111 @@ addTwo(2);
 	testIntegerObject(t, testEval(input), 6)
 }
 
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`len("")`, 0},
+		{`len("four")`, 4},
+		{`len("你好")`, 2},
+		{`len("héllo")`, 5},
+		{`len([1, 2, 3])`, 3},
+		{`first([1, 2, 3])`, 1},
+		{`first([])`, nil},
+		{`last([1, 2, 3])`, 3},
+		{`len(1)`, "argument to `len` not supported, got INTEGER"},
+		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestStringIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`"abc"[0]`, "a"},
+		{`"héllo"[1]`, "é"},
+		{`"你好世界"[3]`, "界"},
+		{`let s = "你好"; s[len(s) - 1]`, "好"},
+		{`"你好"[2]`, nil},
+		{`"abc"[-1]`, nil},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		expected, ok := tt.expected.(string)
+		if !ok {
+			testNullObject(t, evaluated)
+			continue
+		}
+		str, ok := evaluated.(*object.String)
+		if !ok {
+			t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
+			continue
+		}
+		if str.Value != expected {
+			t.Errorf("wrong value. expected=%q, got=%q", expected, str.Value)
+		}
+	}
+}
+
+func TestTemplateLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"a${1}b"`, "a1b"},
+		{`let name = "monkey"; "hello, ${name}!"`, "hello, monkey!"},
+		{`"${1 + 2} ${1.5 * 2} ${true} ${[1, "x"]}"`, `3 3.0 true [1, x]`},
+		{`let f = fn(x) { x * 2 }; "${f(2)}${f(3)}"`, "46"},
+		{`"outer ${"inner ${1}"}"`, "outer inner 1"},
+		{`"\${x}"`, "${x}"},
+		{"`raw ${x}\\n`", "raw ${x}\\n"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		str, ok := evaluated.(*object.String)
+		if !ok {
+			t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
+			continue
+		}
+		if str.Value != tt.expected {
+			t.Errorf("wrong value. expected=%q, got=%q", tt.expected, str.Value)
+		}
+	}
+
+	evaluated := testEval(`"a ${missing} b"`)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "identifier not found: missing" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
 // region 帮助函数
 
 func testEval(input string) object.Object {
@@ -0,0 +1,292 @@
+package format
+
+import (
+	"MyCompiler/src/ast"
+	"MyCompiler/src/evaluator"
+	"MyCompiler/src/format"
+	"MyCompiler/src/lexer"
+	"MyCompiler/src/object"
+	"MyCompiler/src/parser"
+	"MyCompiler/src/token"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x=1+2*3", "let x = 1 + 2 * 3;\n"},
+		{"let x = (1 + 2) * 3;", "let x = (1 + 2) * 3;\n"},
+		{"let x = ((a - b) - (c - d))", "let x = a - b - (c - d);\n"},
+		{"a && (b || c); -(-x); !(a == b); (-a)[0]; -a[0]", "a && (b || c);\n- -x;\n!(a == b);\n(-a)[0];\n-a[0];\n"},
+		{"(fn(x) { x })(1); (a + b)(c)", "fn(x) { x }(1);\n(a + b)(c);\n"},
+		{"return   x ;", "return x;\n"},
+		{"let add = fn(a,b){a+b}", "let add = fn(a, b) { a + b };\n"},
+		{"let f = fn() {\nlet y = 1; y }", "let f = fn() {\n    let y = 1;\n    y\n};\n"},
+		{"let e = fn() {\n\n};", "let e = fn() {};\n"},
+		{"if (a) { b } else { c }", "if (a) { b } else { c }\n"},
+		{"if (a) {\nb } else { c }", "if (a) {\n    b\n} else {\n    c\n}\n"},
+		{"let a = 1;\n\n\n\nlet b = 2;\nlet c = 3;", "let a = 1;\n\nlet b = 2;\nlet c = 3;\n"},
+		{`let h = {"b": [1,2], "a": {}}`, "let h = {\"b\": [1, 2], \"a\": {}};\n"},
+		{`puts("a\tb", ` + "`raw\\n`" + `, "x${ y + 1 }z", "\${q}")`, "puts(\"a\\tb\", `raw\\n`, \"x${y + 1}z\", \"\\${q}\");\n"},
+		{"let n = -9223372036854775808 - -1", "let n = -9223372036854775808 - -1;\n"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		got := formatSource(t, tt.input)
+		if got != tt.expected {
+			t.Errorf("input %q:\nwant=%q\ngot =%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+// if后面没有分号时，下一条语句可能被当成调用或减法 这时必须保留分号
+func TestIfSemicolon(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"if (a) { 1 }\nlet x = 2;", "if (a) { 1 }\nlet x = 2;\n"},
+		{"if (a) { 1 };\n[1, 2];", "if (a) { 1 };\n[1, 2];\n"},
+		{"if (a) { 1 };\n-1;", "if (a) { 1 };\n-1;\n"},
+		{"if (a) { 1 };\n(b + 1) * 2;", "if (a) { 1 };\n(b + 1) * 2;\n"},
+		// 源码中没有分号，(b)本来就是调用
+		{"if (a) { 1 }\n(b)", "if (a) { 1 }(b);\n"},
+	}
+
+	for _, tt := range tests {
+		got := formatSource(t, tt.input)
+		if got != tt.expected {
+			t.Errorf("input %q:\nwant=%q\ngot =%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"while(i<3){let i=i+1}", "while (i < 3) { let i = i + 1; }\n"},
+		{"while (true) {\nbreak }", "while (true) {\n    break;\n}\n"},
+		{"for(let i=0;i<3;let i=i+1){puts(i)}", "for (let i = 0; i < 3; let i = i + 1) { puts(i) }\n"},
+		{"for ( ; ; ) { continue }", "for (;;) { continue; }\n"},
+		{"for (; n > 0;) { f(n); }", "for (; n > 0;) { f(n) }\n"},
+		{"for (f();;) {}", "for (f();;) {}\n"},
+	}
+
+	for _, tt := range tests {
+		got := formatSource(t, tt.input)
+		if got != tt.expected {
+			t.Errorf("input %q:\nwant=%q\ngot =%q", tt.input, tt.expected, got)
+		}
+		if twice := formatSource(t, got); twice != got {
+			t.Errorf("formatting is not idempotent for %q.\nonce=%q\ntwice=%q", tt.input, got, twice)
+		}
+	}
+}
+
+func TestLineBreaking(t *testing.T) {
+	input := `let config = {"server": {"host": "localhost", "port": 8080, "routes": ["/index", "/about"]}, "debug": true};
+let total = reduce(map(numbers, fn(n) { n * n }), 0, fn(accumulator, value) { accumulator + value });
+each(items, fn(item) { let doubled = item * 2; puts(doubled) });
+`
+	expected := `let config = {
+    "server": {
+        "host": "localhost",
+        "port": 8080,
+        "routes": ["/index", "/about"]
+    },
+    "debug": true
+};
+let total = reduce(
+    map(numbers, fn(n) { n * n }),
+    0,
+    fn(accumulator, value) { accumulator + value }
+);
+each(items, fn(item) {
+    let doubled = item * 2;
+    puts(doubled)
+});
+`
+	got := formatSource(t, input)
+	if got != expected {
+		t.Errorf("wrong output.\nwant=\n%s\ngot =\n%s", expected, got)
+	}
+	for i, line := range strings.Split(got, "\n") {
+		if len(line) > 80 {
+			t.Errorf("line %d longer than 80 columns: %q", i+1, line)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// 文件开头的注释
+
+let x = 1; // 行尾注释
+/* 块注释 */ let y = fn(a) {
+  // 函数体中的注释
+  a + 1 // 返回值
+
+  // 右花括号之前的注释
+};
+let list = [1, // 第一个
+  2];
+let z =
+  // 值之前的注释
+  5;
+// 文件末尾的注释
+`
+	expected := `// 文件开头的注释
+
+let x = 1; // 行尾注释
+/* 块注释 */ let y = fn(a) {
+    // 函数体中的注释
+    a + 1 // 返回值
+
+    // 右花括号之前的注释
+};
+let list = [
+    1, // 第一个
+    2
+];
+let z =
+    // 值之前的注释
+    5;
+// 文件末尾的注释
+`
+	got := formatSource(t, input)
+	if got != expected {
+		t.Errorf("wrong output.\nwant=\n%s\ngot =\n%s", expected, got)
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	inputs := []string{
+		"let f = fn(a, b) {\n  // c\n  if (a > b) { a } else {\n b }\n};",
+		"foo(a, /* 1 */ b, // 2\n  c) + /* 3 */ bar[ // 4\n 0];",
+		"let x = // 1\n 1 + // 2\n 2;\nlet s = `多行\n  原始字符串`;",
+		"fn// 1\n(/* 2 */ ) /* 3 */ {\n let a = 1; // 4\n}",
+		"let h = {\n\"k\": fn(x) { x }, // v\n/* end */ };\n\n\n/* 最后 */",
+		"let long = [aaaaaaaaaaaaaaa, bbbbbbbbbbbbbbbbb, ccccccccccccccccc, ddddddddddddddd, eee];",
+	}
+
+	for _, input := range inputs {
+		once := formatSource(t, input)
+		twice := formatSource(t, once)
+		if once != twice {
+			t.Errorf("formatting is not idempotent for %q.\nonce=\n%s\ntwice=\n%s", input, once, twice)
+		}
+		// 注释都保留下来
+		for _, c := range collectComments(input) {
+			if !strings.Contains(once, c) {
+				t.Errorf("comment %q lost in:\n%s", c, once)
+			}
+		}
+	}
+}
+
+func TestPreservesMeaning(t *testing.T) {
+	input := `let fib = fn(n){if(n<2){return n;};fib(n-1)+fib(n-2)};
+let apply = fn(f, xs) { if (len(xs) == 0) { [] } else { push(apply(f, rest(xs)), f(first(xs))) } };
+let h = {"a": 1 - (2 - 3), "b": -(1 + 2) * 3};
+[fib(10), apply(fn(x){x*2}, [1,2,3]), h["a"], h["b"], "${1 + 2}-${"x"}", !(true && false)]`
+
+	formatted := formatSource(t, input)
+
+	want := eval(t, input)
+	got := eval(t, formatted)
+	if got.Inspect() != want.Inspect() {
+		t.Errorf("formatted program gives a different result. want=%s, got=%s\n%s", want.Inspect(), got.Inspect(), formatted)
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	_, err := format.Source("main.mk", []byte("let x = ;\nlet = 1;"))
+	if err == nil {
+		t.Fatalf("expected error, got none")
+	}
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "main.mk:1:9: ") || !strings.HasPrefix(lines[1], "main.mk:2:5: ") {
+		t.Errorf("wrong error. got=%q", err.Error())
+	}
+}
+
+// 改写过的语法树没有源码位置 也能正确加上括号
+func TestNode(t *testing.T) {
+	program := parse(t, "let y = x * 2; f(x)")
+	ast.Apply(program, nil, func(c *ast.Cursor) bool {
+		if ident, ok := c.Node().(*ast.Identifier); ok && ident.Value == "x" {
+			c.Replace(&ast.InfixExpression{
+				Token:    token.Token{Type: token.PLUS, Literal: "+"},
+				Operator: "+",
+				Left:     &ast.Identifier{Value: "a"},
+				Right:    &ast.StringLiteral{Value: "say \"hi\"\n"},
+			})
+		}
+		return true
+	})
+
+	var out bytes.Buffer
+	err := format.Node(&out, program)
+	if err != nil {
+		t.Fatalf("format error: %s", err)
+	}
+	expected := "let y = (a + \"say \\\"hi\\\"\\n\") * 2;\nf(a + \"say \\\"hi\\\"\\n\");\n"
+	if out.String() != expected {
+		t.Errorf("wrong output.\nwant=%q\ngot =%q", expected, out.String())
+	}
+
+	bad := parser.New(lexer.New("let x 5;"))
+	err = format.Node(&out, bad.ParseProgram())
+	if err == nil || !strings.Contains(err.Error(), "syntax errors") {
+		t.Errorf("expected syntax error, got=%v", err)
+	}
+}
+
+// region 帮助函数
+
+func formatSource(t *testing.T, input string) string {
+	out, err := format.Source("", []byte(input))
+	if err != nil {
+		t.Fatalf("format error for %q: %s", input, err)
+	}
+	return string(out)
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Error()) != 0 {
+		t.Fatalf("parser errors: %v", p.Error())
+	}
+	return program
+}
+
+func eval(t *testing.T, input string) object.Object {
+	result := evaluator.Eval(parse(t, input), object.NewEnvironment())
+	if result == nil {
+		t.Fatalf("no result for %q", input)
+	}
+	return result
+}
+
+func collectComments(input string) []string {
+	l := lexer.New(input)
+	l.SetMode(lexer.KeepComments)
+	var comments []string
+	for tok := l.NextToken(); ; tok = l.NextToken() {
+		for _, c := range tok.Leading {
+			comments = append(comments, c.Text)
+		}
+		if tok.Type == token.EOF {
+			return comments
+		}
+	}
+}
+
+// endregion
@@ -3,7 +3,13 @@ package lexer
 import (
 	"MyCompiler/src/lexer"
 	"MyCompiler/src/token"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 type expectStruct []struct {
@@ -118,9 +124,23 @@ if (ten < five) {
 		"expAndFunc",
 	}
 
+	loopKeywords := testSet{
+		"while for break continue whilex",
+		expectStruct{
+			{token.WHILE, "while"},
+			{token.FOR, "for"},
+			{token.BREAK, "break"},
+			{token.CONTINUE, "continue"},
+			{token.IDENT, "whilex"},
+			{token.EOF, ""},
+		},
+		"loopKeywords",
+	}
+
 	tests := []testSet{
 		basicToken,
 		expAndFunc,
+		loopKeywords,
 	}
 	for _, test := range tests {
 		input, expects, name := test.input, test.expects, test.name
@@ -143,3 +163,528 @@ if (ten < five) {
 	}
 
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 10;\n  x + \"ab\";"
+
+	tests := []struct {
+		expectedType token.TokenType
+		pos          token.Position
+		end          token.Position
+	}{
+		{token.LET, token.Position{Offset: 0, Line: 1, Column: 1}, token.Position{Offset: 3, Line: 1, Column: 4}},
+		{token.IDENT, token.Position{Offset: 4, Line: 1, Column: 5}, token.Position{Offset: 5, Line: 1, Column: 6}},
+		{token.ASSIGN, token.Position{Offset: 6, Line: 1, Column: 7}, token.Position{Offset: 7, Line: 1, Column: 8}},
+		{token.INT, token.Position{Offset: 8, Line: 1, Column: 9}, token.Position{Offset: 10, Line: 1, Column: 11}},
+		{token.SEMICOLON, token.Position{Offset: 10, Line: 1, Column: 11}, token.Position{Offset: 11, Line: 1, Column: 12}},
+		{token.IDENT, token.Position{Offset: 14, Line: 2, Column: 3}, token.Position{Offset: 15, Line: 2, Column: 4}},
+		{token.PLUS, token.Position{Offset: 16, Line: 2, Column: 5}, token.Position{Offset: 17, Line: 2, Column: 6}},
+		{token.STRING, token.Position{Offset: 18, Line: 2, Column: 7}, token.Position{Offset: 22, Line: 2, Column: 11}},
+		{token.SEMICOLON, token.Position{Offset: 22, Line: 2, Column: 11}, token.Position{Offset: 23, Line: 2, Column: 12}},
+		{token.EOF, token.Position{Offset: 23, Line: 2, Column: 12}, token.Position{Offset: 23, Line: 2, Column: 12}},
+	}
+
+	l := lexer.New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("%d - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Pos != tt.pos {
+			t.Errorf("%d - pos wrong. expected=%+v, got=%+v", i, tt.pos, tok.Pos)
+		}
+		if tok.End != tt.end {
+			t.Errorf("%d - end wrong. expected=%+v, got=%+v", i, tt.end, tok.End)
+		}
+	}
+}
+
+func TestPositionString(t *testing.T) {
+	l := lexer.NewWithFilename("main.mk", "\n\n   foo")
+	tok := l.NextToken()
+
+	if tok.Pos.String() != "main.mk:3:4" {
+		t.Errorf("position wrongly formatted. got=%q", tok.Pos.String())
+	}
+	if (token.Position{}).String() != "<input>" {
+		t.Errorf("unknown position wrongly formatted. got=%q", token.Position{}.String())
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	input := "let 名字 = \"你好, 世界\"; café_ün + ч;"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		column          int
+	}{
+		{token.LET, "let", 1},
+		{token.IDENT, "名字", 5},
+		{token.ASSIGN, "=", 8},
+		{token.STRING, "你好, 世界", 10},
+		{token.SEMICOLON, ";", 18},
+		{token.IDENT, "café_ün", 20},
+		{token.PLUS, "+", 28},
+		{token.IDENT, "ч", 30},
+		{token.SEMICOLON, ";", 31},
+		{token.EOF, "", 32},
+	}
+
+	l := lexer.New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("%d - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("%d - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Pos.Column != tt.column {
+			t.Errorf("%d - column wrong. expected=%d, got=%d", i, tt.column, tok.Pos.Column)
+		}
+	}
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedLiteral string
+	}{
+		{"\xff", "\xff"},
+		{"\"a\xc3b\"", "\xc3"},
+	}
+
+	for _, tt := range tests {
+		tok := lexer.New(tt.input).NextToken()
+		if tok.Type != token.ILLEGAL {
+			t.Errorf("input %q - tokentype wrong. expected=ILLEGAL, got=%q", tt.input, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("input %q - literal wrong. expected=%q, got=%q", tt.input, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestComparisonAndLogicalOperators(t *testing.T) {
+	input := "a <= b >= c && d || e & |"
+
+	tests := expectStruct{
+		{token.IDENT, "a"},
+		{token.LT_EQ, "<="},
+		{token.IDENT, "b"},
+		{token.GT_EQ, ">="},
+		{token.IDENT, "c"},
+		{token.AND, "&&"},
+		{token.IDENT, "d"},
+		{token.OR, "||"},
+		{token.IDENT, "e"},
+		{token.ILLEGAL, "&"},
+		{token.ILLEGAL, "|"},
+		{token.EOF, ""},
+	}
+
+	l := lexer.New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Errorf("%d - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("%d - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	input := "1 1.5 0.25e3 2E-4 7e+2 3. 1e"
+
+	tests := expectStruct{
+		{token.INT, "1"},
+		{token.FLOAT, "1.5"},
+		{token.FLOAT, "0.25e3"},
+		{token.FLOAT, "2E-4"},
+		{token.FLOAT, "7e+2"},
+		{token.INT, "3"},
+		{token.ILLEGAL, "."},
+		{token.FLOAT, "1e"},
+		{token.EOF, ""},
+	}
+
+	l := lexer.New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Errorf("%d - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("%d - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestIntegerLiterals(t *testing.T) {
+	input := "0xFF 0o17 0b1010 1_000_000 0x_dead_BEEF 0b102 1_000.5 0o"
+
+	tests := expectStruct{
+		{token.INT, "0xFF"},
+		{token.INT, "0o17"},
+		{token.INT, "0b1010"},
+		{token.INT, "1_000_000"},
+		{token.INT, "0x_dead_BEEF"},
+		{token.INT, "0b102"},
+		{token.FLOAT, "1_000.5"},
+		{token.INT, "0o"},
+		{token.EOF, ""},
+	}
+
+	l := lexer.New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Errorf("%d - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("%d - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// 开头的注释
+let a = 1; // 行尾注释
+/* 块注释 /* 可以嵌套 */ 仍在注释里 */
+a / 2 /**/`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		leading         []string
+	}{
+		{token.LET, "let", []string{"// 开头的注释"}},
+		{token.IDENT, "a", nil},
+		{token.ASSIGN, "=", nil},
+		{token.INT, "1", nil},
+		{token.SEMICOLON, ";", nil},
+		{token.IDENT, "a", []string{"// 行尾注释", "/* 块注释 /* 可以嵌套 */ 仍在注释里 */"}},
+		{token.SLASH, "/", nil},
+		{token.INT, "2", nil},
+		{token.EOF, "", []string{"/**/"}},
+	}
+
+	for _, keep := range []bool{false, true} {
+		l := lexer.New(input)
+		if keep {
+			l.SetMode(lexer.KeepComments)
+		}
+
+		for i, tt := range tests {
+			tok := l.NextToken()
+			if tok.Type != tt.expectedType {
+				t.Fatalf("%d - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+			}
+			if tok.Literal != tt.expectedLiteral {
+				t.Errorf("%d - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+			}
+
+			expected := tt.leading
+			if !keep {
+				expected = nil
+			}
+			if len(tok.Leading) != len(expected) {
+				t.Fatalf("%d - wrong number of comments. expected=%d, got=%d", i, len(expected), len(tok.Leading))
+			}
+			for j, text := range expected {
+				if tok.Leading[j].Text != text {
+					t.Errorf("%d - comment %d wrong. expected=%q, got=%q", i, j, text, tok.Leading[j].Text)
+				}
+			}
+		}
+	}
+}
+
+func TestCommentPositions(t *testing.T) {
+	l := lexer.New("x\n  /* a\nb */ y")
+	l.SetMode(lexer.KeepComments)
+	l.NextToken()
+
+	tok := l.NextToken()
+	if len(tok.Leading) != 1 {
+		t.Fatalf("wrong number of comments. got=%d", len(tok.Leading))
+	}
+	comment := tok.Leading[0]
+	if comment.Pos.String() != "<input>:2:3" || comment.End.String() != "<input>:3:5" {
+		t.Errorf("comment position wrong. got=%s-%s", comment.Pos, comment.End)
+	}
+}
+
+func TestUnterminatedBlockComment(t *testing.T) {
+	l := lexer.New("1 /* a /* b */")
+	l.NextToken()
+
+	tok := l.NextToken()
+	if tok.Type != token.ILLEGAL {
+		t.Fatalf("tokentype wrong. expected=ILLEGAL, got=%q", tok.Type)
+	}
+	if tok.Literal != "/* a /* b */" {
+		t.Errorf("literal wrong. got=%q", tok.Literal)
+	}
+	if tok.Pos.String() != "<input>:1:3" {
+		t.Errorf("position wrong. got=%s", tok.Pos)
+	}
+	if l.NextToken().Type != token.EOF {
+		t.Errorf("expected EOF after unterminated comment")
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"a\nb"`, "a\nb"},
+		{`"a\tb"`, "a\tb"},
+		{`"a\rb"`, "a\rb"},
+		{`"a\bb"`, "a\bb"},
+		{`"a\0b"`, "a\x00b"},
+		{`"say \"hi\""`, `say "hi"`},
+		{`"back\\slash"`, `back\slash`},
+		{`"\x41\x7f\x0a"`, "A\x7f\n"},
+		{`"\u{48}\u{e9}"`, "Hé"},
+		{`"\u{4F60}\u{597d}"`, "你好"},
+		{`"\u{1F600}"`, "😀"},
+		{`"\u{10FFFF}"`, "\U0010FFFF"},
+		{`"plain 文字"`, "plain 文字"},
+		{`""`, ""},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		tok := l.NextToken()
+		if tok.Type != token.STRING {
+			t.Errorf("input %s - tokentype wrong. expected=STRING, got=%q (%q)", tt.input, tok.Type, tok.Literal)
+			continue
+		}
+		if tok.Literal != tt.expected {
+			t.Errorf("input %s - literal wrong. expected=%q, got=%q", tt.input, tt.expected, tok.Literal)
+		}
+		if next := l.NextToken(); next.Type != token.EOF {
+			t.Errorf("input %s - expected EOF after string, got=%q", tt.input, next.Type)
+		}
+	}
+}
+
+func TestInvalidStringEscapes(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedLiteral string
+		column          int
+	}{
+		{`"a\qb"`, `\q`, 3},
+		{`"\x4"`, `\x4`, 2},
+		{`"\xZZ"`, `\x`, 2},
+		{`"\x80"`, `\x80`, 2},
+		{`"\u"`, `\u`, 2},
+		{`"\u{}"`, `\u{`, 2},
+		{`"\u{41"`, `\u{41`, 2},
+		{`"\u{1234567}"`, `\u{123456`, 2},
+		{`"\u{D800}"`, `\u{D800}`, 2},
+		{`"\u{110000}"`, `\u{110000}`, 2},
+		{`"ok\q\w"`, `\q`, 4},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input + "; x")
+		tok := l.NextToken()
+		if tok.Type != token.ILLEGAL {
+			t.Errorf("input %s - tokentype wrong. expected=ILLEGAL, got=%q", tt.input, tok.Type)
+			continue
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("input %s - literal wrong. expected=%q, got=%q", tt.input, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Pos.Column != tt.column {
+			t.Errorf("input %s - column wrong. expected=%d, got=%d", tt.input, tt.column, tok.Pos.Column)
+		}
+		// 出错后从字符串之后继续
+		if next := l.NextToken(); next.Type != token.SEMICOLON {
+			t.Errorf("input %s - expected ; after string, got=%q (%q)", tt.input, next.Type, next.Literal)
+		}
+	}
+}
+
+func TestTemplateStrings(t *testing.T) {
+	input := `"a ${x + {1}[0]} b ${"c${y}"}" "\${z}" "${w}"`
+
+	tests := expectStruct{
+		{token.TEMPLATE_HEAD, "a "},
+		{token.IDENT, "x"},
+		{token.PLUS, "+"},
+		{token.LBRACE, "{"},
+		{token.INT, "1"},
+		{token.RBRACE, "}"},
+		{token.LBRACKET, "["},
+		{token.INT, "0"},
+		{token.RBRACKET, "]"},
+		{token.TEMPLATE_MIDDLE, " b "},
+		{token.TEMPLATE_HEAD, "c"},
+		{token.IDENT, "y"},
+		{token.TEMPLATE_TAIL, ""},
+		{token.TEMPLATE_TAIL, ""},
+		{token.STRING, "${z}"},
+		{token.TEMPLATE_HEAD, ""},
+		{token.IDENT, "w"},
+		{token.TEMPLATE_TAIL, ""},
+		{token.EOF, ""},
+	}
+
+	l := lexer.New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Errorf("%d - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("%d - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestTemplateStringPositions(t *testing.T) {
+	l := lexer.New(`"ab${x}cd"`)
+
+	expected := []string{"1:1-1:6", "1:6-1:7", "1:7-1:11"}
+	for i, want := range expected {
+		tok := l.NextToken()
+		got := fmt.Sprintf("%d:%d-%d:%d", tok.Pos.Line, tok.Pos.Column, tok.End.Line, tok.End.Column)
+		if got != want {
+			t.Errorf("%d - %s position wrong. expected=%s, got=%s", i, tok.Type, want, got)
+		}
+	}
+}
+
+func TestRawStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"`abc`", "abc"},
+		{"`a\\nb`", `a\nb`},
+		{"`line1\nline2`", "line1\nline2"},
+		{"`${x} \"q\"`", `${x} "q"`},
+		{"``", ""},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		tok := l.NextToken()
+		if tok.Type != token.STRING {
+			t.Errorf("input %q - tokentype wrong. expected=STRING, got=%q (%q)", tt.input, tok.Type, tok.Literal)
+			continue
+		}
+		if tok.Literal != tt.expected {
+			t.Errorf("input %q - literal wrong. expected=%q, got=%q", tt.input, tt.expected, tok.Literal)
+		}
+		if next := l.NextToken(); next.Type != token.EOF {
+			t.Errorf("input %q - expected EOF after string, got=%q", tt.input, next.Type)
+		}
+	}
+
+	l := lexer.New("let s = `abc\ndef")
+	for i := 0; i < 3; i++ {
+		l.NextToken()
+	}
+	tok := l.NextToken()
+	if tok.Type != token.ILLEGAL || tok.Literal != "`abc\ndef" {
+		t.Fatalf("expected ILLEGAL for unterminated raw string, got=%q (%q)", tok.Type, tok.Literal)
+	}
+	if tok.Pos.String() != "<input>:1:9" || tok.End.String() != "<input>:2:4" {
+		t.Errorf("position wrong. got=%s-%s", tok.Pos, tok.End)
+	}
+}
+
+func TestUnterminatedString(t *testing.T) {
+	l := lexer.New("let s = \"abc\ndef")
+	for i := 0; i < 3; i++ {
+		l.NextToken()
+	}
+
+	tok := l.NextToken()
+	if tok.Type != token.ILLEGAL {
+		t.Fatalf("tokentype wrong. expected=ILLEGAL, got=%q", tok.Type)
+	}
+	if tok.Literal != "\"abc\ndef" {
+		t.Errorf("literal wrong. got=%q", tok.Literal)
+	}
+	if tok.Pos.String() != "<input>:1:9" || tok.End.String() != "<input>:2:4" {
+		t.Errorf("position wrong. got=%s-%s", tok.Pos, tok.End)
+	}
+	if l.NextToken().Type != token.EOF {
+		t.Errorf("expected EOF after unterminated string")
+	}
+}
+
+func TestReaderMatchesString(t *testing.T) {
+	long := strings.Repeat("汉字abc", 3000)
+	inputs := []string{
+		"",
+		"let five = 5;\nlet add = fn(x, y) { x + y; };\n",
+		"let 名字 = \"你好\"; // 注释\n/* 块 /* 嵌套 */ 注释 */ 名字",
+		"\"a ${x + {1}[0]} b ${\"c${y}\"}\" `raw\nstring` 0x1F 1.5e3",
+		"let s = \"a\\qb\xff\";\n\xc3 # \"abc",
+		"let s = \"" + long + "\";\n// " + long + "\n`" + long + "`",
+		"/* 没有结束 " + long,
+	}
+
+	for _, input := range inputs {
+		var expected []token.Token
+		l := lexer.NewWithFilename("main.mk", input)
+		l.SetMode(lexer.KeepComments)
+		for tok := l.NextToken(); ; tok = l.NextToken() {
+			expected = append(expected, tok)
+			if tok.Type == token.EOF {
+				break
+			}
+		}
+
+		readers := []io.Reader{
+			strings.NewReader(input),
+			iotest.OneByteReader(strings.NewReader(input)),
+			iotest.HalfReader(strings.NewReader(input)),
+		}
+		for _, r := range readers {
+			l := lexer.NewReaderWithFilename("main.mk", r)
+			l.SetMode(lexer.KeepComments)
+			for i, want := range expected {
+				got := l.NextToken()
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("input %.40q - token %d wrong.\nwant=%+v\ngot= %+v", input, i, want, got)
+				}
+			}
+			if l.Err() != nil {
+				t.Errorf("input %.40q - unexpected error: %s", input, l.Err())
+			}
+		}
+	}
+}
+
+func TestReaderError(t *testing.T) {
+	readErr := errors.New("disk on fire")
+	r := io.MultiReader(strings.NewReader("let x"), iotest.ErrReader(readErr))
+
+	l := lexer.NewReader(r)
+	tests := expectStruct{
+		{token.LET, "let"},
+		{token.IDENT, "x"},
+		{token.EOF, ""},
+	}
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Errorf("%d - token wrong. expected=%q %q, got=%q %q", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+	if l.Err() != readErr {
+		t.Errorf("wrong error. expected=%v, got=%v", readErr, l.Err())
+	}
+}
@@ -1 +1,60 @@
 package object
+
+import (
+	"MyCompiler/src/object"
+	"testing"
+)
+
+func TestGetBuiltinByName(t *testing.T) {
+	for i, b := range object.Builtins {
+		found := object.GetBuiltinByName(b.Name)
+		if found != object.Builtins[i] {
+			t.Errorf("builtin %s not found by name", b.Name)
+		}
+		if b.Doc == "" {
+			t.Errorf("builtin %s has no documentation", b.Name)
+		}
+	}
+
+	if object.GetBuiltinByName("nope") != nil {
+		t.Errorf("expected nil for unknown builtin")
+	}
+}
+
+func TestBuiltinArity(t *testing.T) {
+	length := object.GetBuiltinByName("len")
+
+	_, err := length.Call()
+	if err == nil || err.Error() != "wrong number of arguments. got=0, want=1" {
+		t.Errorf("wrong arity error. got=%v", err)
+	}
+
+	result, err := length.Call(&object.String{Value: "abc"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if result.(*object.Integer).Value != 3 {
+		t.Errorf("wrong result. got=%s", result.Inspect())
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{1.5, "1.5"},
+		{2, "2.0"},
+		{-0.25, "-0.25"},
+		{1e21, "1e+21"},
+		{1e-7, "1e-07"},
+		{0.30000000000000004, "0.30000000000000004"},
+	}
+
+	for _, tt := range tests {
+		f := &object.Float{Value: tt.value}
+		if f.Inspect() != tt.expected {
+			t.Errorf("wrong Inspect for %v. expected=%q, got=%q", tt.value, tt.expected, f.Inspect())
+		}
+	}
+}
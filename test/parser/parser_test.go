@@ -6,6 +6,7 @@ import (
 	"MyCompiler/src/parser"
 	"MyCompiler/src/token"
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -390,6 +391,21 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 	}{
 		{"-a * b",
 			"((-a) * b)",
+		}, {
+			"a <= b == c >= d",
+			"((a <= b) == (c >= d))",
+		}, {
+			"a || b && c",
+			"(a || (b && c))",
+		}, {
+			"a && b || c && d",
+			"((a && b) || (c && d))",
+		}, {
+			"a == b && !c",
+			"((a == b) && (!c))",
+		}, {
+			"a < b + 1 || c",
+			"((a < (b + 1)) || c)",
 		}, {
 			"!-a", "(!(-a))",
 		}, {
@@ -594,6 +610,90 @@ func TestIfExpression(t *testing.T) {
 
 }
 
+func TestWhileStatement(t *testing.T) {
+	input := `while (x < y) { x; break; continue }`
+
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statement) != 1 {
+		t.Fatalf("program.Statements do not contain %d statements. got %d",
+			1, len(program.Statement))
+	}
+
+	stmt, ok := program.Statement[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statement[0] is not WhileStatement. got %T", program.Statement[0])
+	}
+	if !testInfixExpression(t, stmt.Condition, "x", "<", "y") {
+		return
+	}
+
+	if len(stmt.Body.Statements) != 3 {
+		t.Fatalf("body is not 3 statements. got %d", len(stmt.Body.Statements))
+	}
+	if _, ok := stmt.Body.Statements[1].(*ast.BreakStatement); !ok {
+		t.Errorf("Statements[1] is not BreakStatement. got %T", stmt.Body.Statements[1])
+	}
+	if _, ok := stmt.Body.Statements[2].(*ast.ContinueStatement); !ok {
+		t.Errorf("Statements[2] is not ContinueStatement. got %T", stmt.Body.Statements[2])
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	tests := []struct {
+		input     string
+		init      string
+		condition string
+		update    string
+		expected  string
+	}{
+		{"for (let i = 0; i < 10; let i = i + 1) { i }", "let i = 0;", "(i < 10)", "let i = (i + 1);",
+			"for (let i = 0; (i < 10); let i = (i + 1)) i"},
+		{"for (f(); ; g()) {}", "f()", "", "g()", "for (f(); ; g()) "},
+		{"for (;;) { break; }", "", "", "", "for (; ; ) break;"},
+		{"for (; x;) { continue; };", "", "x", "", "for (; x; ) continue;"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statement) != 1 {
+			t.Fatalf("input %q: expected 1 statement. got %d", tt.input, len(program.Statement))
+		}
+		stmt, ok := program.Statement[0].(*ast.ForStatement)
+		if !ok {
+			t.Fatalf("input %q: statement is not ForStatement. got %T", tt.input, program.Statement[0])
+		}
+
+		parts := []struct {
+			name     string
+			node     ast.Node
+			expected string
+		}{
+			{"init", stmt.Init, tt.init},
+			{"condition", stmt.Condition, tt.condition},
+			{"update", stmt.Update, tt.update},
+		}
+		for _, part := range parts {
+			got := ""
+			if part.node != nil {
+				got = part.node.String()
+			}
+			if got != part.expected {
+				t.Errorf("input %q: wrong %s. want=%q, got=%q", tt.input, part.name, part.expected, got)
+			}
+		}
+		if program.String() != tt.expected {
+			t.Errorf("input %q: wrong program. want=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
 func TestFnExpression(t *testing.T) {
 	input := `
 fn(x, y) {
@@ -716,3 +816,285 @@ func TestCallExpressionParsing(t *testing.T) {
 	testInfixExpression(t, callExp.Arguments[1], 2, "*", 3)
 	testInfixExpression(t, callExp.Arguments[2], 4, "+", 5)
 }
+
+func TestTemplateLiteralParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		values   int
+	}{
+		{`"a${x}b"`, `"a${x}b"`, 1},
+		{`"${1 + 2 * 3}"`, `"${(1 + (2 * 3))}"`, 1},
+		{`"${a}, ${fn(x) { x }(b)}!"`, `"${a}, ${fn(x)x(b)}!"`, 2},
+		{`"outer ${"inner ${x}"}"`, `"outer ${"inner ${x}"}"`, 1},
+		{`"${ {"k": 1}["k"] }"`, `"${({k:1}[k])}"`, 1},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statement[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("statement is not ast.ExpressionStatement. got=%T", program.Statement[0])
+		}
+		template, ok := stmt.Expression.(*ast.TemplateLiteral)
+		if !ok {
+			t.Fatalf("expression is not ast.TemplateLiteral. got=%T", stmt.Expression)
+		}
+		if len(template.Values) != tt.values || len(template.Strings) != tt.values+1 {
+			t.Errorf("wrong number of parts. values=%d, strings=%d", len(template.Values), len(template.Strings))
+		}
+		if template.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, template.String())
+		}
+		if template.End().Offset != len(tt.input) {
+			t.Errorf("template end wrong. expected offset %d, got=%d", len(tt.input), template.End().Offset)
+		}
+	}
+}
+
+func TestParserErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x 5;", "main.mk:1:7: expected next token to be =, got INT instead"},
+		{"let x = 1;\nlet = 2;", "main.mk:2:5: expected next token to be IDENT, got = instead"},
+		{"1 +\n  ;", "main.mk:2:3: no prefix parse function for ; found"},
+		{"99999999999999999999", "main.mk:1:1: integer literal 99999999999999999999 out of range"},
+		{"1 + 0x1_0000_0000_0000_0000", "main.mk:1:5: integer literal 0x1_0000_0000_0000_0000 out of range"},
+		{"-9223372036854775809", "main.mk:1:2: integer literal 9223372036854775809 out of range"},
+		{"0b102", "main.mk:1:1: invalid integer literal \"0b102\""},
+		{"1__000", "main.mk:1:1: invalid integer literal \"1__000\""},
+		{"0x", "main.mk:1:1: invalid integer literal \"0x\""},
+		{"1e400", "main.mk:1:1: float literal 1e400 out of range"},
+		{"let 名 = \xff;", "main.mk:1:9: invalid UTF-8 encoding: byte 0xff"},
+		{"1 + \"a\xc3\"", "main.mk:1:7: invalid UTF-8 encoding: byte 0xc3"},
+		{"1 # 2", "main.mk:1:3: illegal character \"#\""},
+		{"let x = 1e;", "main.mk:1:9: invalid float literal \"1e\""},
+		{"let s = \"a\\qb\";", "main.mk:1:11: invalid escape sequence \"\\\\q\""},
+		{"let s = \"abc", "main.mk:1:9: unterminated string literal"},
+		{"let s = \"a${x}b", "main.mk:1:14: unterminated string literal"},
+		{"let s = `abc", "main.mk:1:9: unterminated raw string literal"},
+		{"let s = \"a${}b\"", "main.mk:1:13: empty interpolation in template string"},
+		{"let s = \"a${x y}b\"", "main.mk:1:15: expected next token to be TEMPLATE_TAIL, got IDENT instead"},
+		{"1 + 2;\n/* 没有结束", "main.mk:2:1: unterminated block comment"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.NewWithFilename("main.mk", tt.input))
+		p.ParseProgram()
+
+		errors := p.Error()
+		if len(errors) == 0 {
+			t.Fatalf("input: %q, expected parser errors, got none", tt.input)
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("input: %q, wrong error. want=%q, got=%q", tt.input, tt.expected, errors[0])
+		}
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedErrors []string
+		expected       string // 部分语法树的字符串形式
+	}{
+		{
+			"let x 5;\nlet y = ;\nlet = 3;\nlet ok = 1;",
+			[]string{
+				"main.mk:1:7: expected next token to be =, got INT instead",
+				"main.mk:2:9: no prefix parse function for ; found",
+				"main.mk:3:5: expected next token to be IDENT, got = instead",
+			},
+			"<bad statement><bad statement><bad statement>let ok = 1;",
+		},
+		{
+			// 函数体中的错误不影响函数外面的代码
+			"let f = fn(x) {\n  x + ;\n  let y = 2;\n  y\n};\nlet z = 3;",
+			[]string{"main.mk:2:7: no prefix parse function for ; found"},
+			"let f = fn(x)<bad statement>let y = 2;y;let z = 3;",
+		},
+		{
+			// 缺少右括号时跳过整个if 右花括号成对跳过
+			"let f = fn(x) {\n  if (x > 1 {\n    return 1;\n  }\n  x\n};\nf(2);",
+			[]string{"main.mk:2:13: expected next token to be ), got { instead"},
+			"let f = fn(x)<bad statement>;f(2)",
+		},
+		{
+			// 出错的位置就是块的右花括号
+			"fn(x) { x + }; 1",
+			[]string{"main.mk:1:13: no prefix parse function for } found"},
+			"fn(x)<bad statement>1",
+		},
+		{
+			"if (x) { if (y) { 1 + } }\nlet z = 1;",
+			[]string{"main.mk:1:23: no prefix parse function for } found"},
+			"ifx ify <bad statement>let z = 1;",
+		},
+		{
+			// 哈希字面量的右花括号不是块的结尾
+			"let f = fn() {\n  let b = {1: };\n  let c = 2;\n};\nlet d = 1;",
+			[]string{"main.mk:2:15: no prefix parse function for } found"},
+			"let f = fn()<bad statement>let c = 2;;let d = 1;",
+		},
+		{
+			"}\nlet a = 1; ) ] let b = 2;",
+			[]string{
+				"main.mk:1:1: no prefix parse function for } found",
+				"main.mk:2:12: no prefix parse function for ) found",
+			},
+			"<bad statement>let a = 1;<bad statement>let b = 2;",
+		},
+		{
+			"let a = [1, 2;\nlet b = {1: };\nlen(a, b",
+			[]string{
+				"main.mk:1:14: expected next token to be ], got ; instead",
+				"main.mk:2:13: no prefix parse function for } found",
+				"main.mk:3:9: expected next token to be ), got EOF instead",
+			},
+			"<bad statement><bad statement><bad statement>",
+		},
+		{
+			"let f = fn(x) {\n  x\n\nlet y = 2;\n",
+			[]string{"main.mk:5:1: expected } to close block opened at 1:15, got EOF instead"},
+			"<bad statement>",
+		},
+		{
+			"1 # 2; let x = 3;",
+			[]string{"main.mk:1:3: illegal character \"#\""},
+			"1<bad statement>let x = 3;",
+		},
+		{
+			// 循环体中的错误不影响循环本身
+			"while (x) {\n  let = 1;\n  break;\n}\nlet y = 2;",
+			[]string{"main.mk:2:7: expected next token to be IDENT, got = instead"},
+			"whilex <bad statement>break;let y = 2;",
+		},
+		{
+			"while x { 1 }\nlet y = 2;",
+			[]string{"main.mk:1:7: expected next token to be (, got IDENT instead"},
+			"<bad statement>let y = 2;",
+		},
+		{
+			"for (let i = 0, i < 3) { i }\nlet y = 2;",
+			[]string{"main.mk:1:15: expected next token to be ;, got , instead"},
+			"<bad statement>let y = 2;",
+		},
+		{
+			// for头部括号中的分号不是语句的边界
+			"for (let i = 0 i < 10; i) {}\nlet y = 2;",
+			[]string{"main.mk:1:16: expected next token to be ;, got IDENT instead"},
+			"<bad statement>let y = 2;",
+		},
+		{
+			// 前面的语句留下没有闭合的圆括号 不影响后面语句的恢复
+			"let a = (1;\nlet b = ;\nlet c = 3;",
+			[]string{
+				"main.mk:1:11: expected next token to be ), got ; instead",
+				"main.mk:2:9: no prefix parse function for ; found",
+			},
+			"<bad statement><bad statement>let c = 3;",
+		},
+		{
+			// 循环关键字也是语句的开头 出错的while本身属于出错的语句，后面的for不受影响
+			"let x = 5 +\nwhile (true) { break; }\nfor (;;) { break; }",
+			[]string{"main.mk:2:1: no prefix parse function for while found"},
+			"<bad statement>for (; ; ) break;",
+		},
+		{
+			"let a = ;\nbreak;\nlet b = ;\ncontinue;",
+			[]string{
+				"main.mk:1:9: no prefix parse function for ; found",
+				"main.mk:3:9: no prefix parse function for ; found",
+			},
+			"<bad statement>break;<bad statement>continue;",
+		},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.NewWithFilename("main.mk", tt.input))
+		program := p.ParseProgram()
+
+		errors := p.Error()
+		if len(errors) != len(tt.expectedErrors) {
+			t.Errorf("input: %q, wrong number of errors. want=%d, got=%d\n%s",
+				tt.input, len(tt.expectedErrors), len(errors), strings.Join(errors, "\n"))
+			continue
+		}
+		for i, msg := range tt.expectedErrors {
+			if errors[i] != msg {
+				t.Errorf("input: %q, wrong error %d. want=%q, got=%q", tt.input, i, msg, errors[i])
+			}
+		}
+		if program.String() != tt.expected {
+			t.Errorf("input: %q, wrong program. want=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestBadStatementPositions(t *testing.T) {
+	input := "let x 5;\nlet ok = 1;\nfn() { 1 + }"
+	p := parser.New(lexer.NewWithFilename("main.mk", input))
+	program := p.ParseProgram()
+
+	bad, ok := program.Statement[0].(*ast.BadStatement)
+	if !ok {
+		t.Fatalf("statement is not ast.BadStatement. got=%T", program.Statement[0])
+	}
+	if bad.Pos().String() != "main.mk:1:1" || bad.End().String() != "main.mk:1:9" {
+		t.Errorf("wrong range. got=%s-%s", bad.Pos(), bad.End())
+	}
+
+	fn := program.Statement[2].(*ast.ExpressionStatement).Expression.(*ast.FnExpression)
+	bad, ok = fn.Body.Statements[0].(*ast.BadStatement)
+	if !ok {
+		t.Fatalf("statement is not ast.BadStatement. got=%T", fn.Body.Statements[0])
+	}
+	// 出错的部分不包括块的右花括号
+	if bad.Pos().String() != "main.mk:3:8" || bad.End().String() != "main.mk:3:12" {
+		t.Errorf("wrong range. got=%s-%s", bad.Pos(), bad.End())
+	}
+	if fn.Body.End().String() != "main.mk:3:13" {
+		t.Errorf("wrong block end. got=%s", fn.Body.End())
+	}
+}
+
+func TestNodePositions(t *testing.T) {
+	input := "let add = fn(a, b) {\n  a + b\n};\nadd(1, [2][0]);"
+
+	program := parser.New(lexer.New(input)).ParseProgram()
+	if len(program.Statement) != 2 {
+		t.Fatalf("program.Statement should contain 2 statements. got %d", len(program.Statement))
+	}
+
+	let := program.Statement[0].(*ast.LetStatement)
+	fn := let.Value.(*ast.FnExpression)
+	body := fn.Body.Statements[0].(*ast.ExpressionStatement)
+	call := program.Statement[1].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+	index := call.Arguments[1].(*ast.IndexExpression)
+
+	tests := []struct {
+		node     ast.Node
+		pos, end string
+	}{
+		{let, "<input>:1:1", "<input>:3:2"},
+		{fn, "<input>:1:11", "<input>:3:2"},
+		{body, "<input>:2:3", "<input>:2:8"},
+		{call, "<input>:4:1", "<input>:4:15"},
+		{index, "<input>:4:8", "<input>:4:14"},
+		{program, "<input>:1:1", "<input>:4:15"},
+	}
+
+	for _, tt := range tests {
+		if tt.node.Pos().String() != tt.pos {
+			t.Errorf("%s - pos wrong. want=%s, got=%s", tt.node, tt.pos, tt.node.Pos())
+		}
+		if tt.node.End().String() != tt.end {
+			t.Errorf("%s - end wrong. want=%s, got=%s", tt.node, tt.end, tt.node.End())
+		}
+	}
+}